@@ -0,0 +1,150 @@
+package bench
+
+import (
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"github.com/mayadata-io/mlogger/glog"
+	"github.com/mayadata-io/mlogger/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// scenario is a configuration of the core the cases are run against.
+type scenario struct {
+	name     string
+	level    zapcore.Level
+	encoding string
+	// noCaller disables caller reporting. The shims look the caller up
+	// whatever the configuration, so they are not run in it.
+	noCaller bool
+	parallel bool
+}
+
+var scenarios = []scenario{
+	{name: "disabled", level: zapcore.WarnLevel, encoding: "json"},
+	{name: "json", level: zapcore.DebugLevel, encoding: "json"},
+	{name: "console", level: zapcore.DebugLevel, encoding: "console"},
+	{name: "logfmt", level: zapcore.DebugLevel, encoding: "logfmt"},
+	{name: "json-nocaller", level: zapcore.DebugLevel, encoding: "json", noCaller: true},
+	{name: "json-parallel", level: zapcore.DebugLevel, encoding: "json", parallel: true},
+}
+
+// loggers are the handles a case logs through in a scenario.
+type loggers struct {
+	sugar *zap.SugaredLogger
+	typed *common.TypedLogger
+}
+
+func init() {
+	if err := zap.RegisterSink("discard", func(*url.URL) (zap.Sink, error) {
+		return discard{}, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Each case logs one record of the same shape through one API: a message,
+// a string, an int and a duration.
+
+func BenchmarkGlogInfof(b *testing.B) {
+	run(b, true, func(loggers) {
+		glog.Infof("attached volume %s of %d bytes in %v", "pvc-1", 1<<30, time.Second)
+	})
+}
+
+func BenchmarkLogrusWithFields(b *testing.B) {
+	run(b, true, func(loggers) {
+		logrus.WithFields(logrus.Fields{
+			"volume": "pvc-1",
+			"size":   1 << 30,
+			"took":   time.Second,
+		}).Info("attached volume")
+	})
+}
+
+func BenchmarkSugarInfow(b *testing.B) {
+	run(b, false, func(l loggers) {
+		l.sugar.Infow("attached volume", "volume", "pvc-1", "size", 1<<30, "took", time.Second)
+	})
+}
+
+func BenchmarkTypedInfo(b *testing.B) {
+	run(b, false, func(l loggers) {
+		l.typed.Info("attached volume",
+			common.String("volume", "pvc-1"),
+			common.Int("size", 1<<30),
+			common.Duration("took", time.Second),
+		)
+	})
+}
+
+// run runs a case in every scenario, but those without caller reporting
+// for the shims.
+func run(b *testing.B, shim bool, log func(loggers)) {
+	for _, s := range scenarios {
+		if shim && s.noCaller {
+			continue
+		}
+		s := s
+		b.Run(s.name, func(b *testing.B) {
+			l := setup(b, s)
+			b.ReportAllocs()
+			b.ResetTimer()
+			if s.parallel {
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						log(l)
+					}
+				})
+				return
+			}
+			for i := 0; i < b.N; i++ {
+				log(l)
+			}
+		})
+	}
+}
+
+// setup configures the core, and the logrus standard logger, for s.
+func setup(b *testing.B, s scenario) loggers {
+	cfg := common.NewConfig()
+	cfg.Level = zap.NewAtomicLevelAt(s.level)
+	cfg.Encoding = s.encoding
+	cfg.OutputPaths = []string{"discard:"}
+	cfg.DisableCaller = s.noCaller
+	if s.noCaller {
+		cfg.EncoderConfig.CallerKey = ""
+	}
+	// sampling would drop most of the records being measured
+	cfg.Sampling = nil
+	if err := common.Configure(cfg); err != nil {
+		b.Fatal(err)
+	}
+	logrus.SetLevel(logrus.InfoLevel)
+	if s.level > zapcore.InfoLevel {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+	logrus.SetOutput(ioutil.Discard)
+	logrus.SetFormatter(new(logrus.CoreFormatter))
+
+	if !s.noCaller {
+		return loggers{sugar: common.Typed.Sugar(), typed: common.Typed}
+	}
+	typed, err := cfg.Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return loggers{sugar: typed.Sugar(), typed: common.NewTyped(typed)}
+}
+
+// discard is a zap.Sink throwing records away, so that the cost measured
+// is that of the loggers rather than of the output.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) Sync() error                 { return nil }
+func (discard) Close() error                { return nil }
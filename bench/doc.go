@@ -0,0 +1,11 @@
+// Package bench measures the cost of logging through the glog and logrus
+// shims, the sugared common.Logger and typed fields. Its benchmarks are
+// compared with the tracked baseline in bench/baseline.txt with benchstat:
+//
+//	go test -run '^$' -bench . -benchmem -cpu 4 -count 5 ./bench > new.txt
+//	benchstat bench/baseline.txt new.txt
+//
+// The baseline is recorded with the same command; -cpu 4 runs the parallel
+// scenario on four procs. A case slower than the baseline by more than a
+// quarter, or allocating more, is a regression.
+package bench
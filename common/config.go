@@ -0,0 +1,212 @@
+package common
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ECodeKey is the key under which records carry their ecode. Unless a call
+// supplies an explicit ecode field, the caller location is encoded under it.
+const ECodeKey = "ecode"
+
+// Config describes the mlogger core built by InitLogger and shared by the
+// glog and logrus shims. It mirrors zap.Config, adding the stages of the
+// mlogger pipeline that sit between the shims and the encoder.
+type Config struct {
+	// Level is the minimum enabled logging level.
+	Level zap.AtomicLevel `json:"level" yaml:"level"`
+	// DisableCaller stops annotating records with the ecode of the caller.
+	DisableCaller bool `json:"disableCaller" yaml:"disableCaller"`
+	// DisableStacktrace stops capturing stacktraces for ErrorLevel and
+	// above.
+	DisableStacktrace bool `json:"disableStacktrace" yaml:"disableStacktrace"`
+	// DisableLogrusCore makes the loggers of the logrus shim that keep its
+	// default formatter render their entries to their Out with the logrus
+	// TextFormatter, as upstream logrus does, instead of handing them to
+	// the core. Sampling, dedup, the encoding and the sinks then do not
+	// apply to them, and errors are rendered as their message.
+	DisableLogrusCore bool `json:"disableLogrusCore" yaml:"disableLogrusCore"`
+	// Encoding sets the encoding, "json", "console" or "logfmt".
+	Encoding string `json:"encoding" yaml:"encoding"`
+	// EncoderConfig sets options for the chosen encoder.
+	EncoderConfig zapcore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
+	// KeyOrder lists the keys the logfmt encoding renders first, in order.
+	// When empty, those of the time, level, ecode and message come first.
+	KeyOrder []string `json:"keyOrder" yaml:"keyOrder"`
+	// OutputPaths is a list of URLs or file paths to write records to.
+	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
+	// ErrorOutputPaths is a list of URLs to write internal logger errors to.
+	ErrorOutputPaths []string `json:"errorOutputPaths" yaml:"errorOutputPaths"`
+	// Sampling thins out repetitive records. A nil SamplingConfig disables
+	// both sampling and rate limiting.
+	Sampling *SamplingConfig `json:"sampling" yaml:"sampling"`
+	// Dedup collapses repeated records. A nil DedupConfig disables it.
+	Dedup *DedupConfig `json:"dedup" yaml:"dedup"`
+	// Redaction masks secrets before records reach any sink. A nil
+	// RedactionConfig disables it.
+	Redaction *RedactionConfig `json:"redaction" yaml:"redaction"`
+	// NamedLevels sets the levels of named loggers, as SetNamedLevel does.
+	// When nil, the named levels in effect are kept.
+	NamedLevels map[string]zapcore.Level `json:"namedLevels" yaml:"namedLevels"`
+	// Sinks, when not empty, replace OutputPaths with a set of sinks each
+	// record is teed to, each with its own encoding, level and fields.
+	Sinks []SinkConfig `json:"sinks" yaml:"sinks"`
+	// SlogHandler, when set, receives the records in place of the encoder
+	// and OutputPaths, letting the shims emit through a slog.Handler
+	// supplied by the application. Sinks still receive them.
+	SlogHandler slog.Handler `json:"-" yaml:"-"`
+}
+
+// NewEncoderConfig returns the encoder configuration used by mayadata
+// components: time, severity, msg and the caller encoded as ecode.
+func NewEncoderConfig() zapcore.EncoderConfig {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.MessageKey = "msg"
+	cfg.LevelKey = "severity"
+	cfg.TimeKey = "time"
+	cfg.CallerKey = ECodeKey
+	cfg.NameKey = LoggerKey
+	cfg.EncodeCaller = MayaCallerEncoder
+	return cfg
+}
+
+// NewConfig returns the default mlogger configuration: JSON to stderr at
+// DebugLevel, sampling the first 100 records per key and second and every
+// 100th thereafter, with suppressed counts reported every minute, and with
+// the secrets listed by NewRedactionConfig masked.
+func NewConfig() Config {
+	redaction := NewRedactionConfig()
+	return Config{
+		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		Encoding:         "json",
+		EncoderConfig:    NewEncoderConfig(),
+		OutputPaths:      []string{"stderr"},
+		ErrorOutputPaths: []string{"stderr"},
+		Sampling: &SamplingConfig{
+			Tick:            time.Second,
+			Initial:         100,
+			Thereafter:      100,
+			SummaryInterval: time.Minute,
+		},
+		Redaction: &redaction,
+	}
+}
+
+// Build constructs a logger from the Config and Options.
+func (cfg Config) Build(opts ...zap.Option) (*zap.Logger, error) {
+	redactor, err := cfg.buildRedactor()
+	if err != nil {
+		return nil, err
+	}
+	errSink, _, err := zap.Open(cfg.ErrorOutputPaths...)
+	if err != nil {
+		return nil, err
+	}
+	core, err := cfg.buildCore(redactor, false)
+	if err != nil {
+		return nil, err
+	}
+
+	log := zap.New(core, cfg.buildOptions(errSink)...)
+	if len(opts) > 0 {
+		log = log.WithOptions(opts...)
+	}
+	return log, nil
+}
+
+// buildCore opens the configured outputs and assembles the encoder and the
+// pipeline stages in front of it. When the outputs hold resources, the core
+// returned implements io.Closer to release them. takeover is set when the
+// core is to replace the installed one; see SpoolConfig.
+func (cfg Config) buildCore(redactor *Redactor, takeover bool) (_ zapcore.Core, err error) {
+	var cs closers
+	defer func() {
+		if err != nil {
+			cs.Close()
+		}
+	}()
+
+	// Named loggers may enable levels below cfg.Level; the stage added
+	// last filters records by their logger name.
+	enab := NamedLevelEnabler(cfg.Level)
+	var cores []zapcore.Core
+	if cfg.SlogHandler != nil {
+		cores = append(cores, NewSlogCore(cfg.SlogHandler, enab))
+	} else if len(cfg.Sinks) == 0 {
+		enc, err := cfg.buildEncoder()
+		if err != nil {
+			return nil, err
+		}
+		sink, close, err := zap.Open(cfg.OutputPaths...)
+		if err != nil {
+			return nil, err
+		}
+		core := &fileCore{Core: zapcore.NewCore(enc, sink, enab), close: close}
+		cores = append(cores, core)
+		cs = append(cs, core)
+	}
+	for _, sc := range cfg.Sinks {
+		sink, closers, err := cfg.buildSink(sc, enab, takeover)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, sink)
+		cs = append(cs, closers...)
+	}
+	core := cores[0]
+	if len(cores) > 1 {
+		core = NewTee(cores...)
+	}
+	if cfg.Sampling != nil {
+		core = NewSampler(core, *cfg.Sampling)
+	}
+	if cfg.Dedup != nil {
+		core = NewDeduper(core, *cfg.Dedup)
+	}
+	if redactor != nil {
+		core = NewRedactCore(core, redactor)
+	}
+	core = NewNamedLevelCore(NewErrorCore(core), cfg.Level)
+	if len(cs) > 0 {
+		core = &closingCore{Core: core, closers: cs}
+	}
+	return core, nil
+}
+
+func (cfg Config) buildRedactor() (*Redactor, error) {
+	if cfg.Redaction == nil {
+		return nil, nil
+	}
+	return NewRedactor(*cfg.Redaction)
+}
+
+func (cfg Config) buildEncoder() (zapcore.Encoder, error) {
+	return newEncoder(cfg.Encoding, cfg.EncoderConfig, cfg.KeyOrder)
+}
+
+func newEncoder(encoding string, ec zapcore.EncoderConfig, order []string) (zapcore.Encoder, error) {
+	switch encoding {
+	case "json":
+		return zapcore.NewJSONEncoder(ec), nil
+	case "console":
+		return NewConsoleEncoder(ec), nil
+	case "logfmt":
+		return NewLogfmtEncoder(ec, order...), nil
+	}
+	return nil, fmt.Errorf("mlogger: unknown encoding %q", encoding)
+}
+
+func (cfg Config) buildOptions(errSink zapcore.WriteSyncer) []zap.Option {
+	opts := []zap.Option{zap.ErrorOutput(errSink)}
+	if !cfg.DisableCaller {
+		opts = append(opts, zap.AddCaller())
+	}
+	if !cfg.DisableStacktrace {
+		opts = append(opts, zap.AddStacktrace(zapcore.ErrorLevel))
+	}
+	return opts
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger, typically one with the
+// fields of the request being served already attached.
+func NewContext(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, or a logger
+// writing to the mlogger core with no fields when there is none.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return direct
+}
+
+// direct is Typed with sugar, for code calling it directly.
+var direct = Typed.Sugar()
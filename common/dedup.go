@@ -0,0 +1,248 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DedupConfig collapses repeated records. Records are identical when they
+// share level, logger name, message, ecode and the values of the selected
+// Fields.
+//
+// The first occurrence of a record is logged right away. Further
+// occurrences within Window are held back and, when the window ends or the
+// logger is synced, logged once as the last occurrence annotated with
+// repeat_count, first_seen and last_seen.
+type DedupConfig struct {
+	Window time.Duration `json:"window" yaml:"window"`
+	// Fields lists the field keys whose values take part in deciding
+	// whether two records are identical. Other fields are ignored.
+	Fields []string `json:"fields" yaml:"fields"`
+}
+
+// pending is the aggregate of the occurrences of one record seen during
+// its current window.
+type pending struct {
+	core      zapcore.Core
+	ent       zapcore.Entry
+	fields    []zapcore.Field
+	firstSeen time.Time
+	lastSeen  time.Time
+	windowEnd time.Time
+	repeats   int
+	timer     *time.Timer
+}
+
+// deduper holds the state shared by a dedup core and everything derived
+// from it through With.
+type deduper struct {
+	cfg DedupConfig
+
+	mu        sync.Mutex
+	records   map[string]*pending
+	lastSweep time.Time
+}
+
+// observe records an occurrence and reports whether it should be logged
+// now. Aggregates whose window has ended by now are returned for writing.
+func (d *deduper) observe(key string, core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field) (bool, []*pending) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := ent.Time
+	var expired []*pending
+	if p, ok := d.records[key]; ok && now.Before(p.windowEnd) {
+		p.ent, p.core, p.lastSeen = ent, core, now
+		p.fields = append(p.fields[:0], fields...)
+		p.repeats++
+		if p.timer == nil {
+			p.timer = time.AfterFunc(p.windowEnd.Sub(now), func() { d.flush(key, p) })
+		}
+		return false, d.sweep(now, expired)
+	} else if ok {
+		expired = d.take(key, p, expired)
+	}
+
+	d.records[key] = &pending{
+		firstSeen: now,
+		lastSeen:  now,
+		windowEnd: now.Add(d.cfg.Window),
+	}
+	return true, d.sweep(now, expired)
+}
+
+// sweep drops records whose window has ended, at most once per window, so
+// that records which never repeat do not accumulate.
+func (d *deduper) sweep(now time.Time, expired []*pending) []*pending {
+	if now.Sub(d.lastSweep) < d.cfg.Window {
+		return expired
+	}
+	d.lastSweep = now
+	for key, p := range d.records {
+		if !now.Before(p.windowEnd) {
+			expired = d.take(key, p, expired)
+		}
+	}
+	return expired
+}
+
+// take removes the record under key, adding it to expired if it has
+// occurrences left to report.
+func (d *deduper) take(key string, p *pending, expired []*pending) []*pending {
+	delete(d.records, key)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.repeats > 0 {
+		expired = append(expired, p)
+	}
+	return expired
+}
+
+// flush writes the aggregate p at the end of its window. A timer that
+// fires late, once a record has started a new window under key, finds
+// another aggregate there and leaves it alone.
+func (d *deduper) flush(key string, p *pending) {
+	d.mu.Lock()
+	var expired []*pending
+	if cur, ok := d.records[key]; ok && cur == p {
+		expired = d.take(key, p, expired)
+	}
+	d.mu.Unlock()
+	writePending(expired)
+}
+
+func (d *deduper) flushAll() error {
+	d.mu.Lock()
+	var expired []*pending
+	for key, p := range d.records {
+		expired = d.take(key, p, expired)
+	}
+	d.mu.Unlock()
+	return writePending(expired)
+}
+
+func writePending(expired []*pending) error {
+	var err error
+	for _, p := range expired {
+		fields := append(p.fields,
+			zap.Int("repeat_count", p.repeats),
+			zap.Time("first_seen", p.firstSeen),
+			zap.Time("last_seen", p.lastSeen),
+		)
+		err = multierr.Append(err, writeThrough(p.core, p.ent, fields))
+	}
+	return err
+}
+
+type dedupCore struct {
+	zapcore.Core
+	d       *deduper
+	context []zapcore.Field
+}
+
+// NewDeduper wraps core so that repeated records are collapsed according
+// to cfg.
+func NewDeduper(core zapcore.Core, cfg DedupConfig) zapcore.Core {
+	return &dedupCore{
+		Core: core,
+		d: &deduper{
+			cfg:     cfg,
+			records: make(map[string]*pending),
+		},
+	}
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	context := make([]zapcore.Field, 0, len(c.context)+len(fields))
+	context = append(context, c.context...)
+	context = append(context, fields...)
+	return &dedupCore{Core: c.Core.With(fields), d: c.d, context: context}
+}
+
+func (c *dedupCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	return ce.AddCore(ent, c)
+}
+
+func (c *dedupCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	write, expired := c.d.observe(c.key(ent, fields), c.Core, ent, fields)
+	err := writePending(expired)
+	if write {
+		err = multierr.Append(err, writeThrough(c.Core, ent, fields))
+	}
+	return err
+}
+
+func (c *dedupCore) Sync() error {
+	err := c.d.flushAll()
+	return multierr.Append(err, c.Core.Sync())
+}
+
+// key renders what makes two records identical: level, logger name,
+// message, ecode and the selected fields.
+func (c *dedupCore) key(ent zapcore.Entry, fields []zapcore.Field) string {
+	var b strings.Builder
+	b.WriteString(ent.Level.String())
+	b.WriteByte(0)
+	b.WriteString(ent.LoggerName)
+	b.WriteByte(0)
+	b.WriteString(ent.Message)
+	b.WriteByte(0)
+	if code, ok := findECode(fields); ok {
+		b.WriteString(code)
+	} else if code, ok := findECode(c.context); ok {
+		b.WriteString(code)
+	} else if ent.Caller.Defined {
+		b.WriteString(ent.Caller.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(ent.Caller.Line))
+	}
+	for _, key := range c.d.cfg.Fields {
+		b.WriteByte(0)
+		if f, ok := findField(fields, key); ok {
+			writeFieldValue(&b, f)
+		} else if f, ok := findField(c.context, key); ok {
+			writeFieldValue(&b, f)
+		}
+	}
+	return b.String()
+}
+
+func findField(fields []zapcore.Field, key string) (zapcore.Field, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Key == key {
+			return fields[i], true
+		}
+	}
+	return zapcore.Field{}, false
+}
+
+// writeFieldValue renders the value of f for a key. Numbers, booleans,
+// durations and times are rendered from their bits, which is all a key
+// needs; for times, Interface holds the location, not the value.
+func writeFieldValue(b *strings.Builder, f zapcore.Field) {
+	switch f.Type {
+	case zapcore.StringType:
+		b.WriteString(f.String)
+	case zapcore.BoolType, zapcore.DurationType, zapcore.TimeType,
+		zapcore.Float64Type, zapcore.Float32Type,
+		zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type,
+		zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type,
+		zapcore.UintptrType:
+		b.WriteString(strconv.FormatInt(f.Integer, 10))
+	case zapcore.BinaryType, zapcore.ByteStringType:
+		b.Write(f.Interface.([]byte))
+	default:
+		fmt.Fprint(b, f.Interface)
+	}
+}
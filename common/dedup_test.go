@@ -0,0 +1,64 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDedupTimeField(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(NewDeduper(obs, DedupConfig{Window: time.Hour, Fields: []string{"at"}}))
+	at := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger.Info("snapshot", zap.Time("at", at))
+	logger.Info("snapshot", zap.Time("at", at.Add(time.Minute)))
+	logger.Info("snapshot", zap.Time("at", at.Add(time.Minute)))
+	if n := logs.Len(); n != 2 {
+		t.Errorf("logged %d records, want one per time", n)
+	}
+}
+
+func TestDedupLateTimer(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	c := NewDeduper(obs, DedupConfig{Window: time.Hour}).(*dedupCore)
+	start := time.Now()
+	write := func(at time.Time) {
+		if err := c.Write(zapcore.Entry{Message: "degraded", Time: at}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(start)
+	write(start.Add(time.Minute))
+	key := c.key(zapcore.Entry{Message: "degraded"}, nil)
+	first := c.d.records[key]
+
+	// the next window starts before the timer of the first one fires
+	write(start.Add(2 * time.Hour))
+	write(start.Add(2*time.Hour + time.Minute))
+	c.d.flush(key, first)
+	if p := c.d.records[key]; p == nil || p.repeats != 1 {
+		t.Fatalf("the late timer took the aggregate of the new window: %+v", p)
+	}
+
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// each window logs its first occurrence and then its aggregate
+	if n := logs.FilterField(zap.Int("repeat_count", 1)).Len(); n != 2 || logs.Len() != 4 {
+		t.Errorf("records %v", logs.All())
+	}
+}
+
+func TestDedupLoggerName(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(NewDeduper(obs, DedupConfig{Window: time.Hour}))
+	for _, name := range []string{"pool", "volume", "pool"} {
+		logger.Named(name).Info("degraded")
+	}
+	if n := logs.Len(); n != 2 {
+		t.Errorf("logged %d records, want one per logger: %v", n, logs.All())
+	}
+}
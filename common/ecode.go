@@ -0,0 +1,79 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ECodeInfo describes a registered ecode.
+type ECodeInfo struct {
+	// Reason names the condition in CamelCase, as the reason of a
+	// Kubernetes event. When empty, it is derived from the ecode.
+	Reason string
+	// UserVisible marks records the users of the product are to see, such
+	// as through Kubernetes events, rather than only its developers.
+	UserVisible bool
+	// Description documents the condition.
+	Description string
+}
+
+var (
+	ecodesMu sync.RWMutex
+	ecodes   = map[string]ECodeInfo{}
+)
+
+// RegisterECode registers code with info. Packages register the ecodes
+// they log with explicitly, typically from init.
+func RegisterECode(code string, info ECodeInfo) error {
+	if code == "" {
+		return fmt.Errorf("mlogger: empty ecode")
+	}
+	if info.Reason == "" {
+		info.Reason = ReasonOf(code)
+	}
+	ecodesMu.Lock()
+	defer ecodesMu.Unlock()
+	if _, ok := ecodes[code]; ok {
+		return fmt.Errorf("mlogger: ecode %q already registered", code)
+	}
+	ecodes[code] = info
+	return nil
+}
+
+// LookupECode returns the information registered for code.
+func LookupECode(code string) (ECodeInfo, bool) {
+	ecodesMu.RLock()
+	defer ecodesMu.RUnlock()
+	info, ok := ecodes[code]
+	return info, ok
+}
+
+// ECodes returns the registered ecodes.
+func ECodes() []string {
+	ecodesMu.RLock()
+	defer ecodesMu.RUnlock()
+	codes := make([]string, 0, len(ecodes))
+	for code := range ecodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// ReasonOf derives a reason from an ecode by joining its components in
+// CamelCase: "pool.create.failed" gives "PoolCreateFailed".
+func ReasonOf(code string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(code, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r, n := utf8.DecodeRuneInString(part)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(part[n:])
+	}
+	return b.String()
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
 	"strconv"
 	"strings"
@@ -28,8 +29,56 @@ func PackagePath(ec zapcore.EntryCaller, levels int) string {
 		}
 	}
 
-	caller := ec.File[idx+1:]
-	caller += ":"
-	caller += strconv.Itoa(ec.Line)
-	return strings.Replace(caller, "/", ".", -1)
+	// Build the result in one allocation; this runs for every record.
+	file := ec.File[idx+1:]
+	var line [20]byte
+	var b strings.Builder
+	b.Grow(len(file) + 1 + len(line))
+	for i := 0; i < len(file); i++ {
+		if file[i] == '/' {
+			b.WriteByte('.')
+		} else {
+			b.WriteByte(file[i])
+		}
+	}
+	b.WriteByte(':')
+	b.Write(strconv.AppendInt(line[:0], int64(ec.Line), 10))
+	return b.String()
+}
+
+// consoleEncoder is zap's console encoder, except that object references
+// are rendered as namespace/name instead of a JSON object.
+type consoleEncoder struct {
+	zapcore.Encoder
+}
+
+// NewConsoleEncoder creates the human-readable encoder used for the
+// "console" encoding.
+func NewConsoleEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return consoleEncoder{zapcore.NewConsoleEncoder(cfg)}
+}
+
+func (c consoleEncoder) Clone() zapcore.Encoder {
+	return consoleEncoder{c.Encoder.Clone()}
+}
+
+func (c consoleEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if ref, ok := obj.(ObjectRef); ok {
+		c.Encoder.AddString(key, ref.String())
+		return nil
+	}
+	return c.Encoder.AddObject(key, obj)
+}
+
+func (c consoleEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	rendered := fields
+	for i := range fields {
+		if ref, ok := fields[i].Interface.(ObjectRef); ok && fields[i].Type == zapcore.ObjectMarshalerType {
+			if &rendered[0] == &fields[0] {
+				rendered = append([]zapcore.Field(nil), fields...)
+			}
+			rendered[i] = zapcore.Field{Key: fields[i].Key, Type: zapcore.StringType, String: ref.String()}
+		}
+	}
+	return c.Encoder.EncodeEntry(ent, rendered)
 }
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ECoder is implemented by errors that know the ecode they should be
+// logged with.
+type ECoder interface {
+	ECode() string
+}
+
+// Error returns a field logging err under "error" with its chain, type
+// names and stacktrace. See NamedError.
+func Error(err error) zapcore.Field {
+	return NamedError("error", err)
+}
+
+// NamedError returns a field logging err under key as an object holding
+// its message and type, the errors it wraps when there are any, and the
+// %+v rendering of errors carrying a stacktrace, such as those from
+// github.com/pkg/errors. A nil err is skipped.
+//
+// The core renders every error field this way, so plain zap.Error fields
+// and logrus WithError entries are encoded alike; when the chain holds an
+// ECoder and the record has no ecode of its own, its ecode is used.
+func NamedError(key string, err error) zapcore.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.Object(key, richError{err: err})
+}
+
+// richError encodes an error for NamedError. When r is set, the messages
+// and stacktrace are masked by it; the redaction core sets it.
+type richError struct {
+	err error
+	r   *Redactor
+}
+
+func (e richError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("message", e.mask(e.err.Error()))
+	enc.AddString("type", fmt.Sprintf("%T", e.err))
+	if chain := unwrapChain(e.err); len(chain) > 1 {
+		enc.AddArray("chain", maskedChain{chain, e.r})
+	}
+	if verbose := stacktraceOf(e.err); verbose != "" {
+		enc.AddString("stacktrace", e.mask(verbose))
+	}
+	return nil
+}
+
+func (e richError) mask(s string) string {
+	if e.r == nil {
+		return s
+	}
+	return e.r.Message(s)
+}
+
+// errorChain lists an error and everything it wraps, depth first.
+type errorChain []error
+
+// maskedChain encodes an errorChain for richError.
+type maskedChain struct {
+	chain errorChain
+	r     *Redactor
+}
+
+func (c maskedChain) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, err := range c.chain {
+		enc.AppendObject(chainLink{err, c.r})
+	}
+	return nil
+}
+
+type chainLink struct {
+	err error
+	r   *Redactor
+}
+
+func (l chainLink) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("message", richError{l.err, l.r}.mask(l.err.Error()))
+	enc.AddString("type", fmt.Sprintf("%T", l.err))
+	return nil
+}
+
+// maxChain bounds the walk of error chains, which may be cyclic.
+const maxChain = 32
+
+// unwrapChain flattens err and the errors it wraps through Unwrap() error
+// and Unwrap() []error, as produced by fmt.Errorf and errors.Join.
+func unwrapChain(err error) errorChain {
+	var chain errorChain
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil || len(chain) >= maxChain {
+			return
+		}
+		chain = append(chain, err)
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(u.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, e := range u.Unwrap() {
+				walk(e)
+			}
+		case interface{ Cause() error }:
+			walk(u.Cause())
+		}
+	}
+	walk(err)
+	return chain
+}
+
+// stacktraceOf returns the %+v rendering of the first error in the chain
+// of err that formats itself with more detail than its message, or "".
+func stacktraceOf(err error) string {
+	for _, e := range unwrapChain(err) {
+		if _, ok := e.(fmt.Formatter); !ok {
+			continue
+		}
+		if verbose := fmt.Sprintf("%+v", e); verbose != e.Error() {
+			return verbose
+		}
+	}
+	return ""
+}
+
+// ECodeOf returns the ecode of the outermost error in the chain of err
+// implementing ECoder.
+func ECodeOf(err error) (string, bool) {
+	for _, e := range unwrapChain(err) {
+		if c, ok := e.(ECoder); ok {
+			if code := c.ECode(); code != "" {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+// NewErrorCore returns a core rendering the error fields of records with
+// NamedError, taking the ecode of a record from its errors when it has
+// none, and dropping the caller of records carrying an explicit ecode so
+// that it is not encoded twice.
+func NewErrorCore(core zapcore.Core) zapcore.Core {
+	return &errorCore{Core: core}
+}
+
+type errorCore struct {
+	zapcore.Core
+	// ecode reports whether an ecode was added with With.
+	ecode bool
+}
+
+func (c *errorCore) With(fields []zapcore.Field) zapcore.Core {
+	fields, ecode := enrichErrors(fields, c.ecode)
+	return &errorCore{Core: c.Core.With(fields), ecode: ecode}
+}
+
+func (c *errorCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *errorCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	fields, ecode := enrichErrors(fields, c.ecode)
+	if ecode {
+		ent.Caller = zapcore.EntryCaller{}
+	}
+	return writeThrough(c.Core, ent, fields)
+}
+
+// enrichErrors rewrites the error fields in fields, appending the ecode of
+// the first error carrying one unless hasECode is set or fields hold an
+// ecode already. It reports whether the result has an ecode. fields is
+// copied before it is modified.
+func enrichErrors(fields []zapcore.Field, hasECode bool) ([]zapcore.Field, bool) {
+	if _, ok := findECode(fields); ok {
+		hasECode = true
+	}
+	out := fields
+	var code string
+	for i, f := range fields {
+		if f.Type != zapcore.ErrorType {
+			continue
+		}
+		err, ok := f.Interface.(error)
+		if !ok {
+			continue
+		}
+		if &out[0] == &fields[0] {
+			out = make([]zapcore.Field, len(fields), len(fields)+1)
+			copy(out, fields)
+		}
+		out[i] = NamedError(f.Key, err)
+		if code == "" && !hasECode {
+			code, _ = ECodeOf(err)
+		}
+	}
+	if code != "" {
+		out = append(out, zap.String(ECodeKey, code))
+		hasECode = true
+	}
+	return out, hasECode
+}
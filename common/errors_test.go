@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// buildToFile builds a logger from cfg writing to a file, and returns it
+// with a function closing it and returning what it wrote.
+func buildToFile(t *testing.T, cfg Config) (*zap.Logger, func() string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "mlogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "out.log")
+	cfg.OutputPaths = []string{path}
+	logger, err := cfg.Build()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return logger, func() string {
+		defer os.RemoveAll(dir)
+		logger.Sync()
+		logger.Core().(interface{ Close() error }).Close()
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+// configureToFile installs cfg writing to a file, and returns a function
+// syncing the global logger and returning what it wrote. The caller
+// restores the default configuration.
+func configureToFile(t *testing.T, cfg Config) func() string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "mlogger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "out.log")
+	cfg.OutputPaths = []string{path}
+	if err := Configure(cfg); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() string {
+		defer os.RemoveAll(dir)
+		Logger.Sync()
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+// verboseError formats with a stacktrace holding its secret under %+v.
+type verboseError struct {
+	msg string
+}
+
+func (e verboseError) Error() string { return e.msg }
+
+func (e verboseError) Format(s fmt.State, verb rune) {
+	fmt.Fprint(s, e.msg)
+	if s.Flag('+') {
+		fmt.Fprint(s, "\ncalled with Bearer stack.secret\n\tmain.go:12")
+	}
+}
+
+func TestErrorRedaction(t *testing.T) {
+	logger, output := buildToFile(t, NewConfig())
+	cause := verboseError{"token rejected"}
+	err := fmt.Errorf("auth failed with Bearer abc.def: %w", cause)
+	logger.Error("login failed", zap.Error(err))
+	logger.With(Error(err)).Info("retrying")
+	out := output()
+
+	for _, secret := range []string{"abc.def", "stack.secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%s logged in clear: %s", secret, out)
+		}
+	}
+	for _, want := range []string{
+		`"error":{"message":"auth failed with [REDACTED]: token rejected"`,
+		`"chain":[{"message":"auth failed with [REDACTED]: token rejected"`,
+		`"stacktrace":"token rejected\ncalled with [REDACTED]\n\tmain.go:12"`,
+	} {
+		if strings.Count(out, want) != 2 {
+			t.Errorf("want %s in both records: %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Typed is Logger without the sugar, for hot paths: it takes strongly typed
+// fields instead of interface{} key/value pairs, so enabled records cost as
+// few allocations as zap itself, none beyond encoding them and copying the
+// fields, and disabled ones none at all.
+//
+//	common.Typed.Info("volume attached",
+//		common.String("volume", name),
+//		common.Int64("size", size),
+//		common.Duration("took", time.Since(start)),
+//	)
+var Typed = NewTyped(Logger.Desugar().WithOptions(zap.AddCallerSkip(-1)))
+
+// TypedLogger logs typed fields through a zap.Logger. Unlike those of the
+// zap.Logger, its logging methods do not move the fields of a call to the
+// heap unless the record is logged, so that calls at disabled levels do
+// not allocate.
+type TypedLogger struct {
+	// base reports the caller of the logging methods, check that of
+	// Check.
+	base  *zap.Logger
+	check *zap.Logger
+}
+
+// NewTyped returns a TypedLogger logging through logger.
+func NewTyped(logger *zap.Logger) *TypedLogger {
+	return &TypedLogger{
+		base:  logger.WithOptions(zap.AddCallerSkip(2)),
+		check: logger.WithOptions(zap.AddCallerSkip(1)),
+	}
+}
+
+func (l *TypedLogger) Debug(msg string, fields ...Field)  { l.log(zapcore.DebugLevel, msg, fields) }
+func (l *TypedLogger) Info(msg string, fields ...Field)   { l.log(zapcore.InfoLevel, msg, fields) }
+func (l *TypedLogger) Warn(msg string, fields ...Field)   { l.log(zapcore.WarnLevel, msg, fields) }
+func (l *TypedLogger) Error(msg string, fields ...Field)  { l.log(zapcore.ErrorLevel, msg, fields) }
+func (l *TypedLogger) DPanic(msg string, fields ...Field) { l.log(zapcore.DPanicLevel, msg, fields) }
+func (l *TypedLogger) Panic(msg string, fields ...Field)  { l.log(zapcore.PanicLevel, msg, fields) }
+func (l *TypedLogger) Fatal(msg string, fields ...Field)  { l.log(zapcore.FatalLevel, msg, fields) }
+
+// log writes a record with a copy of fields, which keeps the compiler
+// from allocating them at the call site whatever the level.
+func (l *TypedLogger) log(level zapcore.Level, msg string, fields []Field) {
+	if ce := l.base.Check(level, msg); ce != nil {
+		ce.Write(append([]Field(nil), fields...)...)
+	}
+}
+
+// Check returns a CheckedEntry if a record at level is logged. Fields are
+// then added with its Write method.
+func (l *TypedLogger) Check(level zapcore.Level, msg string) *zapcore.CheckedEntry {
+	return l.check.Check(level, msg)
+}
+
+// With returns a child logger adding fields to its records.
+func (l *TypedLogger) With(fields ...Field) *TypedLogger {
+	return &TypedLogger{base: l.base.With(fields...), check: l.check.With(fields...)}
+}
+
+// Named returns a child logger for the subsystem name; see common.Named.
+func (l *TypedLogger) Named(name string) *TypedLogger {
+	return &TypedLogger{base: l.base.Named(name), check: l.check.Named(name)}
+}
+
+// Sugar returns the sugared logger logging through the same core.
+func (l *TypedLogger) Sugar() *zap.SugaredLogger {
+	return l.check.WithOptions(zap.AddCallerSkip(-1)).Sugar()
+}
+
+// Core returns the core of the logger.
+func (l *TypedLogger) Core() zapcore.Core {
+	return l.base.Core()
+}
+
+// Sync flushes the buffered records.
+func (l *TypedLogger) Sync() error {
+	return l.base.Sync()
+}
+
+// Field is a typed key/value pair for Typed.
+type Field = zapcore.Field
+
+// String constructs a field carrying a string.
+func String(key string, val string) Field {
+	return zap.String(key, val)
+}
+
+// Strings constructs a field carrying a slice of strings.
+func Strings(key string, val []string) Field {
+	return zap.Strings(key, val)
+}
+
+// Int constructs a field carrying an int.
+func Int(key string, val int) Field {
+	return zap.Int(key, val)
+}
+
+// Int64 constructs a field carrying an int64.
+func Int64(key string, val int64) Field {
+	return zap.Int64(key, val)
+}
+
+// Uint64 constructs a field carrying a uint64.
+func Uint64(key string, val uint64) Field {
+	return zap.Uint64(key, val)
+}
+
+// Float64 constructs a field carrying a float64.
+func Float64(key string, val float64) Field {
+	return zap.Float64(key, val)
+}
+
+// Bool constructs a field carrying a bool.
+func Bool(key string, val bool) Field {
+	return zap.Bool(key, val)
+}
+
+// Duration constructs a field carrying a time.Duration.
+func Duration(key string, val time.Duration) Field {
+	return zap.Duration(key, val)
+}
+
+// Time constructs a field carrying a time.Time.
+func Time(key string, val time.Time) Field {
+	return zap.Time(key, val)
+}
+
+// Err constructs a field carrying err under "error". Unlike Error, it
+// defers building the rich rendering of err to the core, so it costs
+// nothing when the record is not logged.
+func Err(err error) Field {
+	return zap.Error(err)
+}
+
+// NamedErr is Err under key.
+func NamedErr(key string, err error) Field {
+	return zap.NamedError(key, err)
+}
+
+// Object constructs a field encoding val as a nested object. KObjField is
+// the field for Kubernetes object references.
+func Object(key string, val zapcore.ObjectMarshaler) Field {
+	return zap.Object(key, val)
+}
+
+// Any constructs a field from an arbitrary value, choosing the cheapest
+// encoding it can; it is the escape hatch for types without a constructor.
+func Any(key string, val interface{}) Field {
+	return zap.Any(key, val)
+}
+
+// ECode constructs a field setting the ecode of a record in place of its
+// caller.
+func ECode(code string) Field {
+	return zap.String(ECodeKey, code)
+}
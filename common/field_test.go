@@ -0,0 +1,57 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTypedDisabledAllocs(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := NewTyped(zap.New(obs))
+	err := errors.New("timeout")
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.Debug("attached volume",
+			String("volume", "pvc-1"),
+			Int64("size", 1<<30),
+			Duration("took", time.Second),
+			Err(err),
+		)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per disabled call, want 0", allocs)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("logged %v", logs.All())
+	}
+}
+
+func TestTypedCaller(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := NewTyped(zap.New(obs, zap.AddCaller())).Named("pool").With(String("pool", "a"))
+	logger.Info("created", Int("replicas", 3))
+	if ce := logger.Check(zapcore.InfoLevel, "checked"); ce != nil {
+		ce.Write()
+	}
+	logger.Sugar().Info("sugared")
+
+	for _, e := range logs.All() {
+		if !strings.HasSuffix(e.Caller.File, "field_test.go") {
+			t.Errorf("%s: caller %v, want the test", e.Message, e.Caller)
+		}
+		if e.LoggerName != "pool" || e.ContextMap()["pool"] != "a" {
+			t.Errorf("%s: logger %q, fields %v", e.Message, e.LoggerName, e.ContextMap())
+		}
+	}
+	if logs.Len() != 3 {
+		t.Errorf("logged %d records, want 3", logs.Len())
+	}
+	if got := logs.FilterMessage("created").All(); len(got) != 1 || got[0].ContextMap()["replicas"] != int64(3) {
+		t.Errorf("records %v", got)
+	}
+}
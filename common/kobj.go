@@ -0,0 +1,138 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ObjectKey is the key under which KObj and KRef references are logged by
+// the WithObject helpers.
+const ObjectKey = "object"
+
+// KMetadata is the part of a Kubernetes object's metadata needed to refer
+// to it. Every type embedding metav1.ObjectMeta satisfies it.
+type KMetadata interface {
+	GetName() string
+	GetNamespace() string
+}
+
+// ObjectRef identifies a Kubernetes object in log records. The JSON encoder
+// renders it as an object, the console encoder as namespace/name.
+type ObjectRef struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	UID       string `json:"uid,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+// KObj returns a reference to obj. The UID and kind are included when obj
+// exposes them through GetUID and GetObjectKind; objects whose TypeMeta is
+// empty, as is usual for typed clients, are given the name of their Go
+// type as kind.
+func KObj(obj KMetadata) ObjectRef {
+	if obj == nil {
+		return ObjectRef{}
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return ObjectRef{}
+	}
+	return ObjectRef{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		UID:       objectUID(v),
+		Kind:      objectKind(v),
+	}
+}
+
+// KRef returns a reference to the object with the given namespace and name,
+// for when only those are at hand.
+func KRef(namespace, name string) ObjectRef {
+	return ObjectRef{Namespace: namespace, Name: name}
+}
+
+// String returns namespace/name, or just name for cluster-scoped objects.
+func (ref ObjectRef) String() string {
+	if ref.Namespace == "" {
+		return ref.Name
+	}
+	return ref.Namespace + "/" + ref.Name
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (ref ObjectRef) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if ref.Namespace != "" {
+		enc.AddString("namespace", ref.Namespace)
+	}
+	enc.AddString("name", ref.Name)
+	if ref.UID != "" {
+		enc.AddString("uid", ref.UID)
+	}
+	if ref.Kind != "" {
+		enc.AddString("kind", ref.Kind)
+	}
+	return nil
+}
+
+// KObjField returns a field holding a reference to obj under ObjectKey.
+func KObjField(obj KMetadata) zap.Field {
+	return zap.Object(ObjectKey, KObj(obj))
+}
+
+// WithObject adds a reference to obj to logger's context.
+func WithObject(logger *zap.SugaredLogger, obj KMetadata) *zap.SugaredLogger {
+	return logger.Desugar().With(KObjField(obj)).Sugar()
+}
+
+// objectUID calls GetUID, whose result is a named string type in
+// apimachinery, without depending on it.
+func objectUID(v reflect.Value) string {
+	uid := callNoArgs(v, "GetUID")
+	if !uid.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(uid.Interface())
+}
+
+// objectKind returns the kind recorded in the object's TypeMeta, falling
+// back to the name of its Go type.
+func objectKind(v reflect.Value) string {
+	if kind := fieldString(callNoArgs(callNoArgs(v, "GetObjectKind"), "GroupVersionKind"), "Kind"); kind != "" {
+		return kind
+	}
+	return reflect.Indirect(v).Type().Name()
+}
+
+// callNoArgs calls the named method of v if it takes no arguments and
+// returns a single non-nil value, and returns that value.
+func callNoArgs(v reflect.Value, method string) reflect.Value {
+	if !v.IsValid() {
+		return v
+	}
+	m := v.MethodByName(method)
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return reflect.Value{}
+	}
+	out := m.Call(nil)[0]
+	switch out.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if out.IsNil() {
+			return reflect.Value{}
+		}
+	}
+	return out
+}
+
+// fieldString returns the named string field of the struct v.
+func fieldString(v reflect.Value, field string) string {
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName(field); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+type testUID string
+
+type testGVK struct {
+	Group, Version, Kind string
+}
+
+// testTypeMeta mimics the ObjectKind of apimachinery's TypeMeta.
+type testTypeMeta struct {
+	kind string
+}
+
+func (m *testTypeMeta) GroupVersionKind() testGVK { return testGVK{Kind: m.kind} }
+
+type testPod struct {
+	testTypeMeta
+	name, namespace string
+	uid             testUID
+}
+
+func (p *testPod) GetName() string              { return p.name }
+func (p *testPod) GetNamespace() string         { return p.namespace }
+func (p *testPod) GetUID() testUID              { return p.uid }
+func (p *testPod) GetObjectKind() *testTypeMeta { return &p.testTypeMeta }
+
+func TestKObj(t *testing.T) {
+	var nilPod *testPod
+	for _, tt := range []struct {
+		obj  KMetadata
+		want ObjectRef
+	}{
+		{nil, ObjectRef{}},
+		{nilPod, ObjectRef{}},
+		{&testPod{name: "a", namespace: "ns", uid: "u1"}, ObjectRef{Namespace: "ns", Name: "a", UID: "u1", Kind: "testPod"}},
+		{&testPod{testTypeMeta: testTypeMeta{kind: "Pod"}, name: "a"}, ObjectRef{Name: "a", Kind: "Pod"}},
+	} {
+		if got := KObj(tt.obj); got != tt.want {
+			t.Errorf("KObj(%#v) = %#v, want %#v", tt.obj, got, tt.want)
+		}
+	}
+	if ref := KRef("ns", "a"); ref != (ObjectRef{Namespace: "ns", Name: "a"}) || ref.String() != "ns/a" {
+		t.Errorf("KRef = %#v, %s", ref, ref)
+	}
+	if s := KRef("", "node-1").String(); s != "node-1" {
+		t.Errorf("cluster-scoped reference renders as %q", s)
+	}
+}
+
+func TestObjectEncoding(t *testing.T) {
+	pod := &testPod{testTypeMeta: testTypeMeta{kind: "Pod"}, name: "a", namespace: "ns", uid: "u1"}
+	for encoding, want := range map[string]string{
+		"json":    `"object":{"namespace":"ns","name":"a","uid":"u1","kind":"Pod"}`,
+		"console": `"object": "ns/a"`,
+	} {
+		cfg := NewConfig()
+		cfg.Encoding = encoding
+		logger, output := buildToFile(t, cfg)
+		WithObject(logger.Sugar(), pod).Info("scheduled")
+		logger.Info("deleted", KObjField(pod))
+		out := output()
+		if n := strings.Count(out, want); n != 2 {
+			t.Errorf("%s: want %s in both records: %s", encoding, want, out)
+		}
+	}
+}
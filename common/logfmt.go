@@ -0,0 +1,472 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtPair is a key and its rendered value.
+type logfmtPair struct {
+	key, value string
+}
+
+// logfmtEncoder renders records as logfmt: key=value pairs separated by
+// spaces, with values quoted when they need to be. Objects are flattened
+// into dotted keys, and arrays into keys suffixed with their indexes, so
+// that every value is a scalar. Empty objects and arrays are rendered as
+// key={} and key=[].
+type logfmtEncoder struct {
+	cfg *zapcore.EncoderConfig
+	// order lists the keys rendered first, in order.
+	order []string
+	// pairs holds the fields added with With.
+	pairs []logfmtPair
+	// prefix is prepended to the keys added, ending with a dot inside a
+	// namespace or an object.
+	prefix string
+}
+
+// NewLogfmtEncoder creates the encoder used for the "logfmt" encoding. The
+// pairs whose keys are listed in order come first, in that order; when
+// order is empty, those of the time, level, caller and message, which
+// NewEncoderConfig names time, severity, ecode and msg. The stacktrace
+// comes last.
+func NewLogfmtEncoder(cfg zapcore.EncoderConfig, order ...string) zapcore.Encoder {
+	if len(order) == 0 {
+		for _, key := range []string{cfg.TimeKey, cfg.LevelKey, cfg.CallerKey, cfg.MessageKey} {
+			if key != "" {
+				order = append(order, key)
+			}
+		}
+	}
+	return &logfmtEncoder{cfg: &cfg, order: order}
+}
+
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := *e
+	clone.pairs = append([]logfmtPair(nil), e.pairs...)
+	return &clone
+}
+
+func (e *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := &logfmtEncoder{cfg: e.cfg}
+	if e.cfg.TimeKey != "" {
+		final.encodeWith(e.cfg.TimeKey, func(arr zapcore.ArrayEncoder) { arr.AppendTime(ent.Time) })
+	}
+	if e.cfg.LevelKey != "" && e.cfg.EncodeLevel != nil {
+		final.encodeWith(e.cfg.LevelKey, func(arr zapcore.ArrayEncoder) { e.cfg.EncodeLevel(ent.Level, arr) })
+	}
+	if ent.LoggerName != "" && e.cfg.NameKey != "" {
+		final.encodeWith(e.cfg.NameKey, func(arr zapcore.ArrayEncoder) {
+			if e.cfg.EncodeName != nil {
+				e.cfg.EncodeName(ent.LoggerName, arr)
+			} else {
+				arr.AppendString(ent.LoggerName)
+			}
+		})
+	}
+	if ent.Caller.Defined && e.cfg.CallerKey != "" && e.cfg.EncodeCaller != nil {
+		final.encodeWith(e.cfg.CallerKey, func(arr zapcore.ArrayEncoder) { e.cfg.EncodeCaller(ent.Caller, arr) })
+	}
+	if e.cfg.MessageKey != "" {
+		final.add(e.cfg.MessageKey, ent.Message)
+	}
+	final.pairs = append(final.pairs, e.pairs...)
+	final.prefix = e.prefix
+	for _, f := range fields {
+		f.AddTo(final)
+	}
+	if ent.Stack != "" && e.cfg.StacktraceKey != "" {
+		final.pairs = append(final.pairs, logfmtPair{e.cfg.StacktraceKey, quoteLogfmt(ent.Stack)})
+	}
+
+	buf := logfmtPool.Get()
+	done := make([]bool, len(final.pairs))
+	for _, key := range e.order {
+		for i, p := range final.pairs {
+			if !done[i] && p.key == key {
+				writeLogfmtPair(buf, p)
+				done[i] = true
+			}
+		}
+	}
+	for i, p := range final.pairs {
+		if !done[i] {
+			writeLogfmtPair(buf, p)
+		}
+	}
+	if e.cfg.LineEnding != "" {
+		buf.AppendString(e.cfg.LineEnding)
+	} else {
+		buf.AppendString(zapcore.DefaultLineEnding)
+	}
+	return buf, nil
+}
+
+func writeLogfmtPair(buf *buffer.Buffer, p logfmtPair) {
+	if buf.Len() > 0 {
+		buf.AppendByte(' ')
+	}
+	buf.AppendString(logfmtKey(p.key))
+	buf.AppendByte('=')
+	buf.AppendString(p.value)
+}
+
+// encodeWith adds the values f appends under key, as the encoders of the
+// EncoderConfig do.
+func (e *logfmtEncoder) encodeWith(key string, f func(zapcore.ArrayEncoder)) {
+	f(&logfmtArray{enc: e, key: e.prefix + key})
+}
+
+// addRaw adds a rendered value under key, prefixed.
+func (e *logfmtEncoder) addRaw(key, value string) {
+	e.pairs = append(e.pairs, logfmtPair{e.prefix + key, value})
+}
+
+func (e *logfmtEncoder) add(key, s string) {
+	e.addRaw(key, quoteLogfmt(s))
+}
+
+// nest calls f with the prefix extended by key, restoring it after.
+func (e *logfmtEncoder) nest(key string, f func() error) error {
+	prefix := e.prefix
+	e.prefix += key + "."
+	err := f()
+	e.prefix = prefix
+	return err
+}
+
+// orEmpty calls f, which adds the pairs of an object or array under the
+// prefixed key, and adds key=empty when f adds none, so that the key is
+// not lost.
+func (e *logfmtEncoder) orEmpty(key, empty string, f func() error) error {
+	n := len(e.pairs)
+	err := f()
+	if err == nil && len(e.pairs) == n {
+		e.pairs = append(e.pairs, logfmtPair{key, empty})
+	}
+	return err
+}
+
+func (e *logfmtEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	key = e.prefix + key
+	return e.orEmpty(key, "[]", func() error {
+		return arr.MarshalLogArray(&logfmtArray{enc: e, key: key, indexed: true})
+	})
+}
+
+func (e *logfmtEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	return e.orEmpty(e.prefix+key, "{}", func() error {
+		return e.nest(key, func() error { return obj.MarshalLogObject(e) })
+	})
+}
+
+func (e *logfmtEncoder) AddBinary(key string, v []byte) {
+	e.add(key, base64.StdEncoding.EncodeToString(v))
+}
+
+func (e *logfmtEncoder) AddByteString(key string, v []byte) { e.add(key, string(v)) }
+func (e *logfmtEncoder) AddBool(key string, v bool)         { e.addRaw(key, strconv.FormatBool(v)) }
+func (e *logfmtEncoder) AddComplex128(key string, v complex128) {
+	e.addRaw(key, formatComplex(v))
+}
+func (e *logfmtEncoder) AddComplex64(key string, v complex64) { e.AddComplex128(key, complex128(v)) }
+func (e *logfmtEncoder) AddDuration(key string, v time.Duration) {
+	e.encodeWith(key, func(arr zapcore.ArrayEncoder) { arr.AppendDuration(v) })
+}
+func (e *logfmtEncoder) AddFloat64(key string, v float64) { e.addRaw(key, formatFloat(v, 64)) }
+func (e *logfmtEncoder) AddFloat32(key string, v float32) {
+	e.addRaw(key, formatFloat(float64(v), 32))
+}
+func (e *logfmtEncoder) AddInt(key string, v int)       { e.AddInt64(key, int64(v)) }
+func (e *logfmtEncoder) AddInt64(key string, v int64)   { e.addRaw(key, strconv.FormatInt(v, 10)) }
+func (e *logfmtEncoder) AddInt32(key string, v int32)   { e.AddInt64(key, int64(v)) }
+func (e *logfmtEncoder) AddInt16(key string, v int16)   { e.AddInt64(key, int64(v)) }
+func (e *logfmtEncoder) AddInt8(key string, v int8)     { e.AddInt64(key, int64(v)) }
+func (e *logfmtEncoder) AddString(key, v string)        { e.add(key, v) }
+func (e *logfmtEncoder) AddUint(key string, v uint)     { e.AddUint64(key, uint64(v)) }
+func (e *logfmtEncoder) AddUint64(key string, v uint64) { e.addRaw(key, strconv.FormatUint(v, 10)) }
+func (e *logfmtEncoder) AddUint32(key string, v uint32) { e.AddUint64(key, uint64(v)) }
+func (e *logfmtEncoder) AddUint16(key string, v uint16) { e.AddUint64(key, uint64(v)) }
+func (e *logfmtEncoder) AddUint8(key string, v uint8)   { e.AddUint64(key, uint64(v)) }
+func (e *logfmtEncoder) AddUintptr(key string, v uintptr) {
+	e.AddUint64(key, uint64(v))
+}
+func (e *logfmtEncoder) AddTime(key string, v time.Time) {
+	e.encodeWith(key, func(arr zapcore.ArrayEncoder) { arr.AppendTime(v) })
+}
+
+// AddReflected flattens the JSON encoding of v.
+func (e *logfmtEncoder) AddReflected(key string, v interface{}) error {
+	decoded, err := reflectedValue(v)
+	if err != nil {
+		return err
+	}
+	e.addDecoded(e.prefix+key, decoded)
+	return nil
+}
+
+// reflectedValue returns the JSON encoding of v decoded into maps, slices
+// and scalars, keeping numbers as they were encoded.
+func reflectedValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var decoded interface{}
+	err = d.Decode(&decoded)
+	return decoded, err
+}
+
+// addDecoded adds a value decoded from JSON under the prefixed key,
+// flattening maps and slices.
+func (e *logfmtEncoder) addDecoded(key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			e.pairs = append(e.pairs, logfmtPair{key, "{}"})
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.addDecoded(key+"."+k, v[k])
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			e.pairs = append(e.pairs, logfmtPair{key, "[]"})
+		}
+		for i, elem := range v {
+			e.addDecoded(key+"."+strconv.Itoa(i), elem)
+		}
+	case string:
+		e.pairs = append(e.pairs, logfmtPair{key, quoteLogfmt(v)})
+	case json.Number:
+		e.pairs = append(e.pairs, logfmtPair{key, v.String()})
+	case bool:
+		e.pairs = append(e.pairs, logfmtPair{key, strconv.FormatBool(v)})
+	case nil:
+		e.pairs = append(e.pairs, logfmtPair{key, "null"})
+	}
+}
+
+func (e *logfmtEncoder) OpenNamespace(key string) {
+	e.prefix += key + "."
+}
+
+// logfmtArray adds the elements of an array under its key suffixed with
+// their index, or, for the encoders of the EncoderConfig, the single value
+// they append under the key itself.
+type logfmtArray struct {
+	enc     *logfmtEncoder
+	key     string
+	indexed bool
+	n       int
+}
+
+// next returns the key of the next element.
+func (a *logfmtArray) next() string {
+	key := a.key
+	if a.indexed || a.n > 0 {
+		key += "." + strconv.Itoa(a.n)
+	}
+	a.n++
+	return key
+}
+
+func (a *logfmtArray) appendRaw(value string) {
+	a.enc.pairs = append(a.enc.pairs, logfmtPair{a.next(), value})
+}
+
+// nested calls f with the encoder prefixed by key.
+func (a *logfmtArray) nested(key string, f func(enc *logfmtEncoder) error) error {
+	prefix := a.enc.prefix
+	a.enc.prefix = key + "."
+	err := f(a.enc)
+	a.enc.prefix = prefix
+	return err
+}
+
+func (a *logfmtArray) AppendArray(arr zapcore.ArrayMarshaler) error {
+	key := a.next()
+	return a.enc.orEmpty(key, "[]", func() error {
+		return arr.MarshalLogArray(&logfmtArray{enc: a.enc, key: key, indexed: true})
+	})
+}
+
+func (a *logfmtArray) AppendObject(obj zapcore.ObjectMarshaler) error {
+	key := a.next()
+	return a.enc.orEmpty(key, "{}", func() error {
+		return a.nested(key, func(enc *logfmtEncoder) error { return obj.MarshalLogObject(enc) })
+	})
+}
+
+func (a *logfmtArray) AppendReflected(v interface{}) error {
+	decoded, err := reflectedValue(v)
+	if err != nil {
+		return err
+	}
+	a.enc.addDecoded(a.next(), decoded)
+	return nil
+}
+
+func (a *logfmtArray) AppendBool(v bool)             { a.appendRaw(strconv.FormatBool(v)) }
+func (a *logfmtArray) AppendByteString(v []byte)     { a.appendRaw(quoteLogfmt(string(v))) }
+func (a *logfmtArray) AppendComplex128(v complex128) { a.appendRaw(formatComplex(v)) }
+func (a *logfmtArray) AppendComplex64(v complex64)   { a.AppendComplex128(complex128(v)) }
+func (a *logfmtArray) AppendFloat64(v float64)       { a.appendRaw(formatFloat(v, 64)) }
+func (a *logfmtArray) AppendFloat32(v float32)       { a.appendRaw(formatFloat(float64(v), 32)) }
+func (a *logfmtArray) AppendInt(v int)               { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt64(v int64)           { a.appendRaw(strconv.FormatInt(v, 10)) }
+func (a *logfmtArray) AppendInt32(v int32)           { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt16(v int16)           { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt8(v int8)             { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendString(v string)         { a.appendRaw(quoteLogfmt(v)) }
+func (a *logfmtArray) AppendUint(v uint)             { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint64(v uint64)         { a.appendRaw(strconv.FormatUint(v, 10)) }
+func (a *logfmtArray) AppendUint32(v uint32)         { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint16(v uint16)         { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint8(v uint8)           { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUintptr(v uintptr)       { a.AppendUint64(uint64(v)) }
+
+func (a *logfmtArray) AppendDuration(v time.Duration) {
+	cur := len(a.enc.pairs)
+	if a.enc.cfg.EncodeDuration != nil {
+		a.enc.cfg.EncodeDuration(v, a)
+	}
+	if len(a.enc.pairs) == cur {
+		a.AppendInt64(int64(v))
+	}
+}
+
+func (a *logfmtArray) AppendTime(v time.Time) {
+	cur := len(a.enc.pairs)
+	if a.enc.cfg.EncodeTime != nil {
+		a.enc.cfg.EncodeTime(v, a)
+	}
+	if len(a.enc.pairs) == cur {
+		a.AppendInt64(v.UnixNano())
+	}
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, bits)
+}
+
+func formatComplex(c complex128) string {
+	r, i := real(c), imag(c)
+	s := formatFloat(r, 64)
+	if i >= 0 {
+		s += "+"
+	}
+	return s + formatFloat(i, 64) + "i"
+}
+
+// logfmtKey replaces the characters a key cannot hold with underscores.
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c == '=' || c == '"' || c >= utf8.RuneSelf {
+			return sanitizeLogfmtKey(key)
+		}
+	}
+	return key
+}
+
+func sanitizeLogfmtKey(key string) string {
+	b := make([]byte, 0, len(key))
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || r == 0x7f {
+			b = append(b, '_')
+		} else {
+			b = append(b, string(r)...)
+		}
+	}
+	return string(b)
+}
+
+// quoteLogfmt returns s, quoted and escaped when it is empty or holds
+// spaces, equal signs, quotes, control characters or invalid UTF-8.
+func quoteLogfmt(s string) string {
+	if s != "" && !needsLogfmtQuote(s) {
+		return s
+	}
+	const hex = "0123456789abcdef"
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && n == 1 {
+				b = append(b, "\ufffd"...)
+			} else {
+				b = append(b, s[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < ' ' || c == 0x7f {
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+		i++
+	}
+	return string(append(b, '"'))
+}
+
+func needsLogfmtQuote(s string) bool {
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			return true
+		}
+		i += n
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// encodeLogfmt renders a record of msg and fields, without time or level.
+func encodeLogfmt(t *testing.T, msg string, fields ...zapcore.Field) string {
+	t.Helper()
+	enc := NewLogfmtEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: msg}, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	return buf.String()
+}
+
+func TestLogfmtEscaping(t *testing.T) {
+	got := encodeLogfmt(t, "pool created",
+		zap.String("plain", "a"),
+		zap.String("empty", ""),
+		zap.String("space", "a b"),
+		zap.String("quote", `say "hi"`),
+		zap.String("equal", "a=b"),
+		zap.String("lines", "l1\r\nl2\tend"),
+		zap.String("control", "\x01\x7f"),
+		zap.String("invalid", "\xff"),
+		zap.String("unicode", "héllo"),
+		zap.String("key with=\"chars\"", "v"),
+		zap.String("", "no key"),
+	)
+	want := `msg="pool created" plain=a empty="" space="a b" quote="say \"hi\"" equal="a=b"` +
+		` lines="l1\r\nl2\tend" control="\u0001\u007f" invalid="` + "�" + `" unicode=héllo` +
+		` key_with__chars_=v _="no key"` + "\n"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestLogfmtFlattening(t *testing.T) {
+	pool := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("name", "a")
+		enc.AddInt("replicas", 3)
+		return enc.AddArray("disks", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			arr.AppendString("sda")
+			return arr.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("dev", "sdb")
+				return nil
+			}))
+		}))
+	})
+	none := zapcore.ObjectMarshalerFunc(func(zapcore.ObjectEncoder) error { return nil })
+	got := encodeLogfmt(t, "m",
+		zap.Object("pool", pool),
+		zap.Strings("tags", []string{"x", "y z"}),
+		zap.Reflect("labels", map[string]interface{}{"b": "2", "a": []int{1}, "c": map[string]int{}}),
+		zap.Object("none", none),
+		zap.Strings("nothing", nil),
+		zap.Reflect("empty", map[string]int{}),
+		zap.Reflect("list", []string{}),
+		zap.Array("nested", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			arr.AppendObject(none)
+			return arr.AppendArray(zapcore.ArrayMarshalerFunc(func(zapcore.ArrayEncoder) error { return nil }))
+		})),
+		zap.Namespace("ns"),
+		zap.String("k", "v"),
+	)
+	want := `msg=m pool.name=a pool.replicas=3 pool.disks.0=sda pool.disks.1.dev=sdb` +
+		` tags.0=x tags.1="y z" labels.a.0=1 labels.b=2 labels.c={} none={} nothing=[]` +
+		` empty={} list=[] nested.0={} nested.1=[] ns.k=v` + "\n"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestLogfmtSinkKeyOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ordered, global := filepath.Join(dir, "ordered.log"), filepath.Join(dir, "global.log")
+
+	cfg := NewConfig()
+	cfg.Encoding = "logfmt"
+	cfg.EncoderConfig = zapcore.EncoderConfig{MessageKey: "msg"}
+	cfg.KeyOrder = []string{"a"}
+	cfg.Sampling = nil
+	cfg.Sinks = []SinkConfig{
+		{Paths: []string{ordered}, KeyOrder: []string{"b", "msg"}},
+		{Paths: []string{global}},
+	}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("m", zap.String("a", "1"), zap.String("b", "2"))
+	logger.Sync()
+	logger.Core().(interface{ Close() error }).Close()
+
+	for path, want := range map[string]string{
+		ordered: "b=2 msg=m a=1\n",
+		global:  "a=1 msg=m b=2\n",
+	} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -1,29 +1,177 @@
 package common
 
 import (
+	"errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io"
 	"log"
+	"sync/atomic"
+	"syscall"
 )
+
 var (
+	root   = &swapCore{current: new(atomic.Value)}
 	Logger = InitLogger()
 )
 
 func InitLogger() *zap.SugaredLogger {
-	cfg := zap.NewProductionConfig()
-	cfg.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
-	cfg.EncoderConfig.MessageKey = "msg"
-	cfg.EncoderConfig.LevelKey = "severity"
-	cfg.EncoderConfig.TimeKey = "time"
-	cfg.EncoderConfig.CallerKey = "ecode"
-	cfg.EncoderConfig.EncodeCaller = MayaCallerEncoder
-
-
-	tempLogger, err := cfg.Build(zap.AddCallerSkip(1))
+	cfg := NewConfig()
+	errSink, _, err := zap.Open(cfg.ErrorOutputPaths...)
+	if err == nil {
+		_, err = cfg.install()
+	}
 	if err != nil {
 		log.Fatalf("can't initialize zap logger: %v", err)
 	}
+
+	tempLogger := zap.New(root, cfg.buildOptions(errSink)...).WithOptions(zap.AddCallerSkip(1))
 	defer tempLogger.Sync()
 
 	return tempLogger.Sugar()
 }
+
+// Configure rebuilds the core behind Logger from cfg. Loggers already
+// derived from Logger, including those held by the glog and logrus shims,
+// write through the new core from their next call on. Caller and
+// stacktrace settings, and ErrorOutputPaths, are fixed when Logger is
+// created and are not affected.
+//
+// The sinks of the replaced core are synced, the spools of the new one
+// take over the directories of the old ones, and the old sinks are then
+// closed. Errors doing so are returned, with the new core in place all the
+// same.
+func Configure(cfg Config) error {
+	old := root.core()
+	core, err := cfg.install()
+	if err != nil {
+		return err
+	}
+	// The new core is in place at this point; errors are reported all the
+	// same.
+	err = syncErrors(old.Sync())
+	if c, ok := core.(committer); ok {
+		err = multierr.Append(err, c.commit())
+	}
+	if c, ok := old.(io.Closer); ok {
+		err = multierr.Append(err, c.Close())
+	}
+	return err
+}
+
+// syncErrors drops the EINVAL errors from err, which syncing a terminal or
+// pipe such as stderr fails with.
+func syncErrors(err error) error {
+	var kept error
+	for _, err := range multierr.Errors(err) {
+		if !errors.Is(err, syscall.EINVAL) {
+			kept = multierr.Append(kept, err)
+		}
+	}
+	return kept
+}
+
+// install builds the core described by cfg and puts it behind Logger,
+// returning it.
+func (cfg Config) install() (zapcore.Core, error) {
+	redactor, err := cfg.buildRedactor()
+	if err != nil {
+		return nil, err
+	}
+	core, err := cfg.buildCore(redactor, true)
+	if err != nil {
+		return nil, err
+	}
+	root.swap(core)
+	activeRedactor.Store(redactor)
+	logrusText.Store(cfg.DisableLogrusCore)
+	if cfg.NamedLevels != nil {
+		setNamedLevels(cfg.NamedLevels)
+	}
+	return core, nil
+}
+
+// Core returns the core behind Logger, for shims that hand records to it
+// without going through a zap.Logger.
+func Core() zapcore.Core {
+	return root
+}
+
+var logrusText atomic.Value
+
+// LogrusCore reports whether the loggers of the logrus shim hand their
+// entries to the core by default; see Config.DisableLogrusCore.
+func LogrusCore() bool {
+	disabled, _ := logrusText.Load().(bool)
+	return !disabled
+}
+
+// coreGeneration pairs a core with the number of times Configure has
+// replaced it, letting derived swapCores tell when their cache is stale.
+type coreGeneration struct {
+	gen  uint64
+	core zapcore.Core
+}
+
+// swapCore is a zapcore.Core whose destination can be replaced at runtime.
+// Cores derived through With keep their fields and re-apply them to the
+// replacement the first time they are used after a swap.
+type swapCore struct {
+	current *atomic.Value
+	fields  []zapcore.Field
+	cache   atomic.Value
+}
+
+func (c *swapCore) swap(core zapcore.Core) {
+	var gen uint64
+	if cur, ok := c.current.Load().(*coreGeneration); ok {
+		gen = cur.gen + 1
+	}
+	c.current.Store(&coreGeneration{gen: gen, core: core})
+}
+
+func (c *swapCore) core() zapcore.Core {
+	cur := c.current.Load().(*coreGeneration)
+	if len(c.fields) == 0 {
+		return cur.core
+	}
+	if cached, ok := c.cache.Load().(*coreGeneration); ok && cached.gen == cur.gen {
+		return cached.core
+	}
+	core := cur.core.With(c.fields)
+	c.cache.Store(&coreGeneration{gen: cur.gen, core: core})
+	return core
+}
+
+func (c *swapCore) Enabled(lvl zapcore.Level) bool {
+	return c.core().Enabled(lvl)
+}
+
+func (c *swapCore) With(fields []zapcore.Field) zapcore.Core {
+	merged := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	merged = append(merged, c.fields...)
+	merged = append(merged, fields...)
+	return &swapCore{current: c.current, fields: merged}
+}
+
+func (c *swapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return c.core().Check(ent, ce)
+}
+
+func (c *swapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(ent, fields)
+}
+
+func (c *swapCore) Sync() error {
+	return c.core().Sync()
+}
+
+// writeThrough hands a record that a wrapping core has accepted on to the
+// wrapped core, honouring the wrapped core's own level.
+func writeThrough(core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field) error {
+	if !core.Enabled(ent.Level) {
+		return nil
+	}
+	return core.Write(ent, fields)
+}
@@ -0,0 +1,222 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LoggerKey is the key under which the name of a named logger is encoded.
+const LoggerKey = "logger"
+
+// Named returns a child of Logger for the subsystem name, whose records
+// carry the name under LoggerKey. Naming a named logger again joins the
+// names with dots: Named("volume").Named("clone") is "volume.clone".
+//
+// Named loggers are enabled according to the level set for their name with
+// SetNamedLevel or SetNamedLevels, falling back to that of the closest
+// dotted prefix with a level, and to Config.Level when there is none.
+func Named(name string) *zap.SugaredLogger {
+	return direct.Named(name)
+}
+
+// levelTable maps logger names to the level set for them.
+type levelTable struct {
+	levels map[string]zapcore.Level
+	// min is the lowest level in levels, so that the core can tell cheaply
+	// whether any name might enable a level its base level does not.
+	min zapcore.Level
+}
+
+var (
+	// namedLevelsMu serialises updates of namedLevels; reads are lock free.
+	namedLevelsMu sync.Mutex
+	namedLevels   atomic.Value // *levelTable
+)
+
+func init() {
+	namedLevels.Store(newLevelTable(nil))
+}
+
+func newLevelTable(levels map[string]zapcore.Level) *levelTable {
+	t := &levelTable{levels: levels, min: zapcore.FatalLevel + 1}
+	for _, l := range levels {
+		if l < t.min {
+			t.min = l
+		}
+	}
+	return t
+}
+
+func currentLevels() *levelTable {
+	return namedLevels.Load().(*levelTable)
+}
+
+// lookup returns the level of the closest dotted prefix of name, name
+// included, that has a level set.
+func (t *levelTable) lookup(name string) (zapcore.Level, bool) {
+	if len(t.levels) == 0 {
+		return 0, false
+	}
+	for {
+		if l, ok := t.levels[name]; ok {
+			return l, true
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return 0, false
+		}
+		name = name[:i]
+	}
+}
+
+// SetNamedLevel sets the level of the loggers named name and of their
+// descendants without a level of their own. It takes effect immediately.
+func SetNamedLevel(name string, level zapcore.Level) {
+	namedLevelsMu.Lock()
+	defer namedLevelsMu.Unlock()
+	levels := NamedLevels()
+	levels[name] = level
+	namedLevels.Store(newLevelTable(levels))
+}
+
+// UnsetNamedLevel removes the level set for name, which then inherits it
+// again.
+func UnsetNamedLevel(name string) {
+	namedLevelsMu.Lock()
+	defer namedLevelsMu.Unlock()
+	levels := NamedLevels()
+	delete(levels, name)
+	namedLevels.Store(newLevelTable(levels))
+}
+
+// SetNamedLevels replaces all the named levels by those in spec, a comma
+// separated list of name=level pairs such as "snapshot=debug,volume.clone=warn".
+// An empty spec clears them.
+func SetNamedLevels(spec string) error {
+	levels, err := ParseNamedLevels(spec)
+	if err != nil {
+		return err
+	}
+	setNamedLevels(levels)
+	return nil
+}
+
+func setNamedLevels(levels map[string]zapcore.Level) {
+	copied := make(map[string]zapcore.Level, len(levels))
+	for name, l := range levels {
+		copied[name] = l
+	}
+	namedLevelsMu.Lock()
+	defer namedLevelsMu.Unlock()
+	namedLevels.Store(newLevelTable(copied))
+}
+
+// NamedLevels returns a copy of the named levels in effect.
+func NamedLevels() map[string]zapcore.Level {
+	t := currentLevels()
+	levels := make(map[string]zapcore.Level, len(t.levels)+1)
+	for name, l := range t.levels {
+		levels[name] = l
+	}
+	return levels
+}
+
+// FormatNamedLevels renders levels in the syntax of SetNamedLevels.
+func FormatNamedLevels(levels map[string]zapcore.Level) string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + levels[name].String()
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseNamedLevels parses a comma separated list of name=level pairs.
+func ParseNamedLevels(spec string) (map[string]zapcore.Level, error) {
+	levels := make(map[string]zapcore.Level)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		eq := strings.IndexByte(item, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("mlogger: named level %q is not name=level", item)
+		}
+		var l zapcore.Level
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(item[eq+1:]))); err != nil {
+			return nil, fmt.Errorf("mlogger: named level %q: %v", item, err)
+		}
+		levels[strings.TrimSpace(item[:eq])] = l
+	}
+	return levels, nil
+}
+
+// namedEnabler enables the levels enabled by base or by any named level,
+// for the stages behind a namedLevelCore, which takes the actual decision.
+type namedEnabler struct {
+	base zapcore.LevelEnabler
+}
+
+func (e namedEnabler) Enabled(l zapcore.Level) bool {
+	return e.base.Enabled(l) || l >= currentLevels().min
+}
+
+// NewNamedLevelCore returns a core filtering records by the level set for
+// the name of their logger, or by base for records without one or whose
+// name has no level. The stages behind it must enable every level a name
+// may, which NamedLevelEnabler provides.
+func NewNamedLevelCore(core zapcore.Core, base zapcore.LevelEnabler) zapcore.Core {
+	return &namedLevelCore{Core: core, base: base}
+}
+
+// NamedLevelEnabler returns an enabler accepting the levels enabled by base
+// or by any of the named levels.
+func NamedLevelEnabler(base zapcore.LevelEnabler) zapcore.LevelEnabler {
+	return namedEnabler{base: base}
+}
+
+type namedLevelCore struct {
+	zapcore.Core
+	base zapcore.LevelEnabler
+}
+
+func (c *namedLevelCore) enabled(ent zapcore.Entry) bool {
+	if ent.LoggerName != "" {
+		if l, ok := currentLevels().lookup(ent.LoggerName); ok {
+			return ent.Level >= l
+		}
+	}
+	return c.base.Enabled(ent.Level)
+}
+
+func (c *namedLevelCore) Enabled(l zapcore.Level) bool {
+	return namedEnabler{base: c.base}.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *namedLevelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &namedLevelCore{Core: c.Core.With(fields), base: c.base}
+}
+
+func (c *namedLevelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.enabled(ent) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *namedLevelCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !c.enabled(ent) {
+		return nil
+	}
+	return writeThrough(c.Core, ent, fields)
+}
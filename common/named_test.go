@@ -0,0 +1,101 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNamedLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "named")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+	defer setNamedLevels(nil)
+	defer Configure(NewConfig())
+	cfg := NewConfig()
+	cfg.OutputPaths = []string{path}
+	cfg.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	cfg.NamedLevels = map[string]zapcore.Level{
+		"volume":       zapcore.WarnLevel,
+		"volume.clone": zapcore.DebugLevel,
+	}
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	volume, other := Named("volume"), Named("other")
+	volume.Info("volume info")
+	volume.Warn("volume warn")
+	volume.Named("clone").Debug("clone debug")
+	volume.Named("resize").Info("resize info")
+	other.Debug("other debug")
+	other.Info("other info")
+	Logger.Debug("root debug")
+
+	// changes apply to existing loggers
+	SetNamedLevel("volume", zapcore.DebugLevel)
+	volume.Debug("volume debug")
+	UnsetNamedLevel("volume")
+	volume.Debug("volume unset")
+	if err := SetNamedLevels("other=error"); err != nil {
+		t.Fatal(err)
+	}
+	other.Warn("other warn")
+	volume.Named("clone").Debug("clone cleared")
+
+	// as do those of Configure, while a Configure without NamedLevels
+	// leaves them alone
+	cfg.NamedLevels = map[string]zapcore.Level{"other": zapcore.DebugLevel}
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	other.Debug("other reconfigured")
+	cfg.NamedLevels = nil
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	other.Debug("other kept")
+
+	Logger.Sync()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	for _, msg := range []string{"volume warn", "clone debug", "other info", "volume debug", "other reconfigured", "other kept"} {
+		if !strings.Contains(out, `"msg":"`+msg+`"`) {
+			t.Errorf("%q not logged: %s", msg, out)
+		}
+	}
+	for _, msg := range []string{"volume info", "resize info", "other debug", "root debug", "volume unset", "other warn", "clone cleared"} {
+		if strings.Contains(out, `"msg":"`+msg+`"`) {
+			t.Errorf("%q logged: %s", msg, out)
+		}
+	}
+	if !strings.Contains(out, `"logger":"volume.clone"`) {
+		t.Errorf("no dotted name: %s", out)
+	}
+}
+
+func TestParseNamedLevels(t *testing.T) {
+	levels, err := ParseNamedLevels(" snapshot=debug, volume.clone = WARN ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := FormatNamedLevels(levels); got != "snapshot=debug,volume.clone=warn" {
+		t.Errorf("parsed %s", got)
+	}
+	for _, spec := range []string{"snapshot", "=debug", "snapshot=loud"} {
+		if _, err := ParseNamedLevels(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
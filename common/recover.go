@@ -0,0 +1,180 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Keys of the fields a recovered panic is logged with.
+const (
+	PanicKey       = "panic"
+	PanicTypeKey   = "panic_type"
+	GoroutineKey   = "goroutine"
+	GoroutineIDKey = "goroutine_id"
+)
+
+// RecoverOption configures Recover and Go.
+type RecoverOption func(*recoverOptions)
+
+type recoverOptions struct {
+	level   zapcore.Level
+	ecode   string
+	label   string
+	repanic bool
+}
+
+// WithPanicLevel sets the level recovered panics are logged at. The default
+// is ErrorLevel; PanicLevel marks them without panicking again, which is
+// what WithRepanic is for.
+func WithPanicLevel(level zapcore.Level) RecoverOption {
+	return func(o *recoverOptions) {
+		o.level = level
+	}
+}
+
+// WithPanicECode sets the ecode recovered panics are logged with. By
+// default it is derived from the location of the panic.
+func WithPanicECode(ecode string) RecoverOption {
+	return func(o *recoverOptions) {
+		o.ecode = ecode
+	}
+}
+
+// WithGoroutine names the goroutine in the record of a recovered panic.
+func WithGoroutine(label string) RecoverOption {
+	return func(o *recoverOptions) {
+		o.label = label
+	}
+}
+
+// WithRepanic makes Recover panic again with the recovered value once it
+// has been logged and the core synced, so that the process still crashes.
+func WithRepanic() RecoverOption {
+	return func(o *recoverOptions) {
+		o.repanic = true
+	}
+}
+
+// Recover recovers a panic and logs it through the mlogger core with its
+// value, type, goroutine and stacktrace. It must be deferred directly:
+//
+//	defer common.Recover(common.WithGoroutine("pool-watcher"))
+func Recover(opts ...RecoverOption) {
+	if r := recover(); r != nil {
+		handlePanic(r, opts)
+	}
+}
+
+// Go runs f in a new goroutine, recovering and logging a panic in it as
+// Recover does.
+func Go(f func(), opts ...RecoverOption) {
+	go func() {
+		defer Recover(opts...)
+		f()
+	}()
+}
+
+// PanicDecoder turns a recovered value into the message and fields it is
+// logged with, reporting false for values it does not know about.
+type PanicDecoder func(v interface{}) (msg string, fields []zapcore.Field, ok bool)
+
+var (
+	panicDecodersMu sync.RWMutex
+	panicDecoders   []PanicDecoder
+)
+
+// RegisterPanicDecoder adds d to the decoders consulted for recovered
+// values, letting a shim such as logrus, which panics with its entries,
+// have its panics logged with their message and fields.
+func RegisterPanicDecoder(d PanicDecoder) {
+	panicDecodersMu.Lock()
+	defer panicDecodersMu.Unlock()
+	panicDecoders = append(panicDecoders, d)
+}
+
+func decodePanic(v interface{}) (string, []zapcore.Field) {
+	panicDecodersMu.RLock()
+	defer panicDecodersMu.RUnlock()
+	for _, d := range panicDecoders {
+		if msg, fields, ok := d(v); ok {
+			return msg, append(fields, zap.String(PanicKey, msg))
+		}
+	}
+	return "recovered from panic", []zapcore.Field{zap.String(PanicKey, fmt.Sprint(v))}
+}
+
+func handlePanic(r interface{}, opts []RecoverOption) {
+	o := &recoverOptions{level: zapcore.ErrorLevel}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	stack := debug.Stack()
+	msg, fields := decodePanic(r)
+	fields = append(fields, zap.String(PanicTypeKey, fmt.Sprintf("%T", r)))
+	if o.label != "" {
+		fields = append(fields, zap.String(GoroutineKey, o.label))
+	}
+	if id, ok := goroutineID(stack); ok {
+		fields = append(fields, zap.Int64(GoroutineIDKey, id))
+	}
+
+	ent := zapcore.Entry{
+		Level:   o.level,
+		Time:    time.Now(),
+		Message: msg,
+		Stack:   string(stack),
+	}
+	if o.ecode != "" {
+		fields = append(fields, zap.String(ECodeKey, o.ecode))
+	} else {
+		ent.Caller = panicCaller()
+	}
+
+	core := Core()
+	writeThrough(core, ent, fields)
+	if o.repanic {
+		core.Sync()
+		panic(r)
+	}
+}
+
+// panicCaller returns the frame that panicked: the first one past the
+// runtime's panic machinery.
+func panicCaller() zapcore.EntryCaller {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "runtime.") {
+			panicking = panicking || frame.Function == "runtime.gopanic"
+		} else if panicking {
+			return zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+		}
+		if !more {
+			return zapcore.EntryCaller{}
+		}
+	}
+}
+
+// goroutineID parses the ID out of the "goroutine 17 [running]:" header of
+// a stack from runtime/debug.
+func goroutineID(stack []byte) (int64, bool) {
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	i := bytes.IndexByte(stack, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(stack[:i]), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeRoot installs an observer as the core of the global logger. The
+// caller restores the default configuration.
+func observeRoot() *observer.ObservedLogs {
+	obs, logs := observer.New(zapcore.DebugLevel)
+	root.swap(obs)
+	return logs
+}
+
+func failPool() {
+	panic("disk gone")
+}
+
+func recovered(f func(), opts ...RecoverOption) {
+	defer Recover(opts...)
+	f()
+}
+
+func TestRecover(t *testing.T) {
+	defer Configure(NewConfig())
+	logs := observeRoot()
+
+	recovered(failPool, WithGoroutine("pool-watcher"))
+	recovered(func() { panic(42) }, WithPanicLevel(zapcore.WarnLevel), WithPanicECode("E42"))
+
+	all := logs.AllUntimed()
+	if len(all) != 2 {
+		t.Fatalf("logged %d records, want 2", len(all))
+	}
+	first := all[0]
+	fields := first.ContextMap()
+	if first.Level != zapcore.ErrorLevel || first.Message != "recovered from panic" ||
+		fields[PanicKey] != "disk gone" || fields[PanicTypeKey] != "string" || fields[GoroutineKey] != "pool-watcher" {
+		t.Errorf("first record %+v", first)
+	}
+	if id, ok := fields[GoroutineIDKey].(int64); !ok || id <= 0 {
+		t.Errorf("goroutine ID %v", fields[GoroutineIDKey])
+	}
+	// the caller is the frame that panicked
+	if !strings.HasSuffix(first.Caller.File, "recover_test.go") || !strings.HasSuffix(runtime.FuncForPC(first.Caller.PC).Name(), ".failPool") {
+		t.Errorf("caller %+v, want failPool", first.Caller)
+	}
+	if !strings.Contains(first.Stack, "failPool") {
+		t.Errorf("stacktrace %s", first.Stack)
+	}
+
+	second := all[1]
+	fields = second.ContextMap()
+	if second.Level != zapcore.WarnLevel || fields[ECodeKey] != "E42" || fields[PanicTypeKey] != "int" || second.Caller.Defined {
+		t.Errorf("second record %+v", second)
+	}
+}
+
+func TestRecoverRepanic(t *testing.T) {
+	defer Configure(NewConfig())
+	logs := observeRoot()
+
+	defer func() {
+		if r := recover(); r != "disk gone" {
+			t.Errorf("recovered %v, want the original value", r)
+		}
+		if logs.Len() != 1 {
+			t.Errorf("logged %d records before panicking again", logs.Len())
+		}
+	}()
+	recovered(failPool, WithRepanic())
+	t.Error("did not panic again")
+}
+
+func TestGo(t *testing.T) {
+	defer Configure(NewConfig())
+	logs := observeRoot()
+
+	Go(failPool, WithGoroutine("worker"))
+	for i := 0; logs.Len() == 0 && i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	records := logs.FilterField(String(GoroutineKey, "worker")).AllUntimed()
+	if len(records) != 1 || records[0].ContextMap()[PanicKey] != "disk gone" {
+		t.Errorf("records %v", logs.AllUntimed())
+	}
+}
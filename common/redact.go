@@ -0,0 +1,476 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RedactTag is the struct tag that marks a field as sensitive:
+//
+//	type Chap struct {
+//		User   string `json:"user"`
+//		Secret string `json:"secret" mlogger:"redact"`
+//	}
+const RedactTag = "mlogger"
+
+// RedactionConfig sets what is masked before records reach any sink: the
+// values of fields whose key is listed in Keys (compared case-insensitively,
+// at any depth of a logged struct or map), struct fields tagged
+// `mlogger:"redact"`, and the parts of messages, string fields and error
+// messages matching Patterns.
+type RedactionConfig struct {
+	Keys     []string `json:"keys" yaml:"keys"`
+	Patterns []string `json:"patterns" yaml:"patterns"`
+	// Mask replaces redacted values. It defaults to "[REDACTED]".
+	Mask string `json:"mask" yaml:"mask"`
+}
+
+// NewRedactionConfig returns a redaction configuration covering the
+// secrets found in volume and iSCSI configuration: passwords, CHAP secrets,
+// tokens, and bearer tokens or private keys embedded in messages.
+func NewRedactionConfig() RedactionConfig {
+	return RedactionConfig{
+		Keys: []string{"password", "passwd", "chapSecret", "mutualChapSecret", "secret", "token", "authorization"},
+		Patterns: []string{
+			`(?i)bearer\s+[a-z0-9\-._~+/]+=*`,
+			`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+		},
+		Mask: "[REDACTED]",
+	}
+}
+
+// Redactor masks sensitive values as configured by a RedactionConfig.
+type Redactor struct {
+	keys     map[string]struct{}
+	patterns []*regexp.Regexp
+	mask     string
+	types    sync.Map // reflect.Type -> bool, whether values need walking
+}
+
+// NewRedactor compiles cfg into a Redactor.
+func NewRedactor(cfg RedactionConfig) (*Redactor, error) {
+	r := &Redactor{keys: make(map[string]struct{}, len(cfg.Keys)), mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = "[REDACTED]"
+	}
+	for _, k := range cfg.Keys {
+		r.keys[strings.ToLower(k)] = struct{}{}
+	}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+var activeRedactor atomic.Value
+
+// ActiveRedactor returns the Redactor of the configuration installed by
+// InitLogger or Configure, or nil if redaction is disabled. Shims that
+// render records themselves use it to apply the same redaction as the
+// core.
+func ActiveRedactor() *Redactor {
+	r, _ := activeRedactor.Load().(*Redactor)
+	return r
+}
+
+func (r *Redactor) sensitive(key string) bool {
+	_, ok := r.keys[strings.ToLower(key)]
+	return ok
+}
+
+// Message masks the parts of msg matching the configured patterns.
+func (r *Redactor) Message(msg string) string {
+	for _, re := range r.patterns {
+		// MatchString does not allocate, sparing the common case
+		if re.MatchString(msg) {
+			msg = re.ReplaceAllLiteralString(msg, r.mask)
+		}
+	}
+	return msg
+}
+
+// Value returns v, or a masked replacement of it when logged under key.
+// Structs, maps and slices that hold sensitive data are replaced by a copy
+// that encodes with the sensitive parts masked, and strings and errors
+// matching the patterns by their masked text.
+func (r *Redactor) Value(key string, v interface{}) interface{} {
+	if r.sensitive(key) {
+		return r.mask
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.Message(v)
+	case error:
+		if msg := v.Error(); r.Message(msg) != msg {
+			return r.Message(msg)
+		}
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if !r.needsWalk(rv.Type()) {
+		return v
+	}
+	return r.wrap(rv)
+}
+
+// Map returns data with every value passed through Value. data itself is
+// returned when nothing had to be masked.
+func (r *Redactor) Map(data map[string]interface{}) map[string]interface{} {
+	var out map[string]interface{}
+	for k, v := range data {
+		rv := r.Value(k, v)
+		if out == nil && !sameValue(rv, v) {
+			out = make(map[string]interface{}, len(data))
+			for k2, v2 := range data {
+				out[k2] = v2
+			}
+		}
+		if out != nil {
+			out[k] = rv
+		}
+	}
+	if out == nil {
+		return data
+	}
+	return out
+}
+
+func sameValue(a, b interface{}) bool {
+	switch a.(type) {
+	case redactedObject, redactedArray:
+		return false
+	}
+	if s, ok := a.(string); ok {
+		t, ok := b.(string)
+		return ok && s == t
+	}
+	return true
+}
+
+// Fields returns fields with sensitive values masked. fields itself is
+// returned when nothing had to be masked.
+func (r *Redactor) Fields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		masked, ok := r.field(f)
+		if !ok {
+			if out != nil {
+				out = append(out, f)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, i, len(fields))
+			copy(out, fields[:i])
+		}
+		out = append(out, masked)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func (r *Redactor) field(f zapcore.Field) (zapcore.Field, bool) {
+	if f.Type == zapcore.NamespaceType || f.Type == zapcore.SkipType {
+		return f, false
+	}
+	if r.sensitive(f.Key) {
+		return zap.String(f.Key, r.mask), true
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		if masked := r.Message(f.String); masked != f.String {
+			return zap.String(f.Key, masked), true
+		}
+		return f, false
+	case zapcore.ErrorType:
+		err, ok := f.Interface.(error)
+		if !ok {
+			return f, false
+		}
+		// the verbose form of err is dropped along with the secret
+		if msg := err.Error(); r.Message(msg) != msg {
+			return zap.NamedError(f.Key, errors.New(r.Message(msg))), true
+		}
+		return f, false
+	case zapcore.ObjectMarshalerType:
+		// The error core renders error fields with NamedError before they
+		// get here; their messages, chain and stacktrace are masked as
+		// they are encoded.
+		if e, ok := f.Interface.(richError); ok && e.r == nil {
+			return zap.Object(f.Key, richError{err: e.err, r: r}), true
+		}
+		return f, false
+	}
+	if f.Type != zapcore.ReflectType || f.Interface == nil {
+		return f, false
+	}
+	rv := reflect.ValueOf(f.Interface)
+	if !r.needsWalk(rv.Type()) {
+		return f, false
+	}
+	switch w := r.wrap(rv).(type) {
+	case redactedObject:
+		return zap.Object(f.Key, w), true
+	case redactedArray:
+		return zap.Array(f.Key, w), true
+	}
+	return f, false
+}
+
+// needsWalk reports whether values of type t may contain something to
+// mask: a tagged or sensitively named struct field, or a map whose keys are
+// only known at runtime.
+func (r *Redactor) needsWalk(t reflect.Type) bool {
+	if needs, ok := r.types.Load(t); ok {
+		return needs.(bool)
+	}
+	var clean []reflect.Type
+	needs := r.walkType(t, make(map[reflect.Type]bool), &clean)
+	if !needs {
+		// Nothing reachable from t needs walking, types met on the way
+		// back to one being worked out included.
+		for _, t := range clean {
+			r.types.Store(t, false)
+		}
+	}
+	return needs
+}
+
+// walkType works out needsWalk for t, treating the types in visiting as
+// needing no walk since their answer depends on the others. Types found to
+// need walking are cached; the others are added to clean, since their
+// answer may rest on that assumption.
+func (r *Redactor) walkType(t reflect.Type, visiting map[reflect.Type]bool, clean *[]reflect.Type) bool {
+	if needs, ok := r.types.Load(t); ok {
+		return needs.(bool)
+	}
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	needs := false
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		needs = r.walkType(t.Elem(), visiting, clean)
+	case reflect.Interface:
+		needs = true
+	case reflect.Map:
+		// string keys may be sensitive names
+		needs = t.Key().Kind() == reflect.String || r.walkType(t.Elem(), visiting, clean)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			name, _ := fieldName(sf)
+			if sf.Tag.Get(RedactTag) == "redact" || r.sensitive(name) || r.walkType(sf.Type, visiting, clean) {
+				needs = true
+				break
+			}
+		}
+	}
+	if needs {
+		r.types.Store(t, true)
+	} else {
+		*clean = append(*clean, t)
+	}
+	return needs
+}
+
+// wrap returns a value that encodes like v with sensitive parts masked.
+func (r *Redactor) wrap(v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map:
+		return redactedObject{r: r, v: v}
+	case reflect.Slice, reflect.Array:
+		return redactedArray{r: r, v: v}
+	}
+	return v.Interface()
+}
+
+// fieldName returns the name a struct field is encoded under, following
+// encoding/json tags, and whether it is encoded at all.
+func fieldName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if idx := strings.IndexByte(tag, ','); idx >= 0 {
+		tag = tag[:idx]
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return sf.Name, true
+}
+
+// redactedObject encodes a struct or map with sensitive members masked.
+type redactedObject struct {
+	r *Redactor
+	v reflect.Value
+}
+
+func (o redactedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if o.v.Kind() == reflect.Map {
+		iter := o.v.MapRange()
+		for iter.Next() {
+			if err := o.add(enc, fmt.Sprint(iter.Key().Interface()), iter.Value(), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t := o.v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := fieldName(sf)
+		if sf.PkgPath != "" || !ok {
+			continue
+		}
+		fv := o.v.Field(i)
+		if sf.Anonymous && sf.Tag.Get("json") == "" && fv.Kind() == reflect.Struct {
+			if err := (redactedObject{r: o.r, v: fv}).MarshalLogObject(enc); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := o.add(enc, name, fv, sf.Tag.Get(RedactTag) == "redact"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o redactedObject) add(enc zapcore.ObjectEncoder, key string, v reflect.Value, tagged bool) error {
+	if tagged || o.r.sensitive(key) {
+		enc.AddString(key, o.r.mask)
+		return nil
+	}
+	if !v.IsValid() || !o.r.needsWalk(v.Type()) {
+		if !v.IsValid() {
+			return enc.AddReflected(key, nil)
+		}
+		return enc.AddReflected(key, v.Interface())
+	}
+	switch w := o.r.wrap(v).(type) {
+	case redactedObject:
+		return enc.AddObject(key, w)
+	case redactedArray:
+		return enc.AddArray(key, w)
+	default:
+		return enc.AddReflected(key, w)
+	}
+}
+
+// MarshalJSON lets formatters outside the zap core, such as the logrus
+// JSONFormatter, render the masked value.
+func (o redactedObject) MarshalJSON() ([]byte, error) {
+	enc := zapcore.NewMapObjectEncoder()
+	if err := o.MarshalLogObject(enc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(enc.Fields)
+}
+
+func (o redactedObject) String() string {
+	b, err := o.MarshalJSON()
+	if err != nil {
+		return o.r.mask
+	}
+	return string(b)
+}
+
+// redactedArray encodes a slice or array with sensitive elements masked.
+type redactedArray struct {
+	r *Redactor
+	v reflect.Value
+}
+
+func (a redactedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for i := 0; i < a.v.Len(); i++ {
+		var err error
+		switch w := a.r.wrap(a.v.Index(i)).(type) {
+		case redactedObject:
+			err = enc.AppendObject(w)
+		case redactedArray:
+			err = enc.AppendArray(w)
+		default:
+			err = enc.AppendReflected(w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON lets formatters outside the zap core render the masked
+// value.
+func (a redactedArray) MarshalJSON() ([]byte, error) {
+	enc := zapcore.NewMapObjectEncoder()
+	if err := enc.AddArray("a", a); err != nil {
+		return nil, err
+	}
+	return json.Marshal(enc.Fields["a"])
+}
+
+func (a redactedArray) String() string {
+	b, err := a.MarshalJSON()
+	if err != nil {
+		return a.r.mask
+	}
+	return string(b)
+}
+
+type redactCore struct {
+	zapcore.Core
+	r *Redactor
+}
+
+// NewRedactCore wraps core so that sensitive values in fields and messages
+// are masked by r before they are encoded.
+func NewRedactCore(core zapcore.Core, r *Redactor) zapcore.Core {
+	return &redactCore{Core: core, r: r}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.r.Fields(fields)), r: c.r}
+}
+
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	return ce.AddCore(ent, c)
+}
+
+func (c *redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.r.Message(ent.Message)
+	return writeThrough(c.Core, ent, c.r.Fields(fields))
+}
@@ -0,0 +1,207 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type chapAuth struct {
+	User   string `json:"user"`
+	Secret string `json:"secret" mlogger:"redact"`
+}
+
+type target struct {
+	IQN  string    `json:"iqn"`
+	Auth *chapAuth `json:"auth"`
+}
+
+// portal and session refer to each other; only session holds a secret.
+type portal struct {
+	Address string   `json:"address"`
+	Session *session `json:"session"`
+}
+
+type session struct {
+	Portal *portal `json:"portal"`
+	Token  string  `json:"token"`
+}
+
+func testRedactor(t *testing.T) *Redactor {
+	r, err := NewRedactor(NewRedactionConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// encode returns the fields as the map they encode to.
+func encode(fields []zapcore.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return enc.Fields
+}
+
+func TestRedactConcurrentFirstUse(t *testing.T) {
+	for n := 0; n < 50; n++ {
+		r := testRedactor(t)
+		var wg sync.WaitGroup
+		leaked := make(chan struct{}, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v := target{IQN: "iqn.2019", Auth: &chapAuth{User: "u", Secret: "s3cret"}}
+				m := encode(r.Fields([]zapcore.Field{zap.Any("target", v)}))
+				auth := m["target"].(map[string]interface{})["auth"]
+				if am, ok := auth.(map[string]interface{}); !ok || am["secret"] != "[REDACTED]" {
+					leaked <- struct{}{}
+				}
+			}()
+		}
+		wg.Wait()
+		if len(leaked) > 0 {
+			t.Fatalf("secret logged unmasked by %d of 8 goroutines", len(leaked))
+		}
+	}
+}
+
+func TestRedactRecursiveTypes(t *testing.T) {
+	r := testRedactor(t)
+	// working out portal first meets session while portal is unknown
+	if !r.needsWalk(reflect.TypeOf(portal{})) {
+		t.Error("portal needs no walk")
+	}
+	if !r.needsWalk(reflect.TypeOf(session{})) {
+		t.Error("session needs no walk")
+	}
+
+	r = testRedactor(t)
+	type node struct {
+		Name string `json:"name"`
+		Next *node  `json:"next"`
+	}
+	if r.needsWalk(reflect.TypeOf(node{})) {
+		t.Error("node needs a walk")
+	}
+
+	p := &portal{Address: "10.0.0.1", Session: &session{Token: "t0k"}}
+	m := encode(r.Fields([]zapcore.Field{zap.Any("portal", p)}))
+	s := m["portal"].(map[string]interface{})["session"].(map[string]interface{})
+	if s["token"] != "[REDACTED]" {
+		t.Errorf("token = %v", s["token"])
+	}
+}
+
+func TestRedactPatternsInFields(t *testing.T) {
+	r := testRedactor(t)
+	fields := []zapcore.Field{
+		zap.String("header", "Bearer abc.def"),
+		zap.Error(errors.New("auth failed with Bearer abc.def")),
+		zap.String("plain", "nothing to hide"),
+	}
+	m := encode(r.Fields(fields))
+	if m["header"] != "[REDACTED]" {
+		t.Errorf("header = %v", m["header"])
+	}
+	if m["error"] != "auth failed with [REDACTED]" {
+		t.Errorf("error = %v", m["error"])
+	}
+	if m["plain"] != "nothing to hide" {
+		t.Errorf("plain = %v", m["plain"])
+	}
+
+	data := map[string]interface{}{"err": errors.New("Bearer xyz"), "note": "Bearer xyz"}
+	out := r.Map(data)
+	if out["err"] != "[REDACTED]" || out["note"] != "[REDACTED]" {
+		t.Errorf("Map = %v", out)
+	}
+	if data["note"] != "Bearer xyz" {
+		t.Error("Map modified its argument")
+	}
+}
+
+func TestRedactNonStringKeys(t *testing.T) {
+	r := testRedactor(t)
+	targets := map[int]target{3: {IQN: "iqn.2019", Auth: &chapAuth{User: "u", Secret: "s3cret"}}}
+	m := encode(r.Fields([]zapcore.Field{zap.Any("targets", targets)}))
+	got, ok := m["targets"].(map[string]interface{})["3"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("targets = %v", m["targets"])
+	}
+	if auth := got["auth"].(map[string]interface{}); auth["secret"] != "[REDACTED]" || auth["user"] != "u" {
+		t.Errorf("auth = %v", auth)
+	}
+}
+
+// The redaction core sits behind the other stages of the pipeline; the
+// records are checked as the default configuration writes them.
+func TestRedactPipeline(t *testing.T) {
+	logger, output := buildToFile(t, NewConfig())
+	logger.With(zap.String("token", "t0ken")).Info("login with Bearer abc.def",
+		zap.String("header", "Bearer abc.def"),
+		zap.Any("target", target{IQN: "iqn.2019", Auth: &chapAuth{User: "u", Secret: "s3cret"}}),
+		zap.Any("targets", map[int]*target{1: {Auth: &chapAuth{Secret: "s3cret"}}}),
+		zap.Any("config", map[string]interface{}{"Password": "hunter2"}),
+	)
+	out := output()
+
+	for _, secret := range []string{"t0ken", "abc.def", "s3cret", "hunter2"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%s logged in clear: %s", secret, out)
+		}
+	}
+	for _, want := range []string{
+		`"msg":"login with [REDACTED]"`,
+		`"token":"[REDACTED]"`,
+		`"header":"[REDACTED]"`,
+		`"auth":{"user":"u","secret":"[REDACTED]"}`,
+		`"targets":{"1":{`,
+		`"config":{"Password":"[REDACTED]"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %s: %s", want, out)
+		}
+	}
+}
+
+func TestRedactLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+	cfg := NewConfig()
+	cfg.OutputPaths = []string{path}
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure(NewConfig())
+
+	Logger.Infow("mounting", "password", "hunter2", "auth", chapAuth{User: "u", Secret: "s3cret"})
+	Logger.Errorw("mount failed", "error", errors.New("Bearer abc.def rejected"))
+	Logger.Sync()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cret", "abc.def"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("%s logged in clear: %s", secret, b)
+		}
+	}
+	if !strings.Contains(string(b), `"password":"[REDACTED]"`) {
+		t.Errorf("password not masked: %s", b)
+	}
+}
@@ -0,0 +1,285 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConfig sets how repetitive records are thinned out. Records are
+// grouped per level by key, which by default is the ecode: an explicit
+// ecode field if the record carries one, otherwise the caller location.
+//
+// Within every Tick the first Initial records of a key are logged and then
+// every Thereafter-th one. Independently of that, each key may be limited
+// to Rate records per second with bursts of up to Burst. Every
+// SummaryInterval, and whenever the logger is synced, a record reporting
+// how many occurrences of each key were suppressed is emitted.
+type SamplingConfig struct {
+	// Tick is the sampling period. Zero disables sampling.
+	Tick       time.Duration `json:"tick" yaml:"tick"`
+	Initial    int           `json:"initial" yaml:"initial"`
+	Thereafter int           `json:"thereafter" yaml:"thereafter"`
+	// Rate is the number of records per second allowed for a key. Zero
+	// disables rate limiting.
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+	// SummaryInterval is how often suppressed counts are reported. Zero
+	// reports them only on Sync.
+	SummaryInterval time.Duration `json:"summaryInterval" yaml:"summaryInterval"`
+	// Key overrides how records are grouped. See KeyByECode and
+	// KeyByMessage.
+	Key KeyFunc `json:"-" yaml:"-"`
+}
+
+// KeyFunc returns the key under which a record is sampled and rate limited.
+// fields holds the fields of the call followed by those added with With.
+type KeyFunc func(ent zapcore.Entry, fields []zapcore.Field) string
+
+// KeyByECode keys records by their ecode field, falling back to the caller
+// location and then the message. It is the default.
+func KeyByECode(ent zapcore.Entry, fields []zapcore.Field) string {
+	if code, ok := findECode(fields); ok {
+		return code
+	}
+	if ent.Caller.Defined {
+		return PackagePath(ent.Caller, 3)
+	}
+	return ent.Message
+}
+
+// KeyByMessage keys records by their message or, for records logged with
+// a format such as by glog.Infof, by its format string, so that the
+// records of a call share a key whatever its arguments.
+func KeyByMessage(ent zapcore.Entry, fields []zapcore.Field) string {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Key == templateKey && fields[i].Type == zapcore.SkipType {
+			return fields[i].String
+		}
+	}
+	return ent.Message
+}
+
+// templateKey is the key of the fields made by Template.
+const templateKey = "mlogger.template"
+
+// Template returns a field recording the format string a message was
+// rendered from, for KeyByMessage. Encoders skip it.
+func Template(format string) zapcore.Field {
+	return zapcore.Field{Key: templateKey, Type: zapcore.SkipType, String: format}
+}
+
+func findECode(fields []zapcore.Field) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if fields[i].Key == ECodeKey && fields[i].Type == zapcore.StringType {
+			return fields[i].String, true
+		}
+	}
+	return "", false
+}
+
+// samplingKey identifies a group of records. Records keyed by their caller
+// carry its file and line rather than a rendered ecode, so the common path
+// does not allocate.
+type samplingKey struct {
+	level zapcore.Level
+	key   string
+	file  string
+	line  int
+}
+
+func (k samplingKey) name() string {
+	if k.file == "" {
+		return k.key
+	}
+	return PackagePath(zapcore.EntryCaller{Defined: true, File: k.file, Line: k.line}, 3)
+}
+
+type keyState struct {
+	tickEnd    time.Time
+	count      int
+	tokens     float64
+	refilled   time.Time
+	suppressed int
+	seen       time.Time
+}
+
+// sampler holds the state shared by a sampling core and everything derived
+// from it through With.
+type sampler struct {
+	cfg  SamplingConfig
+	base zapcore.Core
+
+	mu          sync.Mutex
+	keys        map[samplingKey]*keyState
+	lastSummary time.Time
+}
+
+// allow records an occurrence of key at now and reports whether it should
+// be logged.
+func (s *sampler) allow(key samplingKey, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.keys[key]
+	if !ok {
+		st = &keyState{tokens: float64(s.burst()), refilled: now}
+		s.keys[key] = st
+	}
+	st.seen = now
+
+	if s.cfg.Tick > 0 {
+		if !now.Before(st.tickEnd) {
+			st.tickEnd = now.Add(s.cfg.Tick)
+			st.count = 0
+		}
+		st.count++
+		if st.count > s.cfg.Initial &&
+			(s.cfg.Thereafter <= 0 || (st.count-s.cfg.Initial)%s.cfg.Thereafter != 0) {
+			st.suppressed++
+			return false
+		}
+	}
+
+	if s.cfg.Rate > 0 {
+		st.tokens += now.Sub(st.refilled).Seconds() * s.cfg.Rate
+		if max := float64(s.burst()); st.tokens > max {
+			st.tokens = max
+		}
+		st.refilled = now
+		if st.tokens < 1 {
+			st.suppressed++
+			return false
+		}
+		st.tokens--
+	}
+	return true
+}
+
+func (s *sampler) burst() int {
+	if s.cfg.Burst < 1 {
+		return 1
+	}
+	return s.cfg.Burst
+}
+
+// summaries returns and resets the suppressed counts if a summary is due at
+// now, or unconditionally if force is set. Keys that have been idle for a
+// whole interval are forgotten.
+func (s *sampler) summaries(now time.Time, force bool) map[samplingKey]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !force && (s.cfg.SummaryInterval <= 0 || now.Sub(s.lastSummary) < s.cfg.SummaryInterval) {
+		return nil
+	}
+	cutoff := s.lastSummary
+	s.lastSummary = now
+
+	var due map[samplingKey]int
+	for key, st := range s.keys {
+		if st.suppressed > 0 {
+			if due == nil {
+				due = make(map[samplingKey]int)
+			}
+			due[key] = st.suppressed
+			st.suppressed = 0
+			continue
+		}
+		if st.seen.Before(cutoff) && !now.Before(st.tickEnd) {
+			delete(s.keys, key)
+		}
+	}
+	return due
+}
+
+func (s *sampler) writeSummaries(now time.Time, force bool) error {
+	var err error
+	for key, n := range s.summaries(now, force) {
+		ent := zapcore.Entry{
+			Level:   key.level,
+			Time:    now,
+			Message: fmt.Sprintf("suppressed %d occurrences of %s", n, key.name()),
+		}
+		if key.file != "" {
+			ent.Caller = zapcore.EntryCaller{Defined: true, File: key.file, Line: key.line}
+		}
+		err = multierr.Append(err, writeThrough(s.base, ent, []zapcore.Field{zap.Int("suppressed", n)}))
+	}
+	return err
+}
+
+type samplerCore struct {
+	zapcore.Core
+	s       *sampler
+	context []zapcore.Field
+}
+
+// NewSampler wraps core so that records are sampled and rate limited
+// according to cfg.
+func NewSampler(core zapcore.Core, cfg SamplingConfig) zapcore.Core {
+	return &samplerCore{
+		Core: core,
+		s: &sampler{
+			cfg:         cfg,
+			base:        core,
+			keys:        make(map[samplingKey]*keyState),
+			lastSummary: time.Now(),
+		},
+	}
+}
+
+func (c *samplerCore) With(fields []zapcore.Field) zapcore.Core {
+	context := make([]zapcore.Field, 0, len(c.context)+len(fields))
+	context = append(context, c.context...)
+	context = append(context, fields...)
+	return &samplerCore{Core: c.Core.With(fields), s: c.s, context: context}
+}
+
+func (c *samplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	return ce.AddCore(ent, c)
+}
+
+func (c *samplerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var err error
+	if c.s.allow(c.key(ent, fields), ent.Time) {
+		err = writeThrough(c.Core, ent, fields)
+	}
+	return multierr.Append(err, c.s.writeSummaries(ent.Time, false))
+}
+
+func (c *samplerCore) Sync() error {
+	err := c.s.writeSummaries(time.Now(), true)
+	return multierr.Append(err, c.Core.Sync())
+}
+
+func (c *samplerCore) key(ent zapcore.Entry, fields []zapcore.Field) samplingKey {
+	key := samplingKey{level: ent.Level}
+	if c.s.cfg.Key != nil {
+		all := fields
+		if len(c.context) > 0 {
+			all = make([]zapcore.Field, 0, len(fields)+len(c.context))
+			all = append(append(all, fields...), c.context...)
+		}
+		key.key = c.s.cfg.Key(ent, all)
+		return key
+	}
+
+	if code, ok := findECode(fields); ok {
+		key.key = code
+	} else if code, ok := findECode(c.context); ok {
+		key.key = code
+	} else if ent.Caller.Defined {
+		key.file, key.line = ent.Caller.File, ent.Caller.Line
+	} else {
+		key.key = ent.Message
+	}
+	return key
+}
@@ -0,0 +1,134 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestKeyByMessageTemplate(t *testing.T) {
+	ent := zapcore.Entry{Message: "attached volume pvc-1"}
+	if key := KeyByMessage(ent, nil); key != ent.Message {
+		t.Errorf("key %q, want the message", key)
+	}
+	fields := []zapcore.Field{zap.String("volume", "pvc-1"), Template("attached volume %s")}
+	if key := KeyByMessage(ent, fields); key != "attached volume %s" {
+		t.Errorf("key %q, want the format", key)
+	}
+
+	// formatted records of a call share their key, and the template is
+	// not logged
+	obs, logs := observer.New(zapcore.InfoLevel)
+	core := NewSampler(obs, SamplingConfig{Tick: time.Hour, Initial: 1, Key: KeyByMessage})
+	logger := zap.New(core).Sugar()
+	for _, volume := range []string{"pvc-1", "pvc-2", "pvc-3"} {
+		logger.Infow("attached volume "+volume, Template("attached volume %s"))
+	}
+	if n := logs.Len(); n != 1 {
+		t.Fatalf("%d records logged, want 1", n)
+	}
+	if fields := logs.All()[0].ContextMap(); len(fields) != 0 {
+		t.Errorf("logged fields %v", fields)
+	}
+}
+
+// sampledWriter writes records straight to a sampling core, at chosen
+// times.
+type sampledWriter struct {
+	t    *testing.T
+	core zapcore.Core
+	logs *observer.ObservedLogs
+}
+
+func newSampledWriter(t *testing.T, cfg SamplingConfig) *sampledWriter {
+	obs, logs := observer.New(zapcore.DebugLevel)
+	return &sampledWriter{t: t, core: NewSampler(obs, cfg), logs: logs}
+}
+
+func (w *sampledWriter) write(at time.Time, n int, fields ...zapcore.Field) {
+	w.t.Helper()
+	for i := 0; i < n; i++ {
+		ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: at, Message: "pool degraded"}
+		if err := w.core.Write(ent, fields); err != nil {
+			w.t.Fatal(err)
+		}
+	}
+}
+
+func TestSamplerInitialThereafter(t *testing.T) {
+	w := newSampledWriter(t, SamplingConfig{Tick: time.Minute, Initial: 2, Thereafter: 3})
+	start := time.Now()
+	// the 1st, 2nd, 5th and 8th of a tick are logged
+	w.write(start, 10, ECode("E1"))
+	if n := w.logs.Len(); n != 4 {
+		t.Fatalf("logged %d records in the first tick, want 4", n)
+	}
+	w.write(start.Add(time.Minute), 1, ECode("E1"))
+	if n := w.logs.Len(); n != 5 {
+		t.Fatalf("logged %d records, want the first of the next tick", n)
+	}
+
+	if err := w.core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	summaries := w.logs.FilterMessage("suppressed 6 occurrences of E1")
+	if summaries.Len() != 1 || summaries.All()[0].ContextMap()["suppressed"] != int64(6) {
+		t.Errorf("records %v", w.logs.All())
+	}
+	// counts are reset once reported
+	w.core.Sync()
+	if n := w.logs.Len(); n != 6 {
+		t.Errorf("logged %d records after a second Sync, want 6", n)
+	}
+}
+
+func TestSamplerRate(t *testing.T) {
+	w := newSampledWriter(t, SamplingConfig{Rate: 2, Burst: 3})
+	start := time.Now()
+	w.write(start, 5, ECode("E1"))
+	if n := w.logs.Len(); n != 3 {
+		t.Fatalf("logged %d records, want the burst of 3", n)
+	}
+	// half a second refills one token
+	w.write(start.Add(500*time.Millisecond), 2, ECode("E1"))
+	if n := w.logs.Len(); n != 4 {
+		t.Fatalf("logged %d records, want 4", n)
+	}
+	w.core.Sync()
+	if w.logs.FilterMessage("suppressed 3 occurrences of E1").Len() != 1 {
+		t.Errorf("records %v", w.logs.All())
+	}
+}
+
+func TestSamplerKeysByECode(t *testing.T) {
+	w := newSampledWriter(t, SamplingConfig{Tick: time.Minute, Initial: 1})
+	start := time.Now()
+	w.write(start, 2, ECode("E1"))
+	w.write(start, 2, ECode("E2"))
+	// without an ecode, records are keyed by their caller
+	logger := zap.New(w.core, zap.AddCaller())
+	for i := 0; i < 2; i++ {
+		logger.Info("pool degraded")
+	}
+	logger.With(ECode("E3")).Info("pool degraded")
+	if n := w.logs.Len(); n != 4 {
+		t.Fatalf("logged %d records, want one per key", n)
+	}
+
+	w.core.Sync()
+	for _, msg := range []string{
+		"suppressed 1 occurrences of E1",
+		"suppressed 1 occurrences of E2",
+	} {
+		if w.logs.FilterMessage(msg).Len() != 1 {
+			t.Errorf("no %q: %v", msg, w.logs.All())
+		}
+	}
+	callers := w.logs.FilterMessageSnippet("sampler_test.go:")
+	if callers.Len() != 1 || callers.All()[0].Caller.File == "" {
+		t.Errorf("no summary of the caller key: %v", w.logs.All())
+	}
+}
@@ -0,0 +1,242 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A spool directory holds numbered segment files of frames, each a record
+// prefixed by its length and its CRC-32C, and a checkpoint file holding the
+// position up to which records were delivered. Frames are appended to the
+// last segment only; a crash may leave a partial frame at its end, which
+// the CRC exposes and replay ignores.
+
+const (
+	segmentSuffix  = ".seg"
+	checkpointName = "checkpoint"
+	frameHeader    = 8
+	// maxFrame bounds the length read from a frame header, which is
+	// garbage when the header is torn.
+	maxFrame = 64 << 20
+)
+
+var frameTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment describes a segment file.
+type segment struct {
+	seq uint64
+	// size is the length of the valid frames of the file.
+	size int64
+	// n counts the records of the segment, and acked those delivered.
+	n     int
+	acked int
+	// last is the time of the last record appended.
+	last time.Time
+}
+
+// spoolPos is the position of a frame in a spool.
+type spoolPos struct {
+	seq uint64
+	off int64
+}
+
+// appendFrame appends to b the frame of record.
+func appendFrame(b, record []byte) []byte {
+	var hdr [frameHeader]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(hdr[4:], crc32.Checksum(record, frameTable))
+	b = append(b, hdr[:]...)
+	return append(b, record...)
+}
+
+// readFrame reads a frame from r, returning its record and its length.
+// It returns io.EOF at the end of r and io.ErrUnexpectedEOF for a torn or
+// corrupt frame.
+func readFrame(r *bufio.Reader) ([]byte, int64, error) {
+	var hdr [frameHeader]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n > maxFrame {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	record := make([]byte, n)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(record, frameTable) != binary.BigEndian.Uint32(hdr[4:]) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return record, frameHeader + int64(n), nil
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// loadSegments scans the segments of dir, oldest first, counting their
+// valid frames.
+func loadSegments(dir string) ([]*segment, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []*segment
+	for _, fi := range infos {
+		name := fi.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{seq: seq, last: fi.ModTime()}
+		if err := scanSegment(dir, seg); err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].seq < segs[j].seq })
+	return segs, nil
+}
+
+// scanSegment sets the size and the record count of seg from its valid
+// frames, stopping at the first torn one.
+func scanSegment(dir string, seg *segment) error {
+	f, err := os.Open(segmentPath(dir, seg.seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		_, n, err := readFrame(r)
+		if err != nil {
+			return nil
+		}
+		seg.size += n
+		seg.n++
+	}
+}
+
+// readCheckpoint returns the position stored in the checkpoint of dir, or
+// the zero position when there is none.
+func readCheckpoint(dir string) (spoolPos, error) {
+	var pos spoolPos
+	b, err := ioutil.ReadFile(filepath.Join(dir, checkpointName))
+	if os.IsNotExist(err) {
+		return pos, nil
+	}
+	if err != nil {
+		return pos, err
+	}
+	if _, err := fmt.Sscanf(string(b), "%d %d", &pos.seq, &pos.off); err != nil {
+		// replaying from the oldest segment beats losing records
+		return spoolPos{}, nil
+	}
+	return pos, nil
+}
+
+// writeCheckpoint stores pos in the checkpoint of dir, written aside and
+// renamed so that a crash leaves either checkpoint whole.
+func writeCheckpoint(dir string, pos spoolPos) error {
+	tmp, err := ioutil.TempFile(dir, "checkpoint-")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(tmp, "%d %d\n", pos.seq, pos.off)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, checkpointName))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// syncDir flushes the entries of dir, so that a created segment survives
+// a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// spooledFrame is a record read back from a spool, with the position
+// following it.
+type spooledFrame struct {
+	record []byte
+	seq    uint64
+	end    spoolPos
+}
+
+// readFrames reads up to max frames of the segments in segs from pos,
+// within the sizes of segs.
+func readFrames(dir string, segs []segment, pos spoolPos, max int) ([]spooledFrame, error) {
+	var frames []spooledFrame
+	for _, seg := range segs {
+		if seg.seq < pos.seq {
+			continue
+		}
+		off := int64(0)
+		if seg.seq == pos.seq {
+			off = pos.off
+		}
+		if off >= seg.size {
+			continue
+		}
+		f, err := os.Open(segmentPath(dir, seg.seq))
+		if os.IsNotExist(err) {
+			// dropped meanwhile
+			continue
+		}
+		if err != nil {
+			return frames, err
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, off, seg.size-off))
+		for len(frames) < max && off < seg.size {
+			record, n, err := readFrame(r)
+			if err != nil {
+				// corrupt within the valid size: skip the rest of the
+				// segment rather than stall on it
+				off = seg.size
+				frames = append(frames, spooledFrame{seq: seg.seq, end: spoolPos{seg.seq, off}})
+				break
+			}
+			off += n
+			frames = append(frames, spooledFrame{record: record, seq: seg.seq, end: spoolPos{seg.seq, off}})
+		}
+		f.Close()
+		if len(frames) >= max {
+			break
+		}
+	}
+	return frames, nil
+}
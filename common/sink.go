@@ -0,0 +1,332 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SinkConfig declares one of the destinations a record is teed to, with
+// its own encoding, level and fields.
+type SinkConfig struct {
+	// Name identifies the sink in errors. It defaults to its type.
+	Name string `json:"name" yaml:"name"`
+	// Type selects the kind of sink among those registered with
+	// RegisterSinkType. "file", the default, writes to Paths.
+	Type string `json:"type" yaml:"type"`
+	// Paths lists the URLs or file paths a "file" sink writes to, as
+	// Config.OutputPaths does.
+	Paths []string `json:"paths" yaml:"paths"`
+	// Encoding overrides Config.Encoding for the sink.
+	Encoding string `json:"encoding" yaml:"encoding"`
+	// EncoderConfig overrides Config.EncoderConfig for the sink.
+	EncoderConfig *zapcore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
+	// KeyOrder overrides Config.KeyOrder for the sink.
+	KeyOrder []string `json:"keyOrder" yaml:"keyOrder"`
+	// Level is the minimum level of the records the sink receives. When
+	// nil, it receives every record the core logs.
+	Level *zapcore.Level `json:"level" yaml:"level"`
+	// Fields restricts the fields the sink receives.
+	Fields *FieldFilter `json:"fields" yaml:"fields"`
+	// Params holds the settings specific to the type of the sink, such as
+	// the address of a syslog server.
+	Params map[string]string `json:"params" yaml:"params"`
+	// Spool, when set, spools the records of the sink to disk, so that a
+	// remote sink does not lose them while its destination is down.
+	Spool *SpoolConfig `json:"spool" yaml:"spool"`
+}
+
+// FieldFilter selects the fields of a record a sink receives by key.
+type FieldFilter struct {
+	// Include, when not empty, lists the only keys kept.
+	Include []string `json:"include" yaml:"include"`
+	// Exclude lists keys dropped.
+	Exclude []string `json:"exclude" yaml:"exclude"`
+}
+
+// SinkFactory builds the core of a sink from its configuration, the
+// encoder configured for it and the levels it is to receive.
+//
+// A core holding resources, such as files, connections or goroutines, also
+// implements io.Closer. Once Configure has replaced it, it is synced and
+// then closed; records written to it after that may be dropped, but must
+// not make it panic.
+type SinkFactory func(sc SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error)
+
+var (
+	sinkTypesMu sync.RWMutex
+	sinkTypes   = map[string]SinkFactory{
+		"file": newFileSink,
+	}
+)
+
+// RegisterSinkType makes sinks of type name available to
+// Config.Sinks. Packages providing sinks register them from init, so
+// importing them is enough to enable their type.
+func RegisterSinkType(name string, f SinkFactory) error {
+	sinkTypesMu.Lock()
+	defer sinkTypesMu.Unlock()
+	if _, ok := sinkTypes[name]; ok {
+		return fmt.Errorf("mlogger: sink type %q already registered", name)
+	}
+	sinkTypes[name] = f
+	return nil
+}
+
+// SinkTypes returns the names of the registered sink types.
+func SinkTypes() []string {
+	sinkTypesMu.RLock()
+	defer sinkTypesMu.RUnlock()
+	names := make([]string, 0, len(sinkTypes))
+	for name := range sinkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newFileSink(sc SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if len(sc.Paths) == 0 {
+		return nil, fmt.Errorf("no paths")
+	}
+	ws, close, err := zap.Open(sc.Paths...)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCore{Core: zapcore.NewCore(enc, ws, enab), close: close}, nil
+}
+
+// fileCore is a core writing to the files it closes with close.
+type fileCore struct {
+	zapcore.Core
+	close func()
+}
+
+func (c *fileCore) Close() error {
+	c.close()
+	return nil
+}
+
+// closers closes each of its members in turn.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		err = multierr.Append(err, c.Close())
+	}
+	return err
+}
+
+// closingCore is the core of a pipeline along with what closes its sinks.
+// Cores derived from it through With share its sinks and do not close
+// them.
+type closingCore struct {
+	zapcore.Core
+	closers closers
+}
+
+func (c *closingCore) Close() error {
+	return c.closers.Close()
+}
+
+// committer is implemented by the closers with a step to run once their
+// pipeline is installed, such as spools taking over the Dir of the
+// pipeline replaced.
+type committer interface {
+	commit() error
+}
+
+func (c *closingCore) commit() error {
+	var err error
+	for _, cl := range c.closers {
+		if cm, ok := cl.(committer); ok {
+			err = multierr.Append(err, cm.commit())
+		}
+	}
+	return err
+}
+
+// buildSink builds the core of sc, falling back to the encoding of cfg,
+// along with what closes it. enab is the level of the core as a whole.
+func (cfg Config) buildSink(sc SinkConfig, enab zapcore.LevelEnabler, takeover bool) (zapcore.Core, closers, error) {
+	if sc.Type == "" {
+		sc.Type = "file"
+	}
+	if sc.Name == "" {
+		sc.Name = sc.Type
+	}
+	sinkTypesMu.RLock()
+	factory, ok := sinkTypes[sc.Type]
+	sinkTypesMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("mlogger: sink %q: unknown type %q", sc.Name, sc.Type)
+	}
+
+	encoding, ec, order := cfg.Encoding, cfg.EncoderConfig, cfg.KeyOrder
+	if sc.Encoding != "" {
+		encoding = sc.Encoding
+	}
+	if sc.EncoderConfig != nil {
+		ec = *sc.EncoderConfig
+	}
+	if sc.KeyOrder != nil {
+		order = sc.KeyOrder
+	}
+	enc, err := newEncoder(encoding, ec, order)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mlogger: sink %q: %v", sc.Name, err)
+	}
+	if sc.Level != nil {
+		enab = *sc.Level
+	}
+
+	core, err := factory(sc, enc, enab)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mlogger: sink %q: %v", sc.Name, err)
+	}
+	var cs closers
+	if c, ok := core.(io.Closer); ok {
+		cs = append(cs, c)
+	}
+	if sc.Fields != nil {
+		core = NewFieldFilterCore(core, *sc.Fields)
+	}
+	if sc.Spool != nil {
+		spool, err := newSpool(core, *sc.Spool, takeover)
+		if err != nil {
+			cs.Close()
+			return nil, nil, fmt.Errorf("mlogger: sink %q: spool: %v", sc.Name, err)
+		}
+		core = spool
+		// the spool forwards to the sink until it is closed
+		if c, ok := spool.(io.Closer); ok {
+			cs = append(closers{c}, cs...)
+		}
+	}
+	return core, cs, nil
+}
+
+// NewTee returns a core duplicating records to each of cores. Unlike
+// zapcore.NewTee, every write is checked against the level of each core,
+// since the stages in front of it write without checking each member.
+func NewTee(cores ...zapcore.Core) zapcore.Core {
+	return teeCore(cores)
+}
+
+type teeCore []zapcore.Core
+
+func (t teeCore) Enabled(lvl zapcore.Level) bool {
+	for _, c := range t {
+		if c.Enabled(lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeCore) With(fields []zapcore.Field) zapcore.Core {
+	cores := make(teeCore, len(t))
+	for i, c := range t {
+		cores[i] = c.With(fields)
+	}
+	return cores
+}
+
+func (t teeCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if t.Enabled(ent.Level) {
+		return ce.AddCore(ent, t)
+	}
+	return ce
+}
+
+func (t teeCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var err error
+	for _, c := range t {
+		err = multierr.Append(err, writeThrough(c, ent, fields))
+	}
+	return err
+}
+
+func (t teeCore) Sync() error {
+	var err error
+	for _, c := range t {
+		err = multierr.Append(err, c.Sync())
+	}
+	return err
+}
+
+// NewFieldFilterCore returns a core passing only the fields selected by f
+// to core. The ecode of a record is always passed, since the error stage
+// takes it out of the fields of the caller.
+func NewFieldFilterCore(core zapcore.Core, f FieldFilter) zapcore.Core {
+	c := &fieldFilterCore{Core: core}
+	if len(f.Include) > 0 {
+		c.include = make(map[string]bool, len(f.Include))
+		for _, k := range f.Include {
+			c.include[k] = true
+		}
+	}
+	if len(f.Exclude) > 0 {
+		c.exclude = make(map[string]bool, len(f.Exclude))
+		for _, k := range f.Exclude {
+			c.exclude[k] = true
+		}
+	}
+	return c
+}
+
+type fieldFilterCore struct {
+	zapcore.Core
+	include map[string]bool
+	exclude map[string]bool
+}
+
+func (c *fieldFilterCore) keep(key string) bool {
+	if key == ECodeKey {
+		return true
+	}
+	if c.include != nil && !c.include[key] {
+		return false
+	}
+	return !c.exclude[key]
+}
+
+// filter returns the fields to keep, copying fields only when some are
+// dropped.
+func (c *fieldFilterCore) filter(fields []zapcore.Field) []zapcore.Field {
+	for i, f := range fields {
+		if c.keep(f.Key) {
+			continue
+		}
+		kept := make([]zapcore.Field, i, len(fields))
+		copy(kept, fields[:i])
+		for _, f := range fields[i+1:] {
+			if c.keep(f.Key) {
+				kept = append(kept, f)
+			}
+		}
+		return kept
+	}
+	return fields
+}
+
+func (c *fieldFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &fieldFilterCore{Core: c.Core.With(c.filter(fields)), include: c.include, exclude: c.exclude}
+}
+
+func (c *fieldFilterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *fieldFilterCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.filter(fields))
+}
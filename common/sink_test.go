@@ -0,0 +1,123 @@
+package common
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTeeLevels(t *testing.T) {
+	info, infoLogs := observer.New(zapcore.InfoLevel)
+	errs, errLogs := observer.New(zapcore.ErrorLevel)
+	tee := NewTee(info, errs)
+	if tee.Enabled(zapcore.DebugLevel) || !tee.Enabled(zapcore.InfoLevel) {
+		t.Error("the tee enables a level none of its cores does, or not one some does")
+	}
+
+	// the stages in front of the tee write without checking
+	tee = tee.With([]zapcore.Field{zap.String("pool", "a")})
+	for _, level := range []zapcore.Level{zapcore.DebugLevel, zapcore.WarnLevel, zapcore.ErrorLevel} {
+		if err := tee.Write(zapcore.Entry{Level: level, Message: level.String()}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := infoLogs.Len(); n != 2 {
+		t.Errorf("info core got %d records, want 2", n)
+	}
+	if all := errLogs.AllUntimed(); len(all) != 1 || all[0].Message != "error" || all[0].ContextMap()["pool"] != "a" {
+		t.Errorf("error core got %v", all)
+	}
+}
+
+func TestFieldFilter(t *testing.T) {
+	obs, logs := observer.New(zapcore.DebugLevel)
+	core := NewFieldFilterCore(obs, FieldFilter{Include: []string{"pool", "disk", "token"}, Exclude: []string{"token"}})
+	core = core.With([]zapcore.Field{zap.String("pool", "a"), zap.String("node", "n1")})
+
+	fields := []zapcore.Field{zap.String("disk", "sda"), zap.String("token", "t"), zap.Int("n", 1), ECode("E1")}
+	zap.New(core).Info("degraded", fields...)
+	got := logs.AllUntimed()[0].ContextMap()
+	want := map[string]interface{}{"pool": "a", "disk": "sda", ECodeKey: "E1"}
+	if !equalJSON(got, want) {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+	if fields[1].Key != "token" || fields[2].Key != "n" {
+		t.Errorf("the fields of the caller were modified: %v", fields)
+	}
+}
+
+// closeCounter is a sink counting the times it is closed.
+type closeCounter struct {
+	zapcore.Core
+	mu     sync.Mutex
+	closes int
+}
+
+func (c *closeCounter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closes++
+	return nil
+}
+
+var (
+	countersMu sync.Mutex
+	counters   []*closeCounter
+)
+
+func init() {
+	err := RegisterSinkType("close-counter", func(sc SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+		c := &closeCounter{Core: zapcore.NewNopCore()}
+		countersMu.Lock()
+		counters = append(counters, c)
+		countersMu.Unlock()
+		return c, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// takeCounters returns the sinks built since the last call.
+func takeCounters() []*closeCounter {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	cs := counters
+	counters = nil
+	return cs
+}
+
+func TestClosingCore(t *testing.T) {
+	takeCounters()
+	cfg := NewConfig()
+	cfg.Sinks = []SinkConfig{{Type: "close-counter"}, {Type: "close-counter", Fields: &FieldFilter{Exclude: []string{"n"}}}}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := logger.With(zap.Int("n", 1)).Core().(io.Closer); ok {
+		t.Error("a derived core closes the sinks it shares")
+	}
+	if err := logger.Core().(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	built := takeCounters()
+	if len(built) != 2 || built[0].closes != 1 || built[1].closes != 1 {
+		t.Errorf("sinks closed %+v, want each once", built)
+	}
+
+	// a failing build closes the sinks built before the failure
+	cfg.Sinks = append(cfg.Sinks, SinkConfig{Type: "unknown"})
+	if _, err := cfg.Build(); err == nil || !strings.Contains(err.Error(), `unknown type "unknown"`) {
+		t.Fatalf("Build = %v", err)
+	}
+	built = takeCounters()
+	if len(built) != 2 || built[0].closes != 1 || built[1].closes != 1 {
+		t.Errorf("sinks closed %+v after a failed build, want each once", built)
+	}
+}
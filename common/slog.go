@@ -0,0 +1,337 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewSlogHandler returns a slog.Handler writing through core, so that code
+// written against log/slog shares the mlogger pipeline and encoding:
+//
+//	slog.SetDefault(slog.New(common.NewSlogHandler(common.Core())))
+//
+// Levels are mapped onto the nearest zap level at or below them, groups
+// become nested objects, LogValuers are resolved, and the source location
+// of the record is encoded as its ecode.
+func NewSlogHandler(core zapcore.Core) slog.Handler {
+	return &slogHandler{core: core}
+}
+
+type slogHandler struct {
+	core zapcore.Core
+	// groups opened by WithGroup that no attribute has been added to yet.
+	// They are only applied once there is something to put in them.
+	groups []string
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.core.Enabled(zapLevelOf(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	ent := zapcore.Entry{
+		Level:   zapLevelOf(r.Level),
+		Time:    r.Time,
+		Message: r.Message,
+	}
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		ent.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+	}
+
+	var fields []zapcore.Field
+	if r.NumAttrs() > 0 {
+		fields = make([]zapcore.Field, 0, len(h.groups)+r.NumAttrs())
+		for _, g := range h.groups {
+			fields = append(fields, zap.Namespace(g))
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			fields = appendAttr(fields, a)
+			return true
+		})
+	}
+	if !h.core.Enabled(ent.Level) {
+		return nil
+	}
+	return h.core.Write(ent, fields)
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	fields := make([]zapcore.Field, 0, len(h.groups)+len(attrs))
+	for _, g := range h.groups {
+		fields = append(fields, zap.Namespace(g))
+	}
+	for _, a := range attrs {
+		fields = appendAttr(fields, a)
+	}
+	return &slogHandler{core: h.core.With(fields)}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+	return &slogHandler{core: h.core, groups: append(groups, name)}
+}
+
+// appendAttr converts a into zap fields. Empty attributes and empty groups
+// are dropped and groups without a key are inlined, as slog requires.
+func appendAttr(fields []zapcore.Field, a slog.Attr) []zapcore.Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	switch a.Value.Kind() {
+	case slog.KindBool:
+		return append(fields, zap.Bool(a.Key, a.Value.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(a.Key, a.Value.Duration()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(a.Key, a.Value.Float64()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(a.Key, a.Value.Int64()))
+	case slog.KindString:
+		return append(fields, zap.String(a.Key, a.Value.String()))
+	case slog.KindTime:
+		return append(fields, zap.Time(a.Key, a.Value.Time()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(a.Key, a.Value.Uint64()))
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return fields
+		}
+		if a.Key == "" {
+			for _, ga := range attrs {
+				fields = appendAttr(fields, ga)
+			}
+			return fields
+		}
+		return append(fields, zap.Object(a.Key, attrGroup(attrs)))
+	}
+	if err, ok := a.Value.Any().(error); ok {
+		return append(fields, zap.NamedError(a.Key, err))
+	}
+	return append(fields, zap.Any(a.Key, a.Value.Any()))
+}
+
+// attrGroup encodes the attributes of a slog group as an object.
+type attrGroup []slog.Attr
+
+func (g attrGroup) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, a := range g {
+		for _, f := range appendAttr(nil, a) {
+			f.AddTo(enc)
+		}
+	}
+	return nil
+}
+
+// zapLevelOf maps a slog level onto the highest zap level not above it.
+func zapLevelOf(level slog.Level) zapcore.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case level < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case level < slog.LevelError:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
+
+// slogLevelOf maps a zap level onto slog. Levels above ErrorLevel, which
+// slog does not name, are rendered as ERROR+1 and so on.
+func slogLevelOf(level zapcore.Level) slog.Level {
+	switch level {
+	case zapcore.DebugLevel:
+		return slog.LevelDebug
+	case zapcore.InfoLevel:
+		return slog.LevelInfo
+	case zapcore.WarnLevel:
+		return slog.LevelWarn
+	}
+	return slog.LevelError + slog.Level(level-zapcore.ErrorLevel)
+}
+
+// NewSlogCore returns a core that hands records to h instead of encoding
+// them, letting the glog and logrus shims emit through a slog.Handler
+// supplied by the application. Records below enab are dropped. See
+// Config.SlogHandler.
+func NewSlogCore(h slog.Handler, enab zapcore.LevelEnabler) zapcore.Core {
+	return &slogCore{LevelEnabler: enab, h: h}
+}
+
+type slogCore struct {
+	zapcore.LevelEnabler
+	h slog.Handler
+	// top is the handler before the first namespace opened by With and
+	// nested the fields added from that namespace on, from which the
+	// logger name is added outside the namespaces.
+	top    slog.Handler
+	nested []zapcore.Field
+}
+
+func (c *slogCore) Enabled(lvl zapcore.Level) bool {
+	return c.LevelEnabler.Enabled(lvl) && c.h.Enabled(context.Background(), slogLevelOf(lvl))
+}
+
+func (c *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &slogCore{LevelEnabler: c.LevelEnabler, h: withFields(c.h, fields), top: c.top}
+	if c.nested != nil {
+		clone.nested = append(append([]zapcore.Field(nil), c.nested...), fields...)
+		return clone
+	}
+	for i, f := range fields {
+		if f.Type == zapcore.NamespaceType {
+			clone.top = withFields(c.h, fields[:i])
+			clone.nested = append([]zapcore.Field(nil), fields[i:]...)
+			break
+		}
+	}
+	return clone
+}
+
+// withFields returns h with fields added, namespaces becoming groups.
+func withFields(h slog.Handler, fields []zapcore.Field) slog.Handler {
+	enc := &attrEncoder{}
+	for _, f := range fields {
+		if f.Type == zapcore.NamespaceType {
+			h = h.WithAttrs(enc.attrs()).WithGroup(f.Key)
+			enc = &attrEncoder{}
+			continue
+		}
+		f.AddTo(enc)
+	}
+	return h.WithAttrs(enc.attrs())
+}
+
+func (c *slogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *slogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var pc uintptr
+	if ent.Caller.Defined && ent.Caller.PC != 0 {
+		// EntryCaller holds the PC of the call instruction, while slog
+		// expects a return address as found by runtime.Callers.
+		pc = ent.Caller.PC + 1
+	}
+	r := slog.NewRecord(ent.Time, slogLevelOf(ent.Level), ent.Message, pc)
+	h := c.h
+	if ent.LoggerName != "" {
+		logger := slog.String("logger", ent.LoggerName)
+		if c.nested != nil {
+			// the name goes outside the namespaces opened by With
+			h = withFields(c.top.WithAttrs([]slog.Attr{logger}), c.nested)
+		} else {
+			r.AddAttrs(logger)
+		}
+	}
+	enc := &attrEncoder{}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	r.AddAttrs(enc.attrs()...)
+	if ent.Stack != "" {
+		r.AddAttrs(slog.String("stacktrace", ent.Stack))
+	}
+	return h.Handle(context.Background(), r)
+}
+
+func (c *slogCore) Sync() error {
+	return nil
+}
+
+// attrEncoder is a zapcore.ObjectEncoder building slog attributes, so that
+// fields keep their types and order on their way to a slog.Handler.
+type attrEncoder struct {
+	list []slog.Attr
+	// open holds the namespaces opened so far; later attributes nest in the
+	// innermost one.
+	open []*attrEncoder
+	key  string
+}
+
+func (e *attrEncoder) add(a slog.Attr) {
+	if n := len(e.open); n > 0 {
+		e.open[n-1].list = append(e.open[n-1].list, a)
+		return
+	}
+	e.list = append(e.list, a)
+}
+
+func (e *attrEncoder) attrs() []slog.Attr {
+	for i := len(e.open) - 1; i >= 0; i-- {
+		ns := e.open[i]
+		group := slog.Attr{Key: ns.key, Value: slog.GroupValue(ns.list...)}
+		if i > 0 {
+			e.open[i-1].list = append(e.open[i-1].list, group)
+		} else {
+			e.list = append(e.list, group)
+		}
+	}
+	e.open = nil
+	return e.list
+}
+
+func (e *attrEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	m := zapcore.NewMapObjectEncoder()
+	err := m.AddArray(key, arr)
+	e.add(slog.Any(key, m.Fields[key]))
+	return err
+}
+
+func (e *attrEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	nested := &attrEncoder{}
+	err := obj.MarshalLogObject(nested)
+	e.add(slog.Attr{Key: key, Value: slog.GroupValue(nested.attrs()...)})
+	return err
+}
+
+func (e *attrEncoder) AddBinary(key string, v []byte) {
+	e.add(slog.String(key, base64.StdEncoding.EncodeToString(v)))
+}
+func (e *attrEncoder) AddByteString(key string, v []byte)     { e.add(slog.String(key, string(v))) }
+func (e *attrEncoder) AddBool(key string, v bool)             { e.add(slog.Bool(key, v)) }
+func (e *attrEncoder) AddComplex128(key string, v complex128) { e.add(slog.Any(key, v)) }
+func (e *attrEncoder) AddComplex64(key string, v complex64)   { e.add(slog.Any(key, v)) }
+func (e *attrEncoder) AddDuration(key string, v time.Duration) {
+	e.add(slog.Duration(key, v))
+}
+func (e *attrEncoder) AddFloat64(key string, v float64) { e.add(slog.Float64(key, v)) }
+func (e *attrEncoder) AddFloat32(key string, v float32) { e.add(slog.Float64(key, float64(v))) }
+func (e *attrEncoder) AddInt(key string, v int)         { e.add(slog.Int(key, v)) }
+func (e *attrEncoder) AddInt64(key string, v int64)     { e.add(slog.Int64(key, v)) }
+func (e *attrEncoder) AddInt32(key string, v int32)     { e.add(slog.Int64(key, int64(v))) }
+func (e *attrEncoder) AddInt16(key string, v int16)     { e.add(slog.Int64(key, int64(v))) }
+func (e *attrEncoder) AddInt8(key string, v int8)       { e.add(slog.Int64(key, int64(v))) }
+func (e *attrEncoder) AddString(key, v string)          { e.add(slog.String(key, v)) }
+func (e *attrEncoder) AddTime(key string, v time.Time)  { e.add(slog.Time(key, v)) }
+func (e *attrEncoder) AddUint(key string, v uint)       { e.add(slog.Uint64(key, uint64(v))) }
+func (e *attrEncoder) AddUint64(key string, v uint64)   { e.add(slog.Uint64(key, v)) }
+func (e *attrEncoder) AddUint32(key string, v uint32)   { e.add(slog.Uint64(key, uint64(v))) }
+func (e *attrEncoder) AddUint16(key string, v uint16)   { e.add(slog.Uint64(key, uint64(v))) }
+func (e *attrEncoder) AddUint8(key string, v uint8)     { e.add(slog.Uint64(key, uint64(v))) }
+func (e *attrEncoder) AddUintptr(key string, v uintptr) { e.add(slog.Uint64(key, uint64(v))) }
+func (e *attrEncoder) AddReflected(key string, v interface{}) error {
+	e.add(slog.Any(key, v))
+	return nil
+}
+func (e *attrEncoder) OpenNamespace(key string) {
+	e.open = append(e.open, &attrEncoder{key: key})
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// secret is a LogValuer hiding its value.
+type secret string
+
+func (secret) LogValue() slog.Value { return slog.StringValue("hidden") }
+
+func TestSlogHandler(t *testing.T) {
+	obs, logs := observer.New(zapcore.InfoLevel)
+	logger := slog.New(NewSlogHandler(obs))
+	ctx := context.Background()
+
+	if logger.Enabled(ctx, slog.LevelDebug) || !logger.Enabled(ctx, slog.LevelInfo+2) {
+		t.Error("levels below InfoLevel enabled or levels between Info and Warn disabled")
+	}
+	logger.Debug("dropped")
+	logger.Log(ctx, slog.LevelInfo+2, "notice")
+	logger.Log(ctx, slog.LevelError+4, "critical")
+	logger.Warn("attrs",
+		"password", secret("hunter2"),
+		"err", errors.New("disk gone"),
+		slog.Group("", "inlined", 1),
+		slog.Group("nothing"),
+	)
+	logger.WithGroup("req").With("id", 7).WithGroup("pool").Info("nested", "name", "a")
+	// a group without attributes is left out
+	logger.WithGroup("unused").Info("bare")
+
+	all := logs.AllUntimed()
+	if len(all) != 5 {
+		t.Fatalf("logged %d records, want 5: %v", len(all), all)
+	}
+	for i, level := range []zapcore.Level{zapcore.InfoLevel, zapcore.ErrorLevel, zapcore.WarnLevel, zapcore.InfoLevel, zapcore.InfoLevel} {
+		if all[i].Level != level {
+			t.Errorf("%q at %v, want %v", all[i].Message, all[i].Level, level)
+		}
+		if !strings.HasSuffix(all[i].Caller.File, "slog_test.go") {
+			t.Errorf("%q from %s, want this file", all[i].Message, all[i].Caller.File)
+		}
+	}
+	if got, want := all[2].ContextMap(), map[string]interface{}{
+		"password": "hidden",
+		"err":      "disk gone",
+		"inlined":  int64(1),
+	}; !equalJSON(got, want) {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+	if got, want := all[3].ContextMap(), map[string]interface{}{
+		"req": map[string]interface{}{"id": int64(7), "pool": map[string]interface{}{"name": "a"}},
+	}; !equalJSON(got, want) {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+	if got := all[4].ContextMap(); len(got) != 0 {
+		t.Errorf("fields %v, want none", got)
+	}
+}
+
+func TestSlogCore(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo})
+	core := NewSlogCore(h, zapcore.DebugLevel)
+	if core.Enabled(zapcore.DebugLevel) {
+		t.Error("DebugLevel enabled below the level of the handler")
+	}
+
+	logger := zap.New(core, zap.AddCaller()).Named("pool")
+	logger.Debug("dropped")
+	logger.With(zap.String("a", "1"), zap.Namespace("ns"), zap.Int("n", 2)).
+		DPanic("failed", zap.Strings("disks", []string{"sda"}), zap.Object("ref", KRef("x", "y")))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	source, _ := got["source"].(map[string]interface{})
+	if file, _ := source["file"].(string); !strings.HasSuffix(file, "slog_test.go") {
+		t.Errorf("source %v, want this file", got["source"])
+	}
+	delete(got, "time")
+	delete(got, "source")
+	want := map[string]interface{}{
+		"level":  "ERROR+1",
+		"msg":    "failed",
+		"a":      "1",
+		"logger": "pool",
+		"ns": map[string]interface{}{
+			"n":     2,
+			"disks": []interface{}{"sda"},
+			"ref":   map[string]interface{}{"namespace": "x", "name": "y"},
+		},
+	}
+	if !equalJSON(got, want) {
+		t.Errorf("got %s", buf.Bytes())
+	}
+}
+
+// equalJSON reports whether a and b have the same JSON rendering.
+func equalJSON(a, b interface{}) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
@@ -0,0 +1,948 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SpoolPolicy selects what a spool does with a record that does not fit.
+type SpoolPolicy string
+
+const (
+	// SpoolDropNewest drops the records that do not fit, keeping the
+	// oldest ones, which tend to explain an outage. It is the default.
+	SpoolDropNewest SpoolPolicy = "drop-newest"
+	// SpoolDropOldest drops the oldest segment to make room.
+	SpoolDropOldest SpoolPolicy = "drop-oldest"
+	// SpoolBlock blocks the logging goroutine until delivery makes room,
+	// for up to BlockTimeout, and then drops the record.
+	SpoolBlock SpoolPolicy = "block"
+)
+
+// SpoolConfig sets up a write-ahead spool in front of a sink, so that the
+// records a remote sink cannot deliver outlive an outage and a restart.
+//
+// Records are appended to segment files in Dir before the sink sees them,
+// and handed to it every FlushInterval in batches. A batch is checkpointed
+// once the Sync of the sink succeeds; until then it is retried with
+// exponential backoff, and replayed by the next process spooling to Dir.
+// Delivery is therefore at least once: a batch whose Sync failed is sent
+// again, including the records the sink managed to deliver.
+//
+// Dir is locked while a spool uses it, and opening another spool on it
+// fails. Configure is the exception: the spools of the configuration it
+// installs take over the Dir of those of the one it replaces once the new
+// core is in place. Until then the records go to the old spool, and those
+// it leaves are delivered by the new one.
+type SpoolConfig struct {
+	// Dir holds the segments and the checkpoint. Each sink needs its own.
+	Dir string `json:"dir" yaml:"dir"`
+	// SegmentSize is the size in bytes beyond which a new segment is
+	// started; 4MiB by default.
+	SegmentSize int64 `json:"segmentSize" yaml:"segmentSize"`
+	// MaxSize is the size in bytes of the spool beyond which Policy
+	// applies; 256MiB by default.
+	MaxSize int64 `json:"maxSize" yaml:"maxSize"`
+	// MaxAge is the age beyond which undelivered segments are dropped;
+	// 72h by default, negative to keep them.
+	MaxAge time.Duration `json:"maxAge" yaml:"maxAge"`
+	// Policy applies when the spool is full.
+	Policy SpoolPolicy `json:"policy" yaml:"policy"`
+	// BlockTimeout bounds how long SpoolBlock blocks; 1s by default.
+	BlockTimeout time.Duration `json:"blockTimeout" yaml:"blockTimeout"`
+	// FlushInterval is how often the active segment is fsynced and the
+	// spooled records are handed to the sink; 1s by default.
+	FlushInterval time.Duration `json:"flushInterval" yaml:"flushInterval"`
+	// SyncWrites fsyncs every record, at a cost in throughput, instead of
+	// every FlushInterval.
+	SyncWrites bool `json:"syncWrites" yaml:"syncWrites"`
+	// BatchSize is the number of records handed to the sink between
+	// checkpoints; 512 by default.
+	BatchSize int `json:"batchSize" yaml:"batchSize"`
+	// MinBackoff and MaxBackoff bound the delay before a batch is retried,
+	// which doubles after each failure; 1s and 1m by default.
+	MinBackoff time.Duration `json:"minBackoff" yaml:"minBackoff"`
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+	// MaxAttempts, when positive, is the number of attempts after which a
+	// batch is dropped, so that records the sink always rejects do not
+	// hold up the spool until they expire.
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts"`
+}
+
+// NewSpool returns a core spooling the records core receives to disk as
+// described by cfg, and handing them to core from a background goroutine.
+// Records left in cfg.Dir by a previous process are replayed first.
+//
+// Its Sync fsyncs the spool and hands the spooled records to core for up to
+// cfg.FlushInterval, and reports the records dropped since the last Sync and
+// those left pending. Its Close stops the spool, leaving the records not
+// delivered in cfg.Dir; it does not close core.
+func NewSpool(core zapcore.Core, cfg SpoolConfig) (zapcore.Core, error) {
+	c, err := newSpool(core, cfg, false)
+	if err != nil {
+		return nil, fmt.Errorf("mlogger: spool: %v", err)
+	}
+	return c, nil
+}
+
+// newSpool is NewSpool. takeover is set for the pipelines Configure
+// installs, whose spools may take over the Dir of the spools of the
+// pipeline installed before.
+func newSpool(core zapcore.Core, cfg SpoolConfig, takeover bool) (zapcore.Core, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("no dir")
+	}
+	switch cfg.Policy {
+	case "":
+		cfg.Policy = SpoolDropNewest
+	case SpoolDropNewest, SpoolDropOldest, SpoolBlock:
+	default:
+		return nil, fmt.Errorf("unknown policy %q", cfg.Policy)
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 256 << 20
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 4 << 20
+	}
+	if cfg.SegmentSize > cfg.MaxSize/4 {
+		// dropping the oldest segment must leave most of the spool
+		cfg.SegmentSize = cfg.MaxSize / 4
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 72 * time.Hour
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = time.Second
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Dir = dir
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	spoolsMu.Lock()
+	prev := spools[cfg.Dir]
+	spoolsMu.Unlock()
+	if prev != nil {
+		if !takeover || !prev.configured {
+			return nil, fmt.Errorf("%s is in use", cfg.Dir)
+		}
+		// opened by commit once the pipeline is installed
+		return &spoolCore{ref: &spoolRef{cfg: cfg, dest: core, s: prev, pending: true}}, nil
+	}
+	s, err := startSpool(core, cfg, takeover)
+	if err != nil {
+		return nil, err
+	}
+	return &spoolCore{ref: &spoolRef{cfg: cfg, dest: core, s: s}}, nil
+}
+
+// startSpool locks cfg.Dir and starts forwarding its records to core.
+func startSpool(core zapcore.Core, cfg SpoolConfig, configured bool) (*spool, error) {
+	lock, err := lockDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openSpool(core, cfg)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.lock = lock
+	s.configured = configured
+	spoolsMu.Lock()
+	spools[cfg.Dir] = s
+	spoolsMu.Unlock()
+	go s.run()
+	return s, nil
+}
+
+// spools maps the directories of the open spools to them.
+var (
+	spoolsMu sync.Mutex
+	spools   = map[string]*spool{}
+)
+
+// openSpool loads the spool of cfg.Dir, which the caller has locked.
+func openSpool(core zapcore.Core, cfg SpoolConfig) (*spool, error) {
+	ack, err := readCheckpoint(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := loadSegments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		cfg:     cfg,
+		dest:    core,
+		segs:    segs,
+		ack:     ack,
+		next:    ack.seq + 1,
+		space:   make(chan struct{}),
+		syncs:   make(chan chan error),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, seg := range segs {
+		s.size += seg.size
+		if seg.seq >= s.next {
+			s.next = seg.seq + 1
+		}
+		if seg.seq == ack.seq {
+			seg.acked = countFrames(cfg.Dir, *seg, ack.off)
+		}
+	}
+	s.normalize()
+	return s, nil
+}
+
+// countFrames returns the number of records of seg before off.
+func countFrames(dir string, seg segment, off int64) int {
+	seg.size = off
+	frames, _ := readFrames(dir, []segment{seg}, spoolPos{seq: seg.seq}, seg.n)
+	return len(frames)
+}
+
+// spool holds the segments of a spool directory. The forwarder, the
+// goroutine running run, hands the records to the sink and advances ack.
+type spool struct {
+	cfg  SpoolConfig
+	dest zapcore.Core
+	lock io.Closer
+	// configured is set for the spools of the pipeline installed by
+	// Configure, which the next one takes over.
+	configured bool
+
+	mu sync.Mutex
+	// segs lists the segments, oldest first. The last one is being
+	// appended to when cur is set.
+	segs []*segment
+	cur  *os.File
+	next uint64
+	// size is the size of the segments.
+	size     int64
+	unsynced bool
+	// ack is the position up to which records were delivered.
+	ack spoolPos
+	// space is closed, and replaced, whenever segments are removed.
+	space chan struct{}
+	// dropped counts the records dropped since the last sync.
+	dropped int
+	// lastErr is the last error spooling or delivering, reported by sync.
+	lastErr error
+	// closed is set once Close has stopped the forwarder.
+	closed bool
+
+	syncs chan chan error
+	// done is closed to stop the forwarder, which closes stopped.
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	// owned by the forwarder
+	attempts int
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+// append appends a frame to the active segment, applying the policy when
+// it does not fit.
+func (s *spool) append(frame []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.dropped++
+		return nil
+	}
+	var deadline time.Time
+	for s.size+int64(len(frame)) > s.cfg.MaxSize {
+		switch s.cfg.Policy {
+		case SpoolDropOldest:
+			if s.dropOldest() {
+				continue
+			}
+		case SpoolBlock:
+			if deadline.IsZero() {
+				deadline = time.Now().Add(s.cfg.BlockTimeout)
+			}
+			if s.waitSpace(deadline) {
+				continue
+			}
+		}
+		s.dropped++
+		return nil
+	}
+
+	if s.cur == nil || s.segs[len(s.segs)-1].size >= s.cfg.SegmentSize {
+		if err := s.roll(); err != nil {
+			s.lastErr = err
+			s.dropped++
+			return err
+		}
+	}
+	seg := s.segs[len(s.segs)-1]
+	if _, err := s.cur.Write(frame); err != nil {
+		// leave no torn frame behind the next one
+		s.cur.Truncate(seg.size)
+		s.lastErr = err
+		s.dropped++
+		return err
+	}
+	seg.size += int64(len(frame))
+	seg.n++
+	seg.last = time.Now()
+	s.size += int64(len(frame))
+	s.unsynced = true
+	if s.cfg.SyncWrites {
+		return s.syncFile()
+	}
+	return nil
+}
+
+// roll closes the active segment and starts a new one.
+func (s *spool) roll() error {
+	if s.cur != nil {
+		if err := s.syncFile(); err != nil {
+			return err
+		}
+		err := s.cur.Close()
+		s.cur = nil
+		if err != nil {
+			return err
+		}
+		s.normalize()
+	}
+	seq := s.next
+	path := segmentPath(s.cfg.Dir, seq)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(s.cfg.Dir); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	s.next++
+	s.cur = f
+	s.segs = append(s.segs, &segment{seq: seq, last: time.Now()})
+	return nil
+}
+
+func (s *spool) syncFile() error {
+	if s.cur == nil || !s.unsynced {
+		return nil
+	}
+	s.unsynced = false
+	return s.cur.Sync()
+}
+
+// waitSpace waits until segments are removed or deadline passes, and
+// reports whether they were.
+func (s *spool) waitSpace(deadline time.Time) bool {
+	d := time.Until(deadline)
+	if d <= 0 {
+		return false
+	}
+	space := s.space
+	s.mu.Unlock()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	var freed bool
+	select {
+	case <-space:
+		freed = true
+	case <-timer.C:
+	}
+	s.mu.Lock()
+	return freed
+}
+
+// dropOldest removes the oldest segment unless it is the active one, and
+// reports whether it did.
+func (s *spool) dropOldest() bool {
+	if len(s.segs) == 0 || len(s.segs) == 1 && s.cur != nil {
+		return false
+	}
+	seg := s.segs[0]
+	s.dropped += seg.n - seg.acked
+	s.remove()
+	s.normalize()
+	return true
+}
+
+// expire removes the segments last appended to before MaxAge.
+func (s *spool) expire() {
+	if s.cfg.MaxAge < 0 {
+		return
+	}
+	limit := time.Now().Add(-s.cfg.MaxAge)
+	for len(s.segs) > 0 && s.segs[0].last.Before(limit) {
+		seg := s.segs[0]
+		if len(s.segs) == 1 && s.cur != nil {
+			s.cur.Close()
+			s.cur = nil
+		}
+		s.dropped += seg.n - seg.acked
+		s.remove()
+	}
+	s.normalize()
+}
+
+// remove removes the oldest segment.
+func (s *spool) remove() {
+	seg := s.segs[0]
+	if err := os.Remove(segmentPath(s.cfg.Dir, seg.seq)); err != nil && !os.IsNotExist(err) {
+		s.lastErr = err
+	}
+	s.segs[0] = nil
+	s.segs = s.segs[1:]
+	s.size -= seg.size
+	close(s.space)
+	s.space = make(chan struct{})
+}
+
+// normalize removes the delivered segments, but the active one, and moves
+// ack past the removed ones.
+func (s *spool) normalize() {
+	for len(s.segs) > 0 {
+		seg := s.segs[0]
+		delivered := seg.seq < s.ack.seq || seg.seq == s.ack.seq && s.ack.off >= seg.size
+		if !delivered || len(s.segs) == 1 && s.cur != nil {
+			break
+		}
+		s.remove()
+	}
+	if len(s.segs) > 0 && s.ack.seq < s.segs[0].seq {
+		s.ack = spoolPos{seq: s.segs[0].seq}
+	}
+}
+
+// commit records the delivery of the records up to end, counts holding
+// their number per segment.
+func (s *spool) commit(end spoolPos, counts map[uint64]int) error {
+	s.mu.Lock()
+	for _, seg := range s.segs {
+		seg.acked += counts[seg.seq]
+	}
+	if end.seq > s.ack.seq || end.seq == s.ack.seq && end.off > s.ack.off {
+		// segments dropped meanwhile may have moved ack further
+		s.ack = end
+	}
+	s.normalize()
+	ack := s.ack
+	s.mu.Unlock()
+	return writeCheckpoint(s.cfg.Dir, ack)
+}
+
+func (s *spool) setErr(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+}
+
+func (s *spool) sync() error {
+	done := make(chan error, 1)
+	select {
+	case s.syncs <- done:
+		return <-done
+	case <-s.done:
+		return s.status()
+	}
+}
+
+// Close stops the forwarder and closes the active segment, leaving the
+// records not delivered for the next spool of the directory.
+func (s *spool) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+		s.mu.Lock()
+		s.closed = true
+		err := s.syncFile()
+		if s.cur != nil {
+			if cerr := s.cur.Close(); err == nil {
+				err = cerr
+			}
+			s.cur = nil
+		}
+		s.mu.Unlock()
+		if lerr := s.lock.Close(); err == nil {
+			err = lerr
+		}
+		spoolsMu.Lock()
+		if spools[s.cfg.Dir] == s {
+			delete(spools, s.cfg.Dir)
+		}
+		spoolsMu.Unlock()
+		s.closeErr = err
+	})
+	return s.closeErr
+}
+
+func (s *spool) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.maintain()
+			if !time.Now().Before(s.retryAt) {
+				s.forward(time.Now().Add(s.cfg.FlushInterval))
+			}
+		case done := <-s.syncs:
+			s.maintain()
+			s.forward(time.Now().Add(s.cfg.FlushInterval))
+			done <- s.status()
+		}
+	}
+}
+
+// maintain fsyncs the active segment and expires the old ones.
+func (s *spool) maintain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.syncFile(); err != nil {
+		s.lastErr = err
+	}
+	s.expire()
+}
+
+// forward hands the spooled records to the sink in batches, syncing it
+// and checkpointing after each, until none is left, a sync fails, deadline
+// passes or the spool is closed. The rest is left to the next call.
+func (s *spool) forward(deadline time.Time) {
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if !time.Now().Before(deadline) {
+			return
+		}
+		s.mu.Lock()
+		segs := make([]segment, len(s.segs))
+		for i, seg := range s.segs {
+			segs[i] = *seg
+		}
+		pos := s.ack
+		s.mu.Unlock()
+
+		frames, err := readFrames(s.cfg.Dir, segs, pos, s.cfg.BatchSize)
+		if err != nil {
+			s.setErr(err)
+		}
+		if len(frames) == 0 {
+			return
+		}
+		counts := make(map[uint64]int)
+		for _, fr := range frames {
+			if fr.record == nil {
+				continue
+			}
+			counts[fr.seq]++
+			ent, fields, err := decodeSpooled(fr.record)
+			if err != nil {
+				s.setErr(err)
+				continue
+			}
+			if err := writeThrough(s.dest, ent, fields); err != nil {
+				s.setErr(err)
+			}
+		}
+
+		if err := s.dest.Sync(); err != nil {
+			s.setErr(err)
+			s.attempts++
+			if s.cfg.MaxAttempts <= 0 || s.attempts < s.cfg.MaxAttempts {
+				if s.backoff == 0 {
+					s.backoff = s.cfg.MinBackoff
+				} else if s.backoff *= 2; s.backoff > s.cfg.MaxBackoff {
+					s.backoff = s.cfg.MaxBackoff
+				}
+				s.retryAt = time.Now().Add(s.backoff)
+				return
+			}
+			// given up, as delivered
+			s.mu.Lock()
+			for _, n := range counts {
+				s.dropped += n
+			}
+			s.mu.Unlock()
+		}
+		s.attempts, s.backoff, s.retryAt = 0, 0, time.Time{}
+		if err := s.commit(frames[len(frames)-1].end, counts); err != nil {
+			s.setErr(err)
+			return
+		}
+	}
+}
+
+// status reports the records dropped since the last call and those left
+// pending, with the last error.
+func (s *spool) status() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var problems []string
+	if s.dropped > 0 {
+		problems = append(problems, fmt.Sprintf("%d records dropped", s.dropped))
+		s.dropped = 0
+	}
+	pending := 0
+	for _, seg := range s.segs {
+		pending += seg.n - seg.acked
+	}
+	if pending > 0 {
+		problems = append(problems, fmt.Sprintf("%d records pending", pending))
+	}
+	lastErr := s.lastErr
+	if pending == 0 {
+		s.lastErr = nil
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	msg := "mlogger: spool: " + strings.Join(problems, ", ")
+	if lastErr != nil {
+		msg += ": " + lastErr.Error()
+	}
+	return errors.New(msg)
+}
+
+type spoolCore struct {
+	ref *spoolRef
+	// context holds the fields added with With, spooled with each record
+	// since the sink only sees records replayed from disk.
+	context []zapcore.Field
+}
+
+// spoolRef is the spool shared by a spoolCore and the cores derived from
+// it. A spool taking over the Dir of the previous pipeline is pending
+// until commit opens it: records go to the spool taken over meanwhile.
+type spoolRef struct {
+	cfg  SpoolConfig
+	dest zapcore.Core
+
+	mu      sync.RWMutex
+	s       *spool
+	pending bool
+	// err is why commit failed to open the spool, leaving s nil.
+	err error
+}
+
+func (r *spoolRef) append(frame []byte) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.s == nil {
+		return r.err
+	}
+	return r.s.append(frame)
+}
+
+func (r *spoolRef) sync() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.s == nil {
+		return r.err
+	}
+	if r.pending {
+		// the spool taken over reports to its own pipeline
+		return nil
+	}
+	return r.s.sync()
+}
+
+// commit stops the spool taken over and opens the pending one on its Dir.
+func (r *spoolRef) commit() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.pending {
+		return nil
+	}
+	r.pending = false
+	r.s.Close()
+	r.s, r.err = startSpool(r.dest, r.cfg, true)
+	if r.err != nil {
+		r.err = fmt.Errorf("mlogger: spool: %v", r.err)
+	}
+	return r.err
+}
+
+func (r *spoolRef) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending {
+		// the spool taken over belongs to the pipeline still installed
+		r.s, r.pending = nil, false
+		return nil
+	}
+	if r.s == nil {
+		return nil
+	}
+	return r.s.Close()
+}
+
+func (c *spoolCore) Enabled(lvl zapcore.Level) bool {
+	return c.ref.dest.Enabled(lvl)
+}
+
+func (c *spoolCore) With(fields []zapcore.Field) zapcore.Core {
+	context := make([]zapcore.Field, 0, len(c.context)+len(fields))
+	context = append(context, c.context...)
+	return &spoolCore{ref: c.ref, context: append(context, fields...)}
+}
+
+func (c *spoolCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *spoolCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.context) > 0 {
+		all := make([]zapcore.Field, 0, len(c.context)+len(fields))
+		all = append(all, c.context...)
+		fields = append(all, fields...)
+	}
+	record, err := encodeSpooled(ent, fields)
+	if err != nil {
+		return err
+	}
+	if err := c.ref.append(appendFrame(nil, record)); err != nil {
+		return fmt.Errorf("mlogger: spool: %v", err)
+	}
+	return nil
+}
+
+func (c *spoolCore) Sync() error {
+	return c.ref.sync()
+}
+
+func (c *spoolCore) Close() error {
+	return c.ref.close()
+}
+
+func (c *spoolCore) commit() error {
+	return c.ref.commit()
+}
+
+// spooledRecord is the form records take on disk, encoded as JSON.
+type spooledRecord struct {
+	Time    int64          `json:"t"`
+	Level   zapcore.Level  `json:"l"`
+	Logger  string         `json:"n,omitempty"`
+	Message string         `json:"m"`
+	File    string         `json:"f,omitempty"`
+	Line    int            `json:"ln,omitempty"`
+	Stack   string         `json:"s,omitempty"`
+	Fields  []spooledField `json:"x,omitempty"`
+}
+
+// spooledField is a field on disk. Fields are replayed with their type,
+// but for those backed by a value of the caller: errors are replayed with
+// their message, stringers as strings, and marshalers and reflected values
+// as the maps and slices their JSON encoding decodes to. ObjectRef, which
+// sinks look for, is kept.
+type spooledField struct {
+	Key     string            `json:"k"`
+	Type    zapcore.FieldType `json:"t"`
+	Integer int64             `json:"i,omitempty"`
+	String  string            `json:"s,omitempty"`
+	Value   json.RawMessage   `json:"v,omitempty"`
+}
+
+func encodeSpooled(ent zapcore.Entry, fields []zapcore.Field) ([]byte, error) {
+	r := spooledRecord{
+		Time:    ent.Time.UnixNano(),
+		Level:   ent.Level,
+		Logger:  ent.LoggerName,
+		Message: ent.Message,
+		Stack:   ent.Stack,
+	}
+	if ent.Caller.Defined {
+		r.File, r.Line = ent.Caller.File, ent.Caller.Line
+	}
+	r.Fields = make([]spooledField, 0, len(fields))
+	for _, f := range fields {
+		if f.Type == zapcore.SkipType {
+			continue
+		}
+		r.Fields = append(r.Fields, spoolField(f))
+	}
+	return json.Marshal(r)
+}
+
+func spoolField(f zapcore.Field) spooledField {
+	sf := spooledField{Key: f.Key, Type: f.Type, Integer: f.Integer, String: f.String}
+	switch f.Type {
+	case zapcore.TimeType:
+		if loc, ok := f.Interface.(*time.Location); ok {
+			sf.String = loc.String()
+		}
+		return sf
+	case zapcore.BinaryType, zapcore.ByteStringType:
+		sf.Value, _ = json.Marshal(f.Interface)
+		return sf
+	case zapcore.Complex128Type, zapcore.Complex64Type:
+		var c complex128
+		switch v := f.Interface.(type) {
+		case complex128:
+			c = v
+		case complex64:
+			c = complex128(v)
+		}
+		sf.Type = zapcore.Complex128Type
+		sf.Value, _ = json.Marshal([2]float64{real(c), imag(c)})
+		return sf
+	case zapcore.ErrorType:
+		err, _ := f.Interface.(error)
+		if err == nil {
+			break
+		}
+		sf.String = err.Error()
+		if _, ok := err.(fmt.Formatter); ok {
+			if verbose := fmt.Sprintf("%+v", err); verbose != sf.String {
+				sf.Value, _ = json.Marshal(verbose)
+			}
+		}
+		return sf
+	case zapcore.ObjectMarshalerType:
+		if ref, ok := f.Interface.(ObjectRef); ok {
+			sf.Value, _ = json.Marshal(ref)
+			return sf
+		}
+	case zapcore.NamespaceType:
+		return sf
+	default:
+		if f.Interface == nil {
+			return sf
+		}
+	}
+	// render the others through an encoder, as they would be logged
+	m := zapcore.NewMapObjectEncoder()
+	f.AddTo(m)
+	v := m.Fields[f.Key]
+	if str, ok := v.(string); ok {
+		return spooledField{Key: f.Key, Type: zapcore.StringType, String: str}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return spooledField{Key: f.Key, Type: zapcore.ReflectType, Value: b}
+}
+
+func decodeSpooled(b []byte) (zapcore.Entry, []zapcore.Field, error) {
+	var r spooledRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return zapcore.Entry{}, nil, err
+	}
+	ent := zapcore.Entry{
+		Level:      r.Level,
+		Time:       time.Unix(0, r.Time),
+		LoggerName: r.Logger,
+		Message:    r.Message,
+		Stack:      r.Stack,
+	}
+	if r.File != "" {
+		ent.Caller = zapcore.NewEntryCaller(0, r.File, r.Line, true)
+	}
+	fields := make([]zapcore.Field, 0, len(r.Fields))
+	for _, sf := range r.Fields {
+		fields = append(fields, sf.field())
+	}
+	return ent, fields, nil
+}
+
+func (sf spooledField) field() zapcore.Field {
+	f := zapcore.Field{Key: sf.Key, Type: sf.Type, Integer: sf.Integer, String: sf.String}
+	switch sf.Type {
+	case zapcore.TimeType:
+		if sf.String != "" {
+			f.String = ""
+			loc, err := time.LoadLocation(sf.String)
+			if err != nil {
+				loc = time.UTC
+			}
+			f.Interface = loc
+		}
+	case zapcore.BinaryType, zapcore.ByteStringType:
+		var b []byte
+		json.Unmarshal(sf.Value, &b)
+		f.Interface = b
+	case zapcore.Complex128Type:
+		var c [2]float64
+		json.Unmarshal(sf.Value, &c)
+		f.Interface = complex(c[0], c[1])
+	case zapcore.ErrorType:
+		err := &spooledError{msg: sf.String}
+		json.Unmarshal(sf.Value, &err.verbose)
+		f.String, f.Interface = "", err
+	case zapcore.ObjectMarshalerType:
+		var ref ObjectRef
+		json.Unmarshal(sf.Value, &ref)
+		f.Interface = ref
+	case zapcore.ReflectType:
+		d := json.NewDecoder(bytes.NewReader(sf.Value))
+		d.UseNumber()
+		var v interface{}
+		d.Decode(&v)
+		f.Interface = v
+	}
+	return f
+}
+
+// spooledError is an error replayed from a spool, which formats as the
+// original did with %+v.
+type spooledError struct {
+	msg     string
+	verbose string
+}
+
+func (e *spooledError) Error() string {
+	return e.msg
+}
+
+func (e *spooledError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') && e.verbose != "" {
+		io.WriteString(s, e.verbose)
+		return
+	}
+	io.WriteString(s, e.msg)
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package common
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// lockDir does not lock dir on this system; only spools of the same
+// process exclude each other.
+func lockDir(dir string) (io.Closer, error) {
+	return ioutil.NopCloser(nil), nil
+}
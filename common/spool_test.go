@@ -0,0 +1,412 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// failingSync is a core whose Sync fails, holding the records in a spool.
+type failingSync struct {
+	zapcore.Core
+}
+
+func (c failingSync) Write(zapcore.Entry, []zapcore.Field) error { return nil }
+
+func (c failingSync) Sync() error { return errors.New("down") }
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func testSpoolConfig(dir string) SpoolConfig {
+	return SpoolConfig{Dir: dir, FlushInterval: time.Hour, MinBackoff: time.Hour}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	down, _ := observer.New(zapcore.DebugLevel)
+	core, err := NewSpool(failingSync{down}, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	log := zap.New(core)
+	log.Info("first",
+		zap.Int("n", 1),
+		zap.Time("at", at),
+		zap.Error(errors.New("boom")),
+		zap.Object(ObjectKey, KRef("default", "pool")),
+		zap.Strings("disks", []string{"a", "b"}),
+	)
+	log.With(zap.String("volume", "v1")).Warn("second")
+	if err := core.Sync(); err == nil {
+		t.Fatal("Sync succeeded with the sink down")
+	}
+	if err := core.(interface{ Close() error }).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	up, logs := observer.New(zapcore.DebugLevel)
+	core, err = NewSpool(up, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	all := logs.AllUntimed()
+	if len(all) != 2 {
+		t.Fatalf("replayed %d records, want 2", len(all))
+	}
+	first := all[0]
+	if first.Message != "first" {
+		t.Errorf("message = %q", first.Message)
+	}
+	keys := []string{"n", "at", "error", ObjectKey, "disks"}
+	if len(first.Context) != len(keys) {
+		t.Fatalf("fields = %v", first.Context)
+	}
+	for i, f := range first.Context {
+		if f.Key != keys[i] {
+			t.Errorf("field %d = %q, want %q", i, f.Key, keys[i])
+		}
+	}
+	m := first.ContextMap()
+	if m["n"] != int64(1) {
+		t.Errorf("n = %#v", m["n"])
+	}
+	if got, ok := m["at"].(time.Time); !ok || !got.Equal(at) || got.Location() != time.UTC {
+		t.Errorf("at = %#v", m["at"])
+	}
+	if m["error"] != "boom" || first.Context[2].Type != zapcore.ErrorType {
+		t.Errorf("error = %#v", first.Context[2])
+	}
+	if ref, ok := first.Context[3].Interface.(ObjectRef); !ok || ref != KRef("default", "pool") {
+		t.Errorf("object = %#v", first.Context[3])
+	}
+	if got := all[1].ContextMap()["volume"]; got != "v1" {
+		t.Errorf("volume = %#v", got)
+	}
+}
+
+func TestSpoolTornFrame(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	down, _ := observer.New(zapcore.DebugLevel)
+	core, err := NewSpool(failingSync{down}, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := zap.New(core)
+	log.Info("kept")
+	core.Sync()
+	core.(interface{ Close() error }).Close()
+
+	// a crash in the middle of a write leaves part of a frame
+	segs, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segs) != 1 {
+		t.Fatalf("segments = %v", segs)
+	}
+	f, err := os.OpenFile(segs[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendFrame(nil, []byte(`{"m":"torn"}`))[:10])
+	f.Close()
+
+	up, logs := observer.New(zapcore.DebugLevel)
+	core, err = NewSpool(up, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	zap.New(core).Info("after")
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range logs.AllUntimed() {
+		got = append(got, e.Message)
+	}
+	if len(got) != 2 || got[0] != "kept" || got[1] != "after" {
+		t.Errorf("delivered %q, want [kept after]", got)
+	}
+}
+
+func TestSpoolInUse(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	first, logs := observer.New(zapcore.DebugLevel)
+	core, err := NewSpool(first, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	second, _ := observer.New(zapcore.DebugLevel)
+	if _, err := NewSpool(second, testSpoolConfig(dir)); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second spool on the dir: %v", err)
+	}
+
+	zap.New(core).Info("kept")
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := logs.Len(); n != 1 {
+		t.Errorf("spool delivered %d records, want 1", n)
+	}
+}
+
+func TestSpoolConfigureTakeover(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	spool := testSpoolConfig(filepath.Join(dir, "spool"))
+	config := func(out string, extra ...SinkConfig) Config {
+		cfg := NewConfig()
+		cfg.Sampling = nil
+		cfg.Sinks = append([]SinkConfig{{Paths: []string{filepath.Join(dir, out)}, Spool: &spool}}, extra...)
+		return cfg
+	}
+	read := func(out string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, out))
+		return string(b)
+	}
+	defer Configure(NewConfig())
+
+	if err := Configure(config("first.log")); err != nil {
+		t.Fatal(err)
+	}
+	Logger.Info("first")
+
+	// a configuration failing after its spool is built leaves the
+	// installed spool alone
+	if err := Configure(config("second.log", SinkConfig{Type: "none"})); err == nil {
+		t.Fatal("invalid configuration installed")
+	}
+	if _, err := config("second.log").Build(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Build on the dir of the installed spool: %v", err)
+	}
+	Logger.Info("after failures")
+	if err := Logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("first.log"); !strings.Contains(got, `"msg":"first"`) || !strings.Contains(got, `"msg":"after failures"`) {
+		t.Errorf("first.log: %s", got)
+	}
+
+	if err := Configure(config("second.log")); err != nil {
+		t.Fatal(err)
+	}
+	Logger.Info("second")
+	if err := Logger.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("second.log"); !strings.Contains(got, `"msg":"second"`) {
+		t.Errorf("second.log: %s", got)
+	}
+	if got := read("first.log"); strings.Contains(got, `"msg":"second"`) {
+		t.Errorf("first.log: %s", got)
+	}
+}
+
+// padded is a message making the frame of a record about 1KB.
+var padded = strings.Repeat("x", 1000)
+
+// spoolRecords logs the records named by msgs, each about 1KB.
+func spoolRecords(core zapcore.Core, msgs ...string) {
+	log := zap.New(core)
+	for _, msg := range msgs {
+		log.Info(msg, zap.String("pad", padded))
+	}
+}
+
+// replay returns the messages delivered by a new spool on dir.
+func replay(t *testing.T, dir string) []string {
+	t.Helper()
+	up, logs := observer.New(zapcore.DebugLevel)
+	core, err := NewSpool(up, testSpoolConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, e := range logs.AllUntimed() {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func TestSpoolDropOldest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	down, _ := observer.New(zapcore.DebugLevel)
+	cfg := testSpoolConfig(dir)
+	// room for three records, one per segment
+	cfg.MaxSize = 4000
+	cfg.Policy = SpoolDropOldest
+	core, err := NewSpool(failingSync{down}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoolRecords(core, "r0", "r1", "r2", "r3", "r4")
+	if err := core.Sync(); err == nil || !strings.Contains(err.Error(), "2 records dropped") {
+		t.Errorf("Sync = %v", err)
+	}
+	core.(interface{ Close() error }).Close()
+
+	if got := strings.Join(replay(t, dir), " "); got != "r2 r3 r4" {
+		t.Errorf("delivered %s, want the newest records", got)
+	}
+}
+
+func TestSpoolBlock(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	up, logs := observer.New(zapcore.DebugLevel)
+	cfg := testSpoolConfig(dir)
+	cfg.MaxSize = 4000
+	cfg.Policy = SpoolBlock
+	cfg.BlockTimeout = 10 * time.Second
+	core, err := NewSpool(up, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoolRecords(core, "r0", "r1", "r2")
+
+	// the fourth record waits for delivery to make room
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		core.Sync()
+	}()
+	start := time.Now()
+	spoolRecords(core, "r3")
+	if d := time.Since(start); d < 50*time.Millisecond || d >= cfg.BlockTimeout {
+		t.Errorf("blocked for %v", d)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := logs.Len(); n != 4 {
+		t.Errorf("delivered %d records, want 4", n)
+	}
+	core.(interface{ Close() error }).Close()
+
+	// with the sink down, it is dropped after BlockTimeout
+	down, _ := observer.New(zapcore.DebugLevel)
+	cfg.BlockTimeout = 50 * time.Millisecond
+	core, err = NewSpool(failingSync{down}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	spoolRecords(core, "r4", "r5", "r6")
+	start = time.Now()
+	spoolRecords(core, "r7")
+	if d := time.Since(start); d < cfg.BlockTimeout {
+		t.Errorf("blocked for %v, want %v", d, cfg.BlockTimeout)
+	}
+	if err := core.Sync(); err == nil || !strings.Contains(err.Error(), "1 records dropped, 3 records pending") {
+		t.Errorf("Sync = %v", err)
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	down, _ := observer.New(zapcore.DebugLevel)
+	cfg := testSpoolConfig(dir)
+	cfg.MaxAge = 50 * time.Millisecond
+	core, err := NewSpool(failingSync{down}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoolRecords(core, "r0", "r1")
+	time.Sleep(100 * time.Millisecond)
+	// the expired records are reported once, and none is left
+	if err := core.Sync(); err == nil || err.Error() != "mlogger: spool: 2 records dropped" {
+		t.Errorf("Sync = %v", err)
+	}
+	spoolRecords(core, "r2")
+	core.(interface{ Close() error }).Close()
+
+	if got := strings.Join(replay(t, dir), " "); got != "r2" {
+		t.Errorf("delivered %s, want the record logged after expiry", got)
+	}
+}
+
+// countingSync is a core whose Sync fails, recording when it is called.
+type countingSync struct {
+	zapcore.Core
+	mu    sync.Mutex
+	syncs []time.Time
+}
+
+func (c *countingSync) Write(zapcore.Entry, []zapcore.Field) error { return nil }
+
+func (c *countingSync) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncs = append(c.syncs, time.Now())
+	return errors.New("down")
+}
+
+func (c *countingSync) attempts() []time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Time(nil), c.syncs...)
+}
+
+func TestSpoolMaxAttempts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	obs, _ := observer.New(zapcore.DebugLevel)
+	down := &countingSync{Core: obs}
+	cfg := testSpoolConfig(dir)
+	cfg.FlushInterval = 5 * time.Millisecond
+	cfg.MinBackoff = 20 * time.Millisecond
+	cfg.MaxBackoff = 30 * time.Millisecond
+	cfg.MaxAttempts = 4
+	core, err := NewSpool(down, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.(interface{ Close() error }).Close()
+	spoolRecords(core, "r0")
+
+	for i := 0; len(down.attempts()) < cfg.MaxAttempts && i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	attempts := down.attempts()
+	if len(attempts) != cfg.MaxAttempts {
+		t.Fatalf("%d attempts, want %d", len(attempts), cfg.MaxAttempts)
+	}
+	// the backoff doubles from MinBackoff up to MaxBackoff
+	for i, min := range []time.Duration{cfg.MinBackoff, cfg.MaxBackoff, cfg.MaxBackoff} {
+		if d := attempts[i+1].Sub(attempts[i]); d < min {
+			t.Errorf("attempt %d after %v, want at least %v", i+2, d, min)
+		}
+	}
+	if err := core.Sync(); err == nil || err.Error() != "mlogger: spool: 1 records dropped: down" {
+		t.Errorf("Sync = %v", err)
+	}
+}
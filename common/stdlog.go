@@ -0,0 +1,164 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LineWriter is an io.Writer that logs every line written to it. Partial
+// lines are held back until they are completed or the writer is closed.
+// It is safe for concurrent use and, unlike an io.Pipe, needs no goroutine
+// reading from it.
+type LineWriter struct {
+	emit func(line string)
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+// NewLineWriter returns a LineWriter handing each line, without its line
+// ending, to emit.
+func NewLineWriter(emit func(line string)) *LineWriter {
+	return &LineWriter{emit: emit}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			w.buf.Write(p)
+			break
+		}
+		line := p[:idx]
+		if w.buf.Len() > 0 {
+			w.buf.Write(line)
+			line = w.buf.Bytes()
+		}
+		w.emit(string(bytes.TrimSuffix(line, []byte{'\r'})))
+		w.buf.Reset()
+		p = p[idx+1:]
+	}
+	return n, nil
+}
+
+// Close logs any incomplete last line. Writes after Close fail with
+// io.ErrClosedPipe.
+func (w *LineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.String())
+		w.buf.Reset()
+	}
+	w.closed = true
+	return nil
+}
+
+// stdLogCallerSkip is the number of frames between the caller of a
+// *log.Logger method and the emit function of its LineWriter: the
+// log.Logger internals, LineWriter.Write and the emit closure.
+const stdLogCallerSkip = 4
+
+// NewStdLogger returns a *log.Logger that writes every line it is given to
+// the mlogger core at level, for libraries that only accept a *log.Logger.
+func NewStdLogger(level zapcore.Level) *log.Logger {
+	return log.New(newStdWriter(func(line string) (zapcore.Level, string) {
+		return level, line
+	}), "", 0)
+}
+
+// NewDetectingStdLogger is NewStdLogger for libraries that mark severity
+// in the text they log, such as net/http.Server.ErrorLog or grpc: the
+// level of each line is inferred with DetectLevel, falling back to
+// fallback.
+func NewDetectingStdLogger(fallback zapcore.Level) *log.Logger {
+	return log.New(newStdWriter(func(line string) (zapcore.Level, string) {
+		return DetectLevel(line, fallback)
+	}), "", 0)
+}
+
+// RedirectStdLog sends the output of the standard library's default logger
+// to the mlogger core at level.
+func RedirectStdLog(level zapcore.Level) {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(newStdWriter(func(line string) (zapcore.Level, string) {
+		return level, line
+	}))
+}
+
+func newStdWriter(classify func(line string) (zapcore.Level, string)) *LineWriter {
+	logger := zap.New(Core(), zap.AddCaller(), zap.AddCallerSkip(stdLogCallerSkip))
+	return NewLineWriter(func(line string) {
+		level, msg := classify(line)
+		if ce := logger.Check(level, msg); ce != nil {
+			ce.Write()
+		}
+	})
+}
+
+var (
+	// [ERROR] msg, ERROR: msg
+	bracketLevel = regexp.MustCompile(`^\s*(?:\[([A-Za-z]+)\]|([A-Za-z]+):)\s*`)
+	// E1018 12:00:00.000000    1234 file.go:12] msg, as written by glog and klog
+	glogHeader = regexp.MustCompile(`^([IWEF])\d{4}(?: [0-9:.]+\s+\d+ [^\]]*\])?\s*`)
+	// level=warn, as written by logfmt loggers
+	logfmtLevel = regexp.MustCompile(`(?:^|\s)(?:level|lvl|severity)="?([A-Za-z]+)"?(?:\s|$)`)
+)
+
+// DetectLevel infers the severity of a line written by a library that only
+// knows *log.Logger. It recognises bracketed and colon-terminated level
+// names ("[ERROR]", "WARNING:"), glog and klog headers ("E1018 ..."), and
+// logfmt level keys ("level=warn"). Severity prefixes are removed from the
+// returned message; logfmt lines are returned unchanged. Fatal and panic
+// markers map to ErrorLevel, since the line has already been handled by
+// the library by the time it is logged. Lines without a recognised marker
+// are logged at fallback.
+func DetectLevel(line string, fallback zapcore.Level) (zapcore.Level, string) {
+	if m := bracketLevel.FindStringSubmatch(line); m != nil {
+		name := m[1] + m[2]
+		if level, ok := levelByName(name); ok {
+			return level, line[len(m[0]):]
+		}
+	}
+	if m := glogHeader.FindStringSubmatch(line); m != nil {
+		level, _ := levelByName(m[1])
+		return level, line[len(m[0]):]
+	}
+	if m := logfmtLevel.FindStringSubmatch(line); m != nil {
+		if level, ok := levelByName(m[1]); ok {
+			return level, line
+		}
+	}
+	return fallback, line
+}
+
+func levelByName(name string) (zapcore.Level, bool) {
+	switch strings.ToLower(name) {
+	case "trace", "debug", "dbug":
+		return zapcore.DebugLevel, true
+	case "i", "info", "notice":
+		return zapcore.InfoLevel, true
+	case "w", "warn", "warning":
+		return zapcore.WarnLevel, true
+	case "e", "f", "err", "error", "fatal", "panic", "crit", "critical":
+		return zapcore.ErrorLevel, true
+	}
+	return zapcore.InfoLevel, false
+}
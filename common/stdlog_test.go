@@ -0,0 +1,85 @@
+package common
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDetectLevel(t *testing.T) {
+	for _, tt := range []struct {
+		line  string
+		level zapcore.Level
+		msg   string
+	}{
+		{"[ERROR] disk gone", zapcore.ErrorLevel, "disk gone"},
+		{"WARNING: pool degraded", zapcore.WarnLevel, "pool degraded"},
+		{"  [debug]  probing", zapcore.DebugLevel, "probing"},
+		{"[FATAL] giving up", zapcore.ErrorLevel, "giving up"},
+		{"E1018 12:00:00.000000    1234 pool.go:12] sync failed", zapcore.ErrorLevel, "sync failed"},
+		{"W1018 retrying", zapcore.WarnLevel, "retrying"},
+		{`time=now level=warn msg="slow disk"`, zapcore.WarnLevel, `time=now level=warn msg="slow disk"`},
+		{"[pool-a] created", zapcore.InfoLevel, "[pool-a] created"},
+		{"http: TLS handshake error", zapcore.InfoLevel, "http: TLS handshake error"},
+		{"created pool", zapcore.InfoLevel, "created pool"},
+	} {
+		level, msg := DetectLevel(tt.line, zapcore.InfoLevel)
+		if level != tt.level || msg != tt.msg {
+			t.Errorf("DetectLevel(%q) = %v, %q, want %v, %q", tt.line, level, msg, tt.level, tt.msg)
+		}
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	for _, p := range []string{"first\r\nsec", "ond\n", "\nthi", "rd"} {
+		if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", p, n, err)
+		}
+	}
+	if want := []string{"first", "second", ""}; strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines %q before Close, want %q", lines, want)
+	}
+	w.Close()
+	if last := lines[len(lines)-1]; len(lines) != 4 || last != "third" {
+		t.Errorf("lines %q after Close, want the incomplete line last", lines)
+	}
+	if _, err := w.Write([]byte("late\n")); err != io.ErrClosedPipe {
+		t.Errorf("Write after Close: %v", err)
+	}
+}
+
+func TestStdLoggerCaller(t *testing.T) {
+	defer Configure(NewConfig())
+	output := configureToFile(t, NewConfig())
+
+	NewStdLogger(zapcore.WarnLevel).Print("from Print")
+	NewDetectingStdLogger(zapcore.InfoLevel).Printf("[ERROR] from %s", "Printf")
+	defer log.SetFlags(log.Flags())
+	defer log.SetOutput(os.Stderr)
+	RedirectStdLog(zapcore.InfoLevel)
+	log.Println("from the standard logger")
+
+	lines := strings.Split(strings.TrimSpace(output()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d records: %q", len(lines), lines)
+	}
+	for i, want := range [][2]string{
+		{`"severity":"warn"`, `"msg":"from Print"`},
+		{`"severity":"error"`, `"msg":"from Printf"`},
+		{`"severity":"info"`, `"msg":"from the standard logger"`},
+	} {
+		if !strings.Contains(lines[i], want[0]) || !strings.Contains(lines[i], want[1]) {
+			t.Errorf("record %d: want %s and %s: %s", i, want[0], want[1], lines[i])
+		}
+		// the caller of the *log.Logger, not the bridge, is the ecode
+		if !strings.Contains(lines[i], `.stdlog_test.go:`) {
+			t.Errorf("record %d: want this file as ecode: %s", i, lines[i])
+		}
+	}
+}
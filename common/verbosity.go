@@ -0,0 +1,38 @@
+package common
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// VKey is the key recording the V-level of records logged above V(0).
+const VKey = "v"
+
+// verbosity is the highest V-level that is logged.
+var verbosity int32
+
+// SetVerbosity sets the highest V-level that is logged, the equivalent of
+// glog's and klog's -v flag for the adapters built on the mlogger core.
+func SetVerbosity(v int) {
+	atomic.StoreInt32(&verbosity, int32(v))
+}
+
+// Verbosity returns the highest V-level that is logged.
+func Verbosity() int {
+	return int(atomic.LoadInt32(&verbosity))
+}
+
+// V reports whether records at the given V-level are logged.
+func V(level int) bool {
+	return int32(level) <= atomic.LoadInt32(&verbosity)
+}
+
+// VLevel returns the severity records at a V-level are written with:
+// InfoLevel for V(0) and below, DebugLevel for anything more verbose.
+func VLevel(level int) zapcore.Level {
+	if level > 0 {
+		return zapcore.DebugLevel
+	}
+	return zapcore.InfoLevel
+}
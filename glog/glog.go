@@ -1,34 +1,59 @@
 package glog
 
 import (
+	"fmt"
 
 	gglog "github.com/golang/glog"
 	"github.com/mayadata-io/mlogger/common"
-	//"go.uber.org/zap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
 	logger = common.Logger
 )
 
+// enabled reports whether records at level are logged, so that formatted
+// calls format only then. Their format is passed along with
+// common.Template for sampling keyed by message.
+func enabled(level zapcore.Level) bool {
+	return common.Core().Enabled(level)
+}
+
 // Flush flushes all pending log I/O.
 func Flush() {
 	//gglog.Flush()
 	logger.Sync()
 }
 
+// Named returns a logger for the subsystem name, whose records carry the
+// name and are enabled according to the level set for it. glog has no
+// named loggers, so it is a zap logger; see common.Named.
+func Named(name string) *zap.SugaredLogger {
+	return common.Named(name)
+}
+
 // CopyStandardLogTo arranges for messages written to the Go "log" package's
 // default logs to also appear in the Google logs for the named and lower
 // severities.  Subsequent changes to the standard log's default output location
 // or format may break this behavior.
 //
 // Valid names are "INFO", "WARNING", "ERROR", and "FATAL".  If the name is not
-// recognized, CopyStandardLogTo panics.
+// recognized, CopyStandardLogTo panics. Unlike glog, "FATAL" logs at ERROR
+// severity: a line from the standard logger never terminates the process.
 func CopyStandardLogTo(name string) {
-	//gglog.CopyStandardLogTo(name)
-	//logger.CopyStandardLogTo(name)
-	//TODO: not supported in this version of mlogger
-	return
+	var level zapcore.Level
+	switch name {
+	case "INFO":
+		level = zapcore.InfoLevel
+	case "WARNING":
+		level = zapcore.WarnLevel
+	case "ERROR", "FATAL":
+		level = zapcore.ErrorLevel
+	default:
+		panic(fmt.Sprintf("log.CopyStandardLogTo(%q): unrecognized severity name", name))
+	}
+	common.RedirectStdLog(level)
 }
 
 // Info logs to the INFO log.
@@ -57,7 +82,9 @@ func Infoln(args ...interface{}) {
 // Infof logs to the INFO log.
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Infof(format string, args ...interface{}) {
-	logger.Infof(format, args)
+	if enabled(zapcore.InfoLevel) {
+		logger.Infow(fmt.Sprintf(format, args...), common.Template(format))
+	}
 }
 
 // Warning logs to the WARNING and INFO logs.
@@ -87,7 +114,9 @@ func Warningln(args ...interface{}) {
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Warningf(format string, args ...interface{}) {
 	//gglog.Warningf(format, args)
-	logger.Warnf(format, args)
+	if enabled(zapcore.WarnLevel) {
+		logger.Warnw(fmt.Sprintf(format, args...), common.Template(format))
+	}
 }
 
 // Error logs to the ERROR, WARNING, and INFO logs.
@@ -117,7 +146,9 @@ func Errorln(args ...interface{}) {
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Errorf(format string, args ...interface{}) {
 	//gglog.Errorf(format, args)
-	logger.Errorf(format, args)
+	if enabled(zapcore.ErrorLevel) {
+		logger.Errorw(fmt.Sprintf(format, args...), common.Template(format))
+	}
 }
 
 // Fatal logs to the FATAL, ERROR, WARNING, and INFO logs,
@@ -150,7 +181,7 @@ func Fatalln(args ...interface{}) {
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Fatalf(format string, args ...interface{}) {
 	//gglog.Fatalf(format, args)
-	logger.Fatalf(format, args)
+	logger.Fatalw(fmt.Sprintf(format, args...), common.Template(format))
 }
 
 // Exit logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
@@ -179,7 +210,7 @@ func Exitln(args ...interface{}) {
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Exitf(format string, args ...interface{}) {
 	//gglog.Exitf(format, args)
-	logger.Fatalf(format, args)
+	logger.Fatalw(fmt.Sprintf(format, args...), common.Template(format))
 }
 
 type Level gglog.Level
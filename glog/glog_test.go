@@ -0,0 +1,48 @@
+package glog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mayadata-io/mlogger/common"
+)
+
+func TestFormatted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.log")
+	cfg := common.NewConfig()
+	cfg.OutputPaths = []string{path}
+	if err := common.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer common.Configure(common.NewConfig())
+
+	Infof("created pool %s with %d disks", "testPool1", 3)
+	Warningf("pool %s degraded", "testPool1")
+	Errorf("pool %q failed", "testPool1")
+	Flush()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"msg":"created pool testPool1 with 3 disks"`,
+		`"msg":"pool testPool1 degraded"`,
+		`"msg":"pool \"testPool1\" failed"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("want %s: %s", want, b)
+		}
+	}
+	if strings.Contains(string(b), "mlogger.template") {
+		t.Errorf("template logged: %s", b)
+	}
+}
@@ -0,0 +1,140 @@
+package grpclog
+
+import (
+	"fmt"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	ggrpclog "google.golang.org/grpc/grpclog"
+)
+
+// InfoVerbosity is the V-level grpc's Info records are logged at. grpc
+// reports every connection state change at Info, so they are only logged
+// once the verbosity is raised with common.SetVerbosity.
+var InfoVerbosity = 2
+
+// Install makes grpc log through the mlogger core. It must be called before
+// any grpc function, as grpclog.SetLoggerV2 is not safe for concurrent use.
+func Install() {
+	ggrpclog.SetLoggerV2(New())
+}
+
+// New returns a grpclog.LoggerV2 writing to the mlogger core. grpc's V(l)
+// follows the mlogger verbosity set with common.SetVerbosity, Info records
+// are logged at InfoVerbosity, and Fatal logs then exits as grpc expects.
+func New() ggrpclog.LoggerV2 {
+	return &loggerV2{
+		log: common.Logger.Desugar().WithOptions(zap.AddCallerSkip(2)),
+	}
+}
+
+type loggerV2 struct {
+	// log skips the write and loggerV2 methods and the grpclog function in
+	// front of them.
+	log *zap.Logger
+}
+
+var (
+	_ ggrpclog.LoggerV2      = (*loggerV2)(nil)
+	_ ggrpclog.DepthLoggerV2 = (*loggerV2)(nil)
+)
+
+func (l *loggerV2) Info(args ...interface{}) {
+	l.info(0, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) Infoln(args ...interface{}) {
+	l.info(0, sprintln(args))
+}
+
+func (l *loggerV2) Infof(format string, args ...interface{}) {
+	l.info(0, fmt.Sprintf(format, args...))
+}
+
+func (l *loggerV2) Warning(args ...interface{}) {
+	l.write(0, zapcore.WarnLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) Warningln(args ...interface{}) {
+	l.write(0, zapcore.WarnLevel, sprintln(args))
+}
+
+func (l *loggerV2) Warningf(format string, args ...interface{}) {
+	l.write(0, zapcore.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *loggerV2) Error(args ...interface{}) {
+	l.write(0, zapcore.ErrorLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) Errorln(args ...interface{}) {
+	l.write(0, zapcore.ErrorLevel, sprintln(args))
+}
+
+func (l *loggerV2) Errorf(format string, args ...interface{}) {
+	l.write(0, zapcore.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+func (l *loggerV2) Fatal(args ...interface{}) {
+	l.write(0, zapcore.FatalLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) Fatalln(args ...interface{}) {
+	l.write(0, zapcore.FatalLevel, sprintln(args))
+}
+
+func (l *loggerV2) Fatalf(format string, args ...interface{}) {
+	l.write(0, zapcore.FatalLevel, fmt.Sprintf(format, args...))
+}
+
+// V reports whether grpc's verbosity level l is enabled.
+func (l *loggerV2) V(level int) bool {
+	return common.V(level)
+}
+
+// The Depth methods are used by grpc's internal logging, which passes the
+// number of frames between the code logging and grpclog.
+
+func (l *loggerV2) InfoDepth(depth int, args ...interface{}) {
+	l.info(depth, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) WarningDepth(depth int, args ...interface{}) {
+	l.write(depth, zapcore.WarnLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) ErrorDepth(depth int, args ...interface{}) {
+	l.write(depth, zapcore.ErrorLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) FatalDepth(depth int, args ...interface{}) {
+	l.write(depth, zapcore.FatalLevel, fmt.Sprint(args...))
+}
+
+func (l *loggerV2) info(depth int, msg string) {
+	if !common.V(InfoVerbosity) {
+		return
+	}
+	var fields []zapcore.Field
+	if InfoVerbosity > 0 {
+		fields = append(fields, zap.Int(common.VKey, InfoVerbosity))
+	}
+	l.write(depth+1, common.VLevel(InfoVerbosity), msg, fields...)
+}
+
+func (l *loggerV2) write(depth int, level zapcore.Level, msg string, fields ...zapcore.Field) {
+	log := l.log
+	if depth > 0 {
+		log = log.WithOptions(zap.AddCallerSkip(depth))
+	}
+	if ce := log.Check(level, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// sprintln formats args like fmt.Sprintln, without the trailing newline.
+func sprintln(args []interface{}) string {
+	msg := fmt.Sprintln(args...)
+	return msg[:len(msg)-1]
+}
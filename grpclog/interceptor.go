@@ -0,0 +1,233 @@
+package grpclog
+
+import (
+	"context"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Keys of the fields logged by the interceptors.
+const (
+	ServiceKey  = "grpc.service"
+	MethodKey   = "grpc.method"
+	CodeKey     = "grpc.code"
+	DurationKey = "grpc.duration"
+	PeerKey     = "peer.address"
+	TargetKey   = "grpc.target"
+)
+
+// Option configures the interceptors.
+type Option func(*options)
+
+type options struct {
+	levelFor func(codes.Code) zapcore.Level
+	skip     func(fullMethod string) bool
+}
+
+// WithLevels sets the level a call is logged at from its status code. The
+// default is DefaultCodeToLevel.
+func WithLevels(f func(codes.Code) zapcore.Level) Option {
+	return func(o *options) {
+		o.levelFor = f
+	}
+}
+
+// WithSkip makes the interceptors log nothing for the calls f returns true
+// for, such as health checks. The request-scoped logger is still attached.
+func WithSkip(f func(fullMethod string) bool) Option {
+	return func(o *options) {
+		o.skip = f
+	}
+}
+
+func buildOptions(opts []Option) *options {
+	o := &options{
+		levelFor: DefaultCodeToLevel,
+		skip:     func(string) bool { return false },
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// DefaultCodeToLevel logs successful calls at Info, errors the client is
+// responsible for at Warn and everything else at Error.
+func DefaultCodeToLevel(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK:
+		return zapcore.InfoLevel
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.ResourceExhausted,
+		codes.FailedPrecondition, codes.Aborted, codes.OutOfRange:
+		return zapcore.WarnLevel
+	}
+	return zapcore.ErrorLevel
+}
+
+// UnaryServerInterceptor logs every unary call served, and attaches a
+// logger carrying the service, method and peer of the call to the context
+// passed to the handler; handlers retrieve it with common.FromContext.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := buildOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		logger := serverLogger(ctx, info.FullMethod)
+		resp, err := handler(common.NewContext(ctx, logger), req)
+		if !o.skip(info.FullMethod) {
+			o.logCall(logger, "finished unary call", start, err)
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls.
+// The call is logged once the handler returns.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := buildOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		logger := serverLogger(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStream{
+			ServerStream: ss,
+			ctx:          common.NewContext(ss.Context(), logger),
+		})
+		if !o.skip(info.FullMethod) {
+			o.logCall(logger, "finished streaming call", start, err)
+		}
+		return err
+	}
+}
+
+// UnaryClientInterceptor logs every unary call made, with the target of the
+// connection and the peer that served it, and attaches a logger carrying
+// the call to the context the invoker and later interceptors see.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := buildOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		start := time.Now()
+		logger := clientLogger(ctx, method, cc)
+		var p peer.Peer
+		err := invoker(common.NewContext(ctx, logger), method, req, reply, cc, append(callOpts, grpc.Peer(&p))...)
+		if !o.skip(method) {
+			o.logCall(logger, "finished client unary call", start, err, peerFields(&p)...)
+		}
+		return err
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streaming calls.
+// The call is logged once it completes: when receiving returns io.EOF or
+// an error, or the single response of a call the server does not stream,
+// or when the stream cannot be established. Calls whose stream is
+// abandoned before that are not logged.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := buildOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		logger := clientLogger(ctx, method, cc)
+		p := new(peer.Peer)
+		cs, err := streamer(common.NewContext(ctx, logger), desc, cc, method, append(callOpts, grpc.Peer(p))...)
+		if o.skip(method) {
+			return cs, err
+		}
+		finish := func(err error) {
+			o.logCall(logger, "finished client streaming call", start, err, peerFields(p)...)
+		}
+		if err != nil {
+			finish(err)
+			return cs, err
+		}
+		return &clientStream{ClientStream: cs, serverStreams: desc.ServerStreams, finish: finish}, nil
+	}
+}
+
+// clientStream calls finish once the call completes, as seen by RecvMsg.
+type clientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	finish        func(error)
+	once          sync.Once
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(func() {
+			if err == io.EOF {
+				s.finish(nil)
+			} else {
+				s.finish(err)
+			}
+		})
+	}
+	return err
+}
+
+// logCall writes the record of a finished call, with fields added. It is
+// attributed to the interceptor rather than to grpc internals.
+func (o *options) logCall(logger *zap.SugaredLogger, msg string, start time.Time, err error, fields ...zapcore.Field) {
+	code := status.Code(err)
+	ce := logger.Desugar().WithOptions(zap.AddCallerSkip(1)).Check(o.levelFor(code), msg)
+	if ce == nil {
+		return
+	}
+	fields = append(fields,
+		zap.String(CodeKey, code.String()),
+		zap.Duration(DurationKey, time.Since(start)),
+	)
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	ce.Write(fields...)
+}
+
+func serverLogger(ctx context.Context, fullMethod string) *zap.SugaredLogger {
+	fields := methodFields(fullMethod)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, PeerKey, p.Addr.String())
+	}
+	return common.FromContext(ctx).With(fields...)
+}
+
+// peerFields returns the address of the peer that served a client call,
+// once grpc has filled p in.
+func peerFields(p *peer.Peer) []zapcore.Field {
+	if p.Addr == nil {
+		return nil
+	}
+	return []zapcore.Field{zap.String(PeerKey, p.Addr.String())}
+}
+
+func clientLogger(ctx context.Context, fullMethod string, cc *grpc.ClientConn) *zap.SugaredLogger {
+	fields := append(methodFields(fullMethod), TargetKey, cc.Target())
+	return common.FromContext(ctx).With(fields...)
+}
+
+// methodFields splits "/package.Service/Method" into its service and
+// method.
+func methodFields(fullMethod string) []interface{} {
+	service := path.Dir(fullMethod)[1:]
+	method := path.Base(fullMethod)
+	return []interface{}{ServiceKey, service, MethodKey, method}
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,273 @@
+package grpclog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// stringCodec carries the *string messages of the echo service.
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(*v.(*string)), nil
+}
+
+func (stringCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func (stringCodec) Name() string   { return "string" }
+func (stringCodec) String() string { return "string" }
+
+// The echo service has a unary, a server streaming and a client streaming
+// method. Requests of "fail" fail with InvalidArgument.
+var echoDesc = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			var req string
+			if err := dec(&req); err != nil {
+				return nil, err
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}
+			return interceptor(ctx, &req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				common.FromContext(ctx).Info("echoing")
+				if *req.(*string) == "fail" {
+					return nil, status.Error(codes.InvalidArgument, "fail")
+				}
+				return req, nil
+			})
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Repeat",
+		ServerStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			var req string
+			if err := ss.RecvMsg(&req); err != nil {
+				return err
+			}
+			if req == "fail" {
+				return status.Error(codes.InvalidArgument, "fail")
+			}
+			for i := 0; i < 3; i++ {
+				if err := ss.SendMsg(&req); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, {
+		StreamName:    "Join",
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			var parts []string
+			for {
+				var req string
+				err := ss.RecvMsg(&req)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				parts = append(parts, req)
+			}
+			joined := strings.Join(parts, ",")
+			return ss.SendMsg(&joined)
+		},
+	}},
+}
+
+// withLogger attaches logger to the context of the calls served, in front
+// of the interceptors under test.
+func withLogger(logger *zap.SugaredLogger) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				return handler(common.NewContext(ctx, logger), req)
+			},
+			UnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				return handler(srv, &serverStream{ServerStream: ss, ctx: common.NewContext(ss.Context(), logger)})
+			},
+			StreamServerInterceptor(),
+		),
+	}
+}
+
+// startEcho serves the echo service over an in-memory connection, logging
+// to the returned observer, and dials it with the client interceptors.
+func startEcho(t *testing.T) (*grpc.ClientConn, *observer.ObservedLogs, func()) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(append(withLogger(zap.New(core).Sugar()), grpc.CustomCodec(stringCodec{}))...)
+	srv.RegisterService(&echoDesc, struct{}{})
+	go srv.Serve(l)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(stringCodec{})),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, logs, func() {
+		cc.Close()
+		srv.Stop()
+	}
+}
+
+// checkCall checks that logs holds exactly one record of msg, for method,
+// with code and the peer, and the target when logged by the client.
+func checkCall(t *testing.T, logs *observer.ObservedLogs, msg, method string, code codes.Code) {
+	t.Helper()
+	calls := logs.FilterMessage(msg).All()
+	if len(calls) != 1 {
+		t.Fatalf("got %d %q records, want 1: %v", len(calls), msg, logs.All())
+	}
+	fields := calls[0].ContextMap()
+	want := map[string]interface{}{
+		ServiceKey: "test.Echo",
+		MethodKey:  method,
+		CodeKey:    code.String(),
+		PeerKey:    "bufconn",
+	}
+	if strings.Contains(msg, "client") {
+		want[TargetKey] = "bufnet"
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s: %s = %v, want %v", msg, k, fields[k], v)
+		}
+	}
+	if level := DefaultCodeToLevel(code); calls[0].Level != level {
+		t.Errorf("%s: level %v, want %v", msg, calls[0].Level, level)
+	}
+}
+
+func TestUnary(t *testing.T) {
+	for _, code := range []codes.Code{codes.OK, codes.InvalidArgument} {
+		t.Run(code.String(), func(t *testing.T) {
+			cc, logs, stop := startEcho(t)
+			defer stop()
+			core, clientLogs := observer.New(zapcore.InfoLevel)
+			ctx := common.NewContext(context.Background(), zap.New(core).Sugar())
+
+			req, reply := "hello", ""
+			if code != codes.OK {
+				req = "fail"
+			}
+			err := cc.Invoke(ctx, "/test.Echo/Echo", &req, &reply)
+			if status.Code(err) != code {
+				t.Fatalf("got %v, want %v", err, code)
+			}
+			stop()
+
+			checkCall(t, logs, "finished unary call", "Echo", code)
+			checkCall(t, clientLogs, "finished client unary call", "Echo", code)
+			// the handler logs with the call fields
+			if echoing := logs.FilterMessage("echoing").All(); len(echoing) != 1 || echoing[0].ContextMap()[MethodKey] != "Echo" {
+				t.Errorf("handler records %v", echoing)
+			}
+		})
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	for _, code := range []codes.Code{codes.OK, codes.InvalidArgument} {
+		t.Run(code.String(), func(t *testing.T) {
+			cc, logs, stop := startEcho(t)
+			defer stop()
+			core, clientLogs := observer.New(zapcore.InfoLevel)
+			ctx := common.NewContext(context.Background(), zap.New(core).Sugar())
+
+			desc := &grpc.StreamDesc{ServerStreams: true}
+			cs, err := cc.NewStream(ctx, desc, "/test.Echo/Repeat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the call is logged once it completes, not at setup
+			if n := clientLogs.Len(); n != 0 {
+				t.Fatalf("%d records at stream setup", n)
+			}
+			req := "hello"
+			if code != codes.OK {
+				req = "fail"
+			}
+			if err := cs.SendMsg(&req); err != nil {
+				t.Fatal(err)
+			}
+			cs.CloseSend()
+			var n int
+			for {
+				var reply string
+				err = cs.RecvMsg(&reply)
+				if err != nil {
+					break
+				}
+				n++
+			}
+			if code == codes.OK && (err != io.EOF || n != 3) {
+				t.Fatalf("got %d replies, then %v", n, err)
+			}
+			if code != codes.OK && status.Code(err) != code {
+				t.Fatalf("got %v, want %v", err, code)
+			}
+			stop()
+
+			checkCall(t, logs, "finished streaming call", "Repeat", code)
+			checkCall(t, clientLogs, "finished client streaming call", "Repeat", code)
+		})
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	cc, logs, stop := startEcho(t)
+	defer stop()
+	core, clientLogs := observer.New(zapcore.InfoLevel)
+	ctx := common.NewContext(context.Background(), zap.New(core).Sugar())
+
+	cs, err := cc.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, "/test.Echo/Join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		part := fmt.Sprint(i)
+		if err := cs.SendMsg(&part); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := cs.RecvMsg(&reply); err != nil || reply != "0,1,2" {
+		t.Fatalf("got %q, %v", reply, err)
+	}
+	stop()
+
+	checkCall(t, logs, "finished streaming call", "Join", codes.OK)
+	checkCall(t, clientLogs, "finished client streaming call", "Join", codes.OK)
+}
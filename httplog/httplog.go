@@ -0,0 +1,249 @@
+package httplog
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestIDHeader is the header a request ID is read from and echoed in.
+const RequestIDHeader = "X-Request-ID"
+
+// MaxRequestIDLength bounds the request IDs taken from clients. Longer IDs,
+// and those with characters other than letters, digits and -_.:+/=@, are
+// replaced by a generated one.
+const MaxRequestIDLength = 128
+
+// Keys of the fields logged by the middleware.
+const (
+	RequestIDKey = "request_id"
+	MethodKey    = "http.method"
+	PathKey      = "http.path"
+	StatusKey    = "http.status"
+	BytesKey     = "http.bytes"
+	DurationKey  = "http.duration"
+	RemoteKey    = "http.remote_addr"
+)
+
+// Option configures the middleware.
+type Option func(*options)
+
+type options struct {
+	levelFor  func(status int) zapcore.Level
+	skip      map[string]bool
+	requestID func() string
+}
+
+// WithLevels sets the level a request is logged at from its response
+// status. The default is DefaultStatusToLevel.
+func WithLevels(f func(status int) zapcore.Level) Option {
+	return func(o *options) {
+		o.levelFor = f
+	}
+}
+
+// WithSkipPaths disables the access log for requests to the given paths,
+// such as health checks. The request-scoped logger is still attached.
+func WithSkipPaths(paths ...string) Option {
+	return func(o *options) {
+		for _, p := range paths {
+			o.skip[p] = true
+		}
+	}
+}
+
+// WithRequestID sets how IDs are generated for requests arriving without
+// a valid X-Request-ID header. The default is 16 random bytes in hex.
+func WithRequestID(f func() string) Option {
+	return func(o *options) {
+		o.requestID = f
+	}
+}
+
+// DefaultStatusToLevel logs client errors at Warn, server errors at Error
+// and everything else at Info.
+func DefaultStatusToLevel(status int) zapcore.Level {
+	switch {
+	case status >= 500:
+		return zapcore.ErrorLevel
+	case status >= 400:
+		return zapcore.WarnLevel
+	}
+	return zapcore.InfoLevel
+}
+
+// Middleware returns a middleware writing an access log record for every
+// request served by the handler it wraps:
+//
+//	http.ListenAndServe(addr, httplog.Middleware()(mux))
+//
+// The request ID is taken from the X-Request-ID header or generated, and
+// is set on the response. Handlers find a logger carrying the request ID,
+// method and path with common.FromContext(r.Context()). A request whose
+// handler panics is logged with the panic, as a 500 unless the handler
+// sent a status, and the panic goes on to the server.
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	o := &options{
+		levelFor:  DefaultStatusToLevel,
+		skip:      make(map[string]bool),
+		requestID: newRequestID,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			o.serve(next, w, r)
+		})
+	}
+}
+
+func (o *options) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = o.requestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+
+	ctx := r.Context()
+	logger := common.FromContext(ctx).With(
+		RequestIDKey, id,
+		MethodKey, r.Method,
+		PathKey, r.URL.Path,
+	)
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	ctx = common.NewContext(ctx, logger)
+
+	rw := &responseWriter{ResponseWriter: w}
+	if !o.skip[r.URL.Path] {
+		defer o.log(logger, rw, r, start)
+	}
+	next.ServeHTTP(rw, r.WithContext(ctx))
+}
+
+// log writes the access log record of a request once its handler
+// returned or panicked, in which case it panics again.
+func (o *options) log(logger *zap.SugaredLogger, rw *responseWriter, r *http.Request, start time.Time) {
+	p := recover()
+	status := rw.status
+	switch {
+	case status != 0:
+	case p != nil:
+		// net/http answers a panicking handler by closing the connection
+		status = http.StatusInternalServerError
+	default:
+		status = http.StatusOK
+	}
+	if ce := logger.Desugar().Check(o.levelFor(status), "served request"); ce != nil {
+		fields := []zapcore.Field{
+			zap.Int(StatusKey, status),
+			zap.Int64(BytesKey, rw.bytes),
+			zap.Duration(DurationKey, time.Since(start)),
+			zap.String(RemoteKey, r.RemoteAddr),
+		}
+		if p != nil && p != http.ErrAbortHandler {
+			fields = append(fields, zap.String(common.PanicKey, fmt.Sprint(p)))
+		}
+		ce.Write(fields...)
+	}
+	if p != nil {
+		panic(p)
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request being served with ctx, or "" if
+// ctx did not come from the middleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id, taken from a client, is fit to be
+// echoed and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// responseWriter records the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	// informational responses are followed by the real one
+	if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush and Hijack keep streaming responses and websockets working
+// through the middleware.
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package httplog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// serve serves req through the middleware wrapping h, logging to the
+// returned observer.
+func serve(h http.HandlerFunc, req *http.Request, opts ...Option) (*httptest.ResponseRecorder, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	req = req.WithContext(common.NewContext(req.Context(), zap.New(core).Sugar()))
+	rec := httptest.NewRecorder()
+	Middleware(opts...)(h).ServeHTTP(rec, req)
+	return rec, logs
+}
+
+func TestAccessLog(t *testing.T) {
+	var handlerID string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		handlerID = RequestID(r.Context())
+		common.FromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no such pool"))
+	}
+	req := httptest.NewRequest("GET", "/pools/a", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec, logs := serve(h, req, WithRequestID(func() string { return "generated" }))
+
+	if got := rec.Header().Get(RequestIDHeader); got != "req-1" || handlerID != "req-1" {
+		t.Errorf("request ID %q echoed, %q in context, want req-1", got, handlerID)
+	}
+	handling := logs.FilterMessage("handling").All()
+	if len(handling) != 1 || handling[0].ContextMap()[RequestIDKey] != "req-1" || handling[0].ContextMap()[PathKey] != "/pools/a" {
+		t.Errorf("handler records %v", handling)
+	}
+	served := logs.FilterMessage("served request").All()
+	if len(served) != 1 {
+		t.Fatalf("got %d access records, want 1", len(served))
+	}
+	fields := served[0].ContextMap()
+	if served[0].Level != zapcore.WarnLevel || fields[StatusKey] != int64(404) || fields[BytesKey] != int64(12) || fields[MethodKey] != "GET" {
+		t.Errorf("access record %v %v", served[0].Level, fields)
+	}
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	for _, id := range []string{
+		"",
+		strings.Repeat("a", MaxRequestIDLength+1),
+		"id with spaces",
+		"id\nforged=record",
+		"id\x1b[31m",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec, logs := serve(func(http.ResponseWriter, *http.Request) {}, req, WithRequestID(func() string { return "generated" }))
+		if got := rec.Header().Get(RequestIDHeader); got != "generated" {
+			t.Errorf("%q: echoed %q, want a generated ID", id, got)
+		}
+		if got := logs.All()[0].ContextMap()[RequestIDKey]; got != "generated" {
+			t.Errorf("%q: logged %q, want a generated ID", id, got)
+		}
+	}
+
+	for _, id := range []string{
+		"0f8fad5b-d9cb-469f-a165-70867728950e",
+		strings.Repeat("a", MaxRequestIDLength),
+		"trace:1/2+3=4@svc_a.b",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rec, _ := serve(func(http.ResponseWriter, *http.Request) {}, req)
+		if got := rec.Header().Get(RequestIDHeader); got != id {
+			t.Errorf("echoed %q, want %q", got, id)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	h := func(http.ResponseWriter, *http.Request) {
+		panic("pool vanished")
+	}
+	core, logs := observer.New(zapcore.DebugLevel)
+	req := httptest.NewRequest("DELETE", "/pools/a", nil)
+	req = req.WithContext(common.NewContext(req.Context(), zap.New(core).Sugar()))
+	func() {
+		defer func() {
+			if p := recover(); p != "pool vanished" {
+				t.Errorf("recovered %v, want the panic of the handler", p)
+			}
+		}()
+		Middleware()(http.HandlerFunc(h)).ServeHTTP(httptest.NewRecorder(), req)
+		t.Error("the panic did not reach the server")
+	}()
+
+	served := logs.FilterMessage("served request").All()
+	if len(served) != 1 {
+		t.Fatalf("got %d access records, want 1", len(served))
+	}
+	fields := served[0].ContextMap()
+	if served[0].Level != zapcore.ErrorLevel || fields[StatusKey] != int64(500) || fields[common.PanicKey] != "pool vanished" {
+		t.Errorf("access record %v %v", served[0].Level, fields)
+	}
+}
+
+func TestSkipPaths(t *testing.T) {
+	var logged bool
+	h := func(w http.ResponseWriter, r *http.Request) {
+		common.FromContext(r.Context()).Info("handling")
+		logged = true
+	}
+	_, logs := serve(h, httptest.NewRequest("GET", "/healthz", nil), WithSkipPaths("/healthz"))
+	if !logged || logs.Len() != 1 || logs.FilterMessage("served request").Len() != 0 {
+		t.Errorf("records %v", logs.All())
+	}
+}
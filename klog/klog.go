@@ -0,0 +1,406 @@
+package klog
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mayadata-io/mlogger/common"
+	mlogr "github.com/mayadata-io/mlogger/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	gklog "k8s.io/klog/v2"
+)
+
+var (
+	// logger skips the klog function and the helper below it.
+	logger = common.Logger.Desugar().WithOptions(zap.AddCallerSkip(1)).Sugar()
+)
+
+// KMetadata is a subset of the kubernetes k8s.io/apimachinery/pkg/apis/meta/v1.Object interface
+// this interface may expand in the future, but will always be a subset of the
+// kubernetes k8s.io/apimachinery/pkg/apis/meta/v1.Object interface
+type KMetadata = common.KMetadata
+
+// ObjectRef references a kubernetes object
+type ObjectRef = common.ObjectRef
+
+// KObj returns ObjectRef from ObjectMeta
+func KObj(obj KMetadata) ObjectRef {
+	return common.KObj(obj)
+}
+
+// KRef returns ObjectRef from name and namespace
+func KRef(namespace, name string) ObjectRef {
+	return common.KRef(namespace, name)
+}
+
+// Install makes mlogger the backend of k8s.io/klog/v2, so that vendored code
+// logging through klog is written through the mlogger core as well. klog's
+// own -v flag is set to the current mlogger verbosity and follows the -v
+// flag registered by InitFlags from then on.
+func Install() {
+	klogFlagsOnce.Do(func() {
+		klogFlags = flag.NewFlagSet("klog", flag.ContinueOnError)
+		gklog.InitFlags(klogFlags)
+	})
+	klogFlags.Set("v", strconv.Itoa(common.Verbosity()))
+	gklog.SetLoggerWithOptions(mlogr.New(), gklog.FlushLogger(Flush))
+}
+
+var (
+	klogFlagsOnce sync.Once
+	klogFlags     *flag.FlagSet
+)
+
+// Flush flushes all pending log I/O.
+func Flush() {
+	logger.Sync()
+}
+
+// InitFlags is for explicitly initializing the flags.
+//
+// Only -v, which sets the mlogger verbosity, has an effect. The other klog
+// flags are accepted so that command lines written for klog keep parsing,
+// and are ignored. Flags already defined in flagset, for instance by glog,
+// keep their definition, except that an existing -v sets the mlogger
+// verbosity as well.
+func InitFlags(flagset *flag.FlagSet) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+
+	if f := flagset.Lookup("v"); f == nil {
+		flagset.Var(verbosityFlag{}, "v", "number for the log level verbosity")
+	} else if _, ok := f.Value.(verbosityFlag); !ok {
+		f.Value = verbosityFlag{next: f.Value}
+	}
+	const usage = "accepted for compatibility with klog; ignored by mlogger"
+	for _, name := range ignoredFlags {
+		if flagset.Lookup(name) == nil {
+			flagset.String(name, "", usage)
+		}
+	}
+	for _, name := range ignoredBoolFlags {
+		if flagset.Lookup(name) == nil {
+			flagset.Bool(name, false, usage)
+		}
+	}
+}
+
+var (
+	ignoredFlags = []string{
+		"vmodule", "log_dir", "log_file", "log_file_max_size",
+		"stderrthreshold", "log_backtrace_at",
+	}
+	// ignoredBoolFlags may be given without a value, as in -logtostderr.
+	ignoredBoolFlags = []string{
+		"logtostderr", "alsologtostderr", "skip_headers", "skip_log_headers",
+		"add_dir_header", "one_output",
+	}
+)
+
+// verbosityFlag is the -v flag registered by InitFlags. next is the value
+// of the -v flag it took over, if any, which it sets as well.
+type verbosityFlag struct {
+	next flag.Value
+}
+
+func (verbosityFlag) String() string {
+	return strconv.Itoa(common.Verbosity())
+}
+
+func (f verbosityFlag) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if f.next != nil {
+		if err := f.next.Set(value); err != nil {
+			return err
+		}
+	}
+	common.SetVerbosity(v)
+	if klogFlags != nil {
+		return klogFlags.Set("v", value)
+	}
+	return nil
+}
+
+// Level specifies a level of verbosity for V logs.
+type Level int32
+
+// Verbose is a boolean type that implements Infof (like Printf) etc.
+// See the documentation of V for more information.
+type Verbose struct {
+	enabled bool
+	level   Level
+}
+
+// V reports whether verbosity at the call site is at least the requested level.
+// The returned value is a struct of type Verbose, which implements Info, Infoln
+// and Infof. These methods will write to the Info log if called.
+// Thus, one may write either
+//
+//	if klog.V(2).Enabled() { klog.Info("log this") }
+//
+// or
+//
+//	klog.V(2).Info("log this")
+//
+// The second form is shorter but the first is cheaper if logging is off because it does
+// not evaluate its arguments.
+//
+// Whether an individual call to V generates a log record depends on the mlogger
+// verbosity, set with the -v flag or common.SetVerbosity. Records above V(0) are
+// logged at debug severity with their level under "v".
+func V(level Level) Verbose {
+	return Verbose{enabled: common.V(int(level)), level: level}
+}
+
+// Enabled will return true if this log level is enabled, guarded by the value
+// of v.
+func (v Verbose) Enabled() bool {
+	return v.enabled
+}
+
+// Info is equivalent to the global Info function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) Info(args ...interface{}) {
+	if v.enabled {
+		v.log(0, fmt.Sprint(args...))
+	}
+}
+
+// InfoDepth is equivalent to the global InfoDepth function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) InfoDepth(depth int, args ...interface{}) {
+	if v.enabled {
+		v.log(depth, fmt.Sprint(args...))
+	}
+}
+
+// Infoln is equivalent to the global Infoln function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) Infoln(args ...interface{}) {
+	if v.enabled {
+		v.log(0, sprintln(args...))
+	}
+}
+
+// Infof is equivalent to the global Infof function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		v.log(0, fmt.Sprintf(format, args...))
+	}
+}
+
+// InfoS is equivalent to the global InfoS function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) InfoS(msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		v.log(0, msg, keysAndValues...)
+	}
+}
+
+// InfoSDepth is equivalent to the global InfoSDepth function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) InfoSDepth(depth int, msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		v.log(depth, msg, keysAndValues...)
+	}
+}
+
+// ErrorS is equivalent to the global Error function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) ErrorS(err error, msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		logDepth(0, zapcore.ErrorLevel, msg, withError(err, keysAndValues)...)
+	}
+}
+
+func (v Verbose) log(depth int, msg string, keysAndValues ...interface{}) {
+	if v.level > 0 {
+		keysAndValues = append(keysAndValues, common.VKey, int(v.level))
+	}
+	logDepth(depth+1, common.VLevel(int(v.level)), msg, keysAndValues...)
+}
+
+// Info logs to the INFO log.
+// Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
+func Info(args ...interface{}) {
+	logDepth(0, zapcore.InfoLevel, fmt.Sprint(args...))
+}
+
+// InfoDepth acts as Info but uses depth to determine which call frame to log.
+// InfoDepth(0, "msg") is the same as Info("msg").
+func InfoDepth(depth int, args ...interface{}) {
+	logDepth(depth, zapcore.InfoLevel, fmt.Sprint(args...))
+}
+
+// Infoln logs to the INFO log.
+// Arguments are handled in the manner of fmt.Println; a newline is always appended.
+func Infoln(args ...interface{}) {
+	logDepth(0, zapcore.InfoLevel, sprintln(args...))
+}
+
+// Infof logs to the INFO log.
+// Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
+func Infof(format string, args ...interface{}) {
+	logDepth(0, zapcore.InfoLevel, fmt.Sprintf(format, args...))
+}
+
+// InfoS structured logs to the INFO log.
+// The msg argument used to add constant description to the log line.
+// The key/value pairs would be join by "=" ; a newline is always appended.
+//
+// Basic examples:
+// >> klog.InfoS("Pod status updated", "pod", "kubedns", "status", "ready")
+func InfoS(msg string, keysAndValues ...interface{}) {
+	logDepth(0, zapcore.InfoLevel, msg, keysAndValues...)
+}
+
+// InfoSDepth acts as InfoS but uses depth to determine which call frame to log.
+// InfoSDepth(0, "msg") is the same as InfoS("msg").
+func InfoSDepth(depth int, msg string, keysAndValues ...interface{}) {
+	logDepth(depth, zapcore.InfoLevel, msg, keysAndValues...)
+}
+
+// Warning logs to the WARNING and INFO logs.
+// Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
+func Warning(args ...interface{}) {
+	logDepth(0, zapcore.WarnLevel, fmt.Sprint(args...))
+}
+
+// WarningDepth acts as Warning but uses depth to determine which call frame to log.
+// WarningDepth(0, "msg") is the same as Warning("msg").
+func WarningDepth(depth int, args ...interface{}) {
+	logDepth(depth, zapcore.WarnLevel, fmt.Sprint(args...))
+}
+
+// Warningln logs to the WARNING and INFO logs.
+// Arguments are handled in the manner of fmt.Println; a newline is always appended.
+func Warningln(args ...interface{}) {
+	logDepth(0, zapcore.WarnLevel, sprintln(args...))
+}
+
+// Warningf logs to the WARNING and INFO logs.
+// Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
+func Warningf(format string, args ...interface{}) {
+	logDepth(0, zapcore.WarnLevel, fmt.Sprintf(format, args...))
+}
+
+// Error logs to the ERROR, WARNING, and INFO logs.
+// Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
+func Error(args ...interface{}) {
+	logDepth(0, zapcore.ErrorLevel, fmt.Sprint(args...))
+}
+
+// ErrorDepth acts as Error but uses depth to determine which call frame to log.
+// ErrorDepth(0, "msg") is the same as Error("msg").
+func ErrorDepth(depth int, args ...interface{}) {
+	logDepth(depth, zapcore.ErrorLevel, fmt.Sprint(args...))
+}
+
+// Errorln logs to the ERROR, WARNING, and INFO logs.
+// Arguments are handled in the manner of fmt.Println; a newline is always appended.
+func Errorln(args ...interface{}) {
+	logDepth(0, zapcore.ErrorLevel, sprintln(args...))
+}
+
+// Errorf logs to the ERROR, WARNING, and INFO logs.
+// Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
+func Errorf(format string, args ...interface{}) {
+	logDepth(0, zapcore.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// ErrorS structured logs to the ERROR, WARNING, and INFO logs.
+// the err argument used as "error" value in structured logs.
+// The msg argument used to add constant description to the log line.
+//
+// Basic examples:
+// >> klog.ErrorS(err, "Failed to update pod status")
+func ErrorS(err error, msg string, keysAndValues ...interface{}) {
+	logDepth(0, zapcore.ErrorLevel, msg, withError(err, keysAndValues)...)
+}
+
+// ErrorSDepth acts as ErrorS but uses depth to determine which call frame to log.
+// ErrorSDepth(0, "msg") is the same as ErrorS("msg").
+func ErrorSDepth(depth int, err error, msg string, keysAndValues ...interface{}) {
+	logDepth(depth, zapcore.ErrorLevel, msg, withError(err, keysAndValues)...)
+}
+
+// Fatal logs to the FATAL, ERROR, WARNING, and INFO logs,
+// then calls os.Exit(1).
+// Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
+func Fatal(args ...interface{}) {
+	logDepth(0, zapcore.FatalLevel, fmt.Sprint(args...))
+}
+
+// FatalDepth acts as Fatal but uses depth to determine which call frame to log.
+// FatalDepth(0, "msg") is the same as Fatal("msg").
+func FatalDepth(depth int, args ...interface{}) {
+	logDepth(depth, zapcore.FatalLevel, fmt.Sprint(args...))
+}
+
+// Fatalln logs to the FATAL, ERROR, WARNING, and INFO logs,
+// then calls os.Exit(1).
+// Arguments are handled in the manner of fmt.Println; a newline is always appended.
+func Fatalln(args ...interface{}) {
+	logDepth(0, zapcore.FatalLevel, sprintln(args...))
+}
+
+// Fatalf logs to the FATAL, ERROR, WARNING, and INFO logs,
+// then calls os.Exit(1).
+// Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
+func Fatalf(format string, args ...interface{}) {
+	logDepth(0, zapcore.FatalLevel, fmt.Sprintf(format, args...))
+}
+
+// Exit logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
+// Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
+func Exit(args ...interface{}) {
+	logDepth(0, zapcore.FatalLevel, fmt.Sprint(args...))
+}
+
+// Exitf logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
+// Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
+func Exitf(format string, args ...interface{}) {
+	logDepth(0, zapcore.FatalLevel, fmt.Sprintf(format, args...))
+}
+
+// logDepth writes a record for the caller of the exported klog function,
+// depth frames further up the stack.
+func logDepth(depth int, lvl zapcore.Level, msg string, keysAndValues ...interface{}) {
+	l := logger
+	if depth > 0 {
+		l = l.Desugar().WithOptions(zap.AddCallerSkip(depth)).Sugar()
+	}
+	switch lvl {
+	case zapcore.DebugLevel:
+		l.Debugw(msg, keysAndValues...)
+	case zapcore.InfoLevel:
+		l.Infow(msg, keysAndValues...)
+	case zapcore.WarnLevel:
+		l.Warnw(msg, keysAndValues...)
+	case zapcore.ErrorLevel:
+		l.Errorw(msg, keysAndValues...)
+	default:
+		l.Fatalw(msg, keysAndValues...)
+	}
+}
+
+// withError prepends err, if any, to the key/value pairs.
+func withError(err error, keysAndValues []interface{}) []interface{} {
+	if err == nil {
+		return keysAndValues
+	}
+	return append([]interface{}{zap.Error(err)}, keysAndValues...)
+}
+
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
@@ -0,0 +1,43 @@
+package klog
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/mayadata-io/mlogger/common"
+)
+
+func TestInitFlags(t *testing.T) {
+	defer common.SetVerbosity(common.Verbosity())
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	InitFlags(fs)
+	if err := fs.Parse([]string{"-logtostderr", "-v=2", "-skip_headers=false", "-vmodule", "a=1", "pool"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := common.Verbosity(); v != 2 {
+		t.Errorf("verbosity %d, want 2", v)
+	}
+	if args := fs.Args(); len(args) != 1 || args[0] != "pool" {
+		t.Errorf("arguments %q, want [pool]", args)
+	}
+}
+
+func TestInitFlagsExistingV(t *testing.T) {
+	defer common.SetVerbosity(common.Verbosity())
+
+	// as defined by glog
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	glogV := fs.Int("v", 0, "log level for V logs")
+	InitFlags(fs)
+	InitFlags(fs)
+	if err := fs.Parse([]string{"-v=3"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := common.Verbosity(); v != 3 || *glogV != 3 {
+		t.Errorf("verbosity %d, glog -v %d, want 3", v, *glogV)
+	}
+	if err := fs.Set("v", "x"); err == nil {
+		t.Error("invalid -v accepted")
+	}
+}
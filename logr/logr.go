@@ -0,0 +1,160 @@
+package logr
+
+import (
+	"fmt"
+	"sync"
+
+	gologr "github.com/go-logr/logr"
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a logr.Logger writing through the mlogger core, for libraries
+// such as controller-runtime that log through logr:
+//
+//	ctrl.SetLogger(logr.New())
+//
+// Its records go through common.Logger, with the caller, stacktrace and
+// error output settings of the mlogger configuration.
+func New() gologr.Logger {
+	return gologr.New(&sink{base: common.Logger.Desugar().WithOptions(zap.AddCallerSkip(-1))})
+}
+
+// NewSink returns a logr.LogSink writing to core.
+//
+// V(n) follows the mlogger verbosity model: it is enabled when n is at most
+// common.Verbosity(), V(0) is logged at Info and higher levels at Debug
+// with the level recorded under common.VKey. WithName builds a dotted
+// logger name, WithValues adds fields, and Error logs at Error with the
+// error under "error" whatever the verbosity.
+//
+// Records carry their caller. Stacktraces are not added, and errors of
+// the core are written to stderr; loggers built by New follow the mlogger
+// configuration instead.
+func NewSink(core zapcore.Core) gologr.LogSink {
+	return &sink{base: zap.New(core, zap.AddCaller())}
+}
+
+type sink struct {
+	// base is the logger the sink derives its own from, reporting the
+	// caller of its methods.
+	base   *zap.Logger
+	name   string
+	fields []zapcore.Field
+	depth  int
+
+	once sync.Once
+	log  *zap.Logger
+}
+
+var (
+	_ gologr.LogSink          = (*sink)(nil)
+	_ gologr.CallDepthLogSink = (*sink)(nil)
+)
+
+// Init receives the call depth of the logr.Logger in front of the sink.
+func (s *sink) Init(info gologr.RuntimeInfo) {
+	s.depth += info.CallDepth
+	s.log = s.build()
+}
+
+// build creates the zap logger the sink writes through. The caller is
+// looked up past the sink's method and the logr.Logger frames.
+func (s *sink) build() *zap.Logger {
+	log := s.base.WithOptions(zap.AddCallerSkip(1 + s.depth)).With(s.fields...)
+	if s.name != "" {
+		log = log.Named(s.name)
+	}
+	return log
+}
+
+// clone returns a copy of the sink without its logger, which the caller
+// builds once it has made its changes.
+func (s *sink) clone() *sink {
+	return &sink{
+		base:   s.base,
+		name:   s.name,
+		fields: s.fields[:len(s.fields):len(s.fields)],
+		depth:  s.depth,
+	}
+}
+
+func (s *sink) Enabled(level int) bool {
+	return common.V(level) && s.base.Core().Enabled(common.VLevel(level))
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	if ce := s.logger().Check(common.VLevel(level), msg); ce != nil {
+		fields := s.handleFields(keysAndValues)
+		if level > 0 {
+			fields = append(fields, zap.Int(common.VKey, level))
+		}
+		ce.Write(fields...)
+	}
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if ce := s.logger().Check(zapcore.ErrorLevel, msg); ce != nil {
+		ce.Write(append(s.handleFields(keysAndValues), zap.Error(err))...)
+	}
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) gologr.LogSink {
+	c := s.clone()
+	c.fields = append(c.fields, s.handleFields(keysAndValues)...)
+	c.log = c.build()
+	return c
+}
+
+func (s *sink) WithName(name string) gologr.LogSink {
+	c := s.clone()
+	if c.name != "" {
+		c.name += "."
+	}
+	c.name += name
+	c.log = c.build()
+	return c
+}
+
+func (s *sink) WithCallDepth(depth int) gologr.LogSink {
+	c := s.clone()
+	c.depth += depth
+	c.log = c.build()
+	return c
+}
+
+// logger returns the zap logger of the sink, building one for sinks used
+// without a logr.Logger calling Init. Such sinks may be used concurrently
+// from their first call on.
+func (s *sink) logger() *zap.Logger {
+	s.once.Do(func() {
+		if s.log == nil {
+			s.log = s.build()
+		}
+	})
+	return s.log
+}
+
+// handleFields converts logr key/value pairs into zap fields. Keys that are
+// not strings and keys without a value are reported rather than dropped.
+func (s *sink) handleFields(keysAndValues []interface{}) []zapcore.Field {
+	fields := make([]zapcore.Field, 0, len(keysAndValues)/2+1)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i == len(keysAndValues)-1 {
+			fields = append(fields, zap.Any("ignored key without a value", keysAndValues[i]))
+			break
+		}
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			fields = append(fields, zap.String("ignored non-string key", fmt.Sprint(keysAndValues[i])))
+			continue
+		}
+		val := keysAndValues[i+1]
+		if m, ok := val.(gologr.Marshaler); ok {
+			val = m.MarshalLog()
+		}
+		fields = append(fields, zap.Any(key, val))
+	}
+	return fields
+}
@@ -0,0 +1,51 @@
+package logr
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	gologr "github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSinkWithoutInit(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	s := NewSink(core)
+
+	// the first calls build the logger of the sink concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Info(0, "reconciled", "pool", "a")
+		}()
+	}
+	wg.Wait()
+	if n := logs.Len(); n != 4 {
+		t.Errorf("logged %d records, want 4", n)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := gologr.New(NewSink(core)).WithName("pool").WithValues("pool", "a")
+	log.Info("reconciled")
+	log.Error(errors.New("timeout"), "reconcile failed", "attempt", 2)
+
+	for _, e := range logs.All() {
+		if !strings.HasSuffix(e.Caller.File, "logr_test.go") {
+			t.Errorf("%s: caller %v, want the test", e.Message, e.Caller)
+		}
+		if e.LoggerName != "pool" || e.ContextMap()["pool"] != "a" {
+			t.Errorf("%s: logger %q, fields %v", e.Message, e.LoggerName, e.ContextMap())
+		}
+	}
+	failed := logs.FilterMessage("reconcile failed").All()
+	if len(failed) != 1 || failed[0].Level != zapcore.ErrorLevel || failed[0].ContextMap()["error"] != "timeout" {
+		t.Errorf("error records %v", failed)
+	}
+}
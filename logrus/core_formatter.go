@@ -0,0 +1,170 @@
+package logrus
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+
+	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// CoreFormatter hands entries to the mlogger core shared with the glog shim
+// instead of rendering them, so that the core's pipeline and encoding apply
+// to logrus loggers too. Nothing is written to the logger's Out.
+//
+// The standard logger and those returned by New use it until they are
+// given a formatter of their own, unless the mlogger configuration sets
+// DisableLogrusCore; they then render entries with TextFormatter.
+type CoreFormatter struct{}
+
+// Format writes the entry to the mlogger core and returns an empty buffer.
+func (f *CoreFormatter) Format(entry *Entry) ([]byte, error) {
+	return nil, writeEntry(common.Core(), entry)
+}
+
+// defaultFormatter is the formatter of the loggers of the shim: the
+// CoreFormatter or, when the configuration disables it, TextFormatter.
+type defaultFormatter struct {
+	text TextFormatter
+}
+
+func (f *defaultFormatter) Format(entry *Entry) ([]byte, error) {
+	if common.LogrusCore() {
+		return new(CoreFormatter).Format(entry)
+	}
+	return f.text.Format(entry)
+}
+
+// toCore reports whether f hands entries to the core, which redacts
+// them.
+func toCore(f Formatter) bool {
+	switch f.(type) {
+	case *CoreFormatter:
+		return true
+	case *defaultFormatter:
+		return common.LogrusCore()
+	}
+	return false
+}
+
+// writeEntry writes a logrus entry to core, if core enables its level.
+func writeEntry(core zapcore.Core, entry *Entry) error {
+	ent := zapcore.Entry{
+		Level:   zapLevel(Level(entry.Level)),
+		Time:    entry.Time,
+		Message: entry.Message,
+	}
+	if !core.Enabled(ent.Level) {
+		return nil
+	}
+	ent.Caller = entryCaller(entry)
+	data := Fields(entry.Data)
+	if name, ok := data[common.LoggerKey].(string); ok {
+		ent.LoggerName = name
+		data = withoutKey(data, common.LoggerKey)
+	}
+	return core.Write(ent, zapFields(data))
+}
+
+// withoutKey returns a copy of data without key.
+func withoutKey(data Fields, key string) Fields {
+	copied := make(Fields, len(data))
+	for k, v := range data {
+		if k != key {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// zapLevel maps a logrus level onto the zap level of the same severity.
+// TraceLevel has no zap counterpart and is logged at DebugLevel.
+func zapLevel(level Level) zapcore.Level {
+	switch level {
+	case PanicLevel:
+		return zapcore.PanicLevel
+	case FatalLevel:
+		return zapcore.FatalLevel
+	case ErrorLevel:
+		return zapcore.ErrorLevel
+	case WarnLevel:
+		return zapcore.WarnLevel
+	case InfoLevel:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
+
+// zapFields converts logrus fields into zap fields, sorted by key so that
+// the output is stable.
+func zapFields(data Fields) []zapcore.Field {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zapcore.Field, 0, len(keys))
+	for _, k := range keys {
+		fields = append(fields, zap.Any(k, data[k]))
+	}
+	return fields
+}
+
+// entryCaller returns the caller logrus recorded for the entry or, when
+// caller reporting is off, the first frame outside the logrus packages.
+func entryCaller(entry *Entry) zapcore.EntryCaller {
+	if (*lrs.Entry)(entry).HasCaller() {
+		return zapcore.NewEntryCaller(entry.Caller.PC, entry.Caller.File, entry.Caller.Line, true)
+	}
+
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !isLogrusFrame(frame.Function) {
+			return zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, frame.PC != 0)
+		}
+		if !more {
+			return zapcore.EntryCaller{}
+		}
+	}
+}
+
+var logrusPackages = []string{
+	"github.com/mayadata-io/mlogger/logrus.",
+	"github.com/Sirupsen/logrus.",
+	"github.com/sirupsen/logrus.",
+	// lines written to Logger.Writer are logged by the goroutine reading
+	// the pipe, which has no caller of interest
+	"github.com/mayadata-io/mlogger/common.(*LineWriter).",
+	"io.",
+	"runtime.",
+}
+
+func isLogrusFrame(function string) bool {
+	for _, pkg := range logrusPackages {
+		if strings.HasPrefix(function, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodePanic lets common.Recover log the entries Entry.Panic panics with
+// by their message and fields.
+func decodePanic(v interface{}) (string, []zapcore.Field, bool) {
+	entry, ok := v.(*lrs.Entry)
+	if !ok {
+		return "", nil, false
+	}
+	return entry.Message, zapFields(Fields(entry.Data)), true
+}
+
+func init() {
+	common.RegisterPanicDecoder(decodePanic)
+	lrs.SetFormatter(&WrapFormatter{internal: new(defaultFormatter)})
+}
@@ -0,0 +1,113 @@
+package logrus
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mayadata-io/mlogger/common"
+)
+
+// configure installs cfg writing to a file, and returns a function
+// returning what the core wrote.
+func configure(t *testing.T, cfg common.Config) func() string {
+	dir, err := ioutil.TempDir("", "logrus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "out.log")
+	cfg.OutputPaths = []string{path}
+	if err := common.Configure(cfg); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() string {
+		defer os.RemoveAll(dir)
+		common.Logger.Sync()
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+func TestDefaultToCore(t *testing.T) {
+	defer common.Configure(common.NewConfig())
+	output := configure(t, common.NewConfig())
+
+	var out bytes.Buffer
+	logger := New()
+	logger.Out = &out
+	logger.WithError(errors.New("disk gone")).WithField("pool", "a").Error("pool failed")
+	std := StandardLogger()
+	defer std.SetOutput(std.Out)
+	std.SetOutput(&out)
+	Info("from the standard logger")
+
+	got := output()
+	if out.Len() != 0 {
+		t.Errorf("rendered to Out: %s", out.String())
+	}
+	for _, want := range []string{
+		`"msg":"pool failed"`,
+		`"pool":"a"`,
+		`"error":{"message":"disk gone"`,
+		`"msg":"from the standard logger"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %s: %s", want, got)
+		}
+	}
+}
+
+func TestDisableLogrusCore(t *testing.T) {
+	defer common.Configure(common.NewConfig())
+	cfg := common.NewConfig()
+	cfg.DisableLogrusCore = true
+	output := configure(t, cfg)
+
+	var text, json bytes.Buffer
+	logger := New()
+	logger.Out = &text
+	logger.WithField("password", "hunter2").Info("rendered")
+	own := New()
+	own.Out = &json
+	own.SetFormatter(new(JSONFormatter))
+	own.Info("own formatter")
+
+	if got := output(); got != "" {
+		t.Errorf("core got %s", got)
+	}
+	if got := text.String(); !strings.Contains(got, "msg=rendered") || !strings.Contains(got, `password="[REDACTED]"`) {
+		t.Errorf("Out got %q", got)
+	}
+	if got := json.String(); !strings.Contains(got, `"msg":"own formatter"`) {
+		t.Errorf("Out got %q", got)
+	}
+}
+
+type pod struct {
+	name, namespace string
+}
+
+func (p pod) GetName() string      { return p.name }
+func (p pod) GetNamespace() string { return p.namespace }
+
+func TestWithObject(t *testing.T) {
+	defer common.Configure(common.NewConfig())
+	output := configure(t, common.NewConfig())
+
+	logger := New()
+	logger.WithObject(pod{"a", "ns"}).Info("scheduled")
+	logger.WithField("n", 1).WithObject(pod{"a", "ns"}).Info("bound")
+	WithObject(pod{"a", "ns"}).Info("running")
+
+	if got, want := output(), `"object":{"namespace":"ns","name":"a","kind":"pod"}`; strings.Count(got, want) != 3 {
+		t.Errorf("want %s in every record: %s", want, got)
+	}
+}
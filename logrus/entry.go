@@ -3,6 +3,7 @@ package logrus
 import (
 	"context"
 	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
 	"time"
 )
 
@@ -34,6 +35,22 @@ func (entry *Entry) WithError(err error) *Entry {
 	return (*Entry)((*lrs.Entry)(entry).WithError(err))
 }
 
+// Add a reference to a Kubernetes object (see common.KObj) to the Entry,
+// under the key common.ObjectKey.
+func (entry *Entry) WithObject(obj common.KMetadata) *Entry {
+	return entry.WithField(common.ObjectKey, common.KObj(obj))
+}
+
+// Name the Entry after a subsystem (see common.Named), under the key
+// common.LoggerKey. Naming a named Entry joins the names with dots. The
+// level of the name applies on top of that of the Logger.
+func (entry *Entry) Named(name string) *Entry {
+	if parent, ok := entry.Data[common.LoggerKey].(string); ok && parent != "" {
+		name = parent + "." + name
+	}
+	return entry.WithField(common.LoggerKey, name)
+}
+
 // Add a context to the Entry.
 func (entry *Entry) WithContext(ctx context.Context) *Entry {
 	return (*Entry)((*lrs.Entry)(entry).WithContext(ctx))
@@ -59,125 +76,125 @@ func (entry Entry) HasCaller() (has bool) {
 }
 
 func (entry *Entry) Log(level Level, args ...interface{}) {
-	(*lrs.Entry)(entry).Log((lrs.Level)(level), args)
+	(*lrs.Entry)(entry).Log((lrs.Level)(level), args...)
 }
 
 func (entry *Entry) Trace(args ...interface{}) {
-	(*lrs.Entry)(entry).Trace(args)
+	(*lrs.Entry)(entry).Trace(args...)
 }
 
 func (entry *Entry) Debug(args ...interface{}) {
-	(*lrs.Entry)(entry).Debug(args)
+	(*lrs.Entry)(entry).Debug(args...)
 }
 
 func (entry *Entry) Print(args ...interface{}) {
-	(*lrs.Entry)(entry).Print(args)
+	(*lrs.Entry)(entry).Print(args...)
 }
 
 func (entry *Entry) Info(args ...interface{}) {
-	(*lrs.Entry)(entry).Info(args)
+	(*lrs.Entry)(entry).Info(args...)
 }
 
 func (entry *Entry) Warn(args ...interface{}) {
-	(*lrs.Entry)(entry).Warn(args)
+	(*lrs.Entry)(entry).Warn(args...)
 }
 
 func (entry *Entry) Warning(args ...interface{}) {
-	(*lrs.Entry)(entry).Warning(args)
+	(*lrs.Entry)(entry).Warning(args...)
 }
 
 func (entry *Entry) Error(args ...interface{}) {
-	(*lrs.Entry)(entry).Error(args)
+	(*lrs.Entry)(entry).Error(args...)
 }
 
 func (entry *Entry) Fatal(args ...interface{}) {
-	(*lrs.Entry)(entry).Fatal(args)
+	(*lrs.Entry)(entry).Fatal(args...)
 }
 
 func (entry *Entry) Panic(args ...interface{}) {
-	(*lrs.Entry)(entry).Panic(args)
+	(*lrs.Entry)(entry).Panic(args...)
 }
 
 // Entry Printf family functions
 
 func (entry *Entry) Logf(level Level, format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Logf((lrs.Level)(level), format, args)
+	(*lrs.Entry)(entry).Logf((lrs.Level)(level), format, args...)
 }
 
 func (entry *Entry) Tracef(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Tracef(format, args)
+	(*lrs.Entry)(entry).Tracef(format, args...)
 }
 
 func (entry *Entry) Debugf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Debugf(format, args)
+	(*lrs.Entry)(entry).Debugf(format, args...)
 }
 
 func (entry *Entry) Infof(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Infof(format, args)
+	(*lrs.Entry)(entry).Infof(format, args...)
 }
 
 func (entry *Entry) Printf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Printf(format, args)
+	(*lrs.Entry)(entry).Printf(format, args...)
 }
 
 func (entry *Entry) Warnf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Warnf(format, args)
+	(*lrs.Entry)(entry).Warnf(format, args...)
 }
 
 func (entry *Entry) Warningf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Warningf(format, args)
+	(*lrs.Entry)(entry).Warningf(format, args...)
 }
 
 func (entry *Entry) Errorf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Errorf(format, args)
+	(*lrs.Entry)(entry).Errorf(format, args...)
 }
 
 func (entry *Entry) Fatalf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Fatalf(format, args)
+	(*lrs.Entry)(entry).Fatalf(format, args...)
 }
 
 func (entry *Entry) Panicf(format string, args ...interface{}) {
-	(*lrs.Entry)(entry).Panicf(format, args)
+	(*lrs.Entry)(entry).Panicf(format, args...)
 }
 
 // Entry Println family functions
 
 func (entry *Entry) Logln(level Level, args ...interface{}) {
-	(*lrs.Entry)(entry).Logln((lrs.Level)(level), args)
+	(*lrs.Entry)(entry).Logln((lrs.Level)(level), args...)
 }
 
 func (entry *Entry) Traceln(args ...interface{}) {
-	(*lrs.Entry)(entry).Traceln(args)
+	(*lrs.Entry)(entry).Traceln(args...)
 }
 
 func (entry *Entry) Debugln(args ...interface{}) {
-	(*lrs.Entry)(entry).Debugln(args)
+	(*lrs.Entry)(entry).Debugln(args...)
 }
 
 func (entry *Entry) Infoln(args ...interface{}) {
-	(*lrs.Entry)(entry).Infoln(args)
+	(*lrs.Entry)(entry).Infoln(args...)
 }
 
 func (entry *Entry) Println(args ...interface{}) {
-	(*lrs.Entry)(entry).Println(args)
+	(*lrs.Entry)(entry).Println(args...)
 }
 
 func (entry *Entry) Warnln(args ...interface{}) {
-	(*lrs.Entry)(entry).Warnln(args)
+	(*lrs.Entry)(entry).Warnln(args...)
 }
 
 func (entry *Entry) Warningln(args ...interface{}) {
-	(*lrs.Entry)(entry).Warningln(args)
+	(*lrs.Entry)(entry).Warningln(args...)
 }
 
 func (entry *Entry) Errorln(args ...interface{}) {
-	(*lrs.Entry)(entry).Errorln(args)
+	(*lrs.Entry)(entry).Errorln(args...)
 }
 
 func (entry *Entry) Fatalln(args ...interface{}) {
-	(*lrs.Entry)(entry).Fatalln(args)
+	(*lrs.Entry)(entry).Fatalln(args...)
 }
 
 func (entry *Entry) Panicln(args ...interface{}) {
-	(*lrs.Entry)(entry).Panicln(args)
+	(*lrs.Entry)(entry).Panicln(args...)
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"time"
 	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
 )
 
 func StandardLogger() *Logger {
@@ -52,6 +53,19 @@ func WithError(err error) *Entry {
 	return (*Entry)(lrs.WithError(err))
 }
 
+// WithObject creates an entry from the standard logger and adds a reference
+// to a Kubernetes object to it, using the value defined in
+// common.ObjectKey as key.
+func WithObject(obj common.KMetadata) *Entry {
+	return StandardLogger().WithObject(obj)
+}
+
+// Named creates an entry from the standard logger named after a subsystem,
+// using the value defined in common.LoggerKey as key.
+func Named(name string) *Entry {
+	return StandardLogger().Named(name)
+}
+
 // WithContext creates an entry from the standard logger and adds a context to it.
 func WithContext(ctx context.Context) *Entry {
 	return (*Entry)(lrs.WithContext(ctx))
@@ -87,135 +101,135 @@ func WithTime(t time.Time) *Entry {
 
 // Trace logs a message at level Trace on the standard logger.
 func Trace(args ...interface{}) {
-	lrs.Trace(args)
+	lrs.Trace(args...)
 }
 
 // Debug logs a message at level Debug on the standard logger.
 func Debug(args ...interface{}) {
-	lrs.Debug(args)
+	lrs.Debug(args...)
 }
 
 // Print logs a message at level Info on the standard logger.
 func Print(args ...interface{}) {
-	lrs.Print(args)
+	lrs.Print(args...)
 }
 
 // Info logs a message at level Info on the standard logger.
 func Info(args ...interface{}) {
-	logger.Info(args)
+	logger.Info(args...)
 }
 
 // Warn logs a message at level Warn on the standard logger.
 func Warn(args ...interface{}) {
-	logger.Warn(args)
+	logger.Warn(args...)
 }
 
 // Warning logs a message at level Warn on the standard logger.
 func Warning(args ...interface{}) {
-	logger.Warn(args)
+	logger.Warn(args...)
 }
 
 // Error logs a message at level Error on the standard logger.
 func Error(args ...interface{}) {
-	logger.Error(args)
+	logger.Error(args...)
 }
 
 // Panic logs a message at level Panic on the standard logger.
 func Panic(args ...interface{}) {
-	logger.Panic(args)
+	logger.Panic(args...)
 }
 
 // Fatal logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatal(args ...interface{}) {
-	logger.Fatal(args)
+	logger.Fatal(args...)
 }
 
 // Tracef logs a message at level Trace on the standard logger.
 func Tracef(format string, args ...interface{}) {
-	lrs.Tracef(format, args)
+	lrs.Tracef(format, args...)
 }
 
 // Debugf logs a message at level Debug on the standard logger.
 func Debugf(format string, args ...interface{}) {
-	lrs.Debugf(format, args)
+	lrs.Debugf(format, args...)
 }
 
 // Printf logs a message at level Info on the standard logger.
 func Printf(format string, args ...interface{}) {
-	lrs.Printf(format, args)
+	lrs.Printf(format, args...)
 }
 
 // Infof logs a message at level Info on the standard logger.
 func Infof(format string, args ...interface{}) {
-	logger.Infof(format, args)
+	logger.Infof(format, args...)
 }
 
 // Warnf logs a message at level Warn on the standard logger.
 func Warnf(format string, args ...interface{}) {
-	logger.Warnf(format, args)
+	logger.Warnf(format, args...)
 }
 
 // Warningf logs a message at level Warn on the standard logger.
 func Warningf(format string, args ...interface{}) {
-	logger.Warnf(format, args)
+	logger.Warnf(format, args...)
 }
 
 // Errorf logs a message at level Error on the standard logger.
 func Errorf(format string, args ...interface{}) {
-	logger.Errorf(format, args)
+	logger.Errorf(format, args...)
 }
 
 // Panicf logs a message at level Panic on the standard logger.
 func Panicf(format string, args ...interface{}) {
-	logger.Panicf(format, args)
+	logger.Panicf(format, args...)
 }
 
 // Fatalf logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatalf(format string, args ...interface{}) {
-	logger.Fatalf(format, args)
+	logger.Fatalf(format, args...)
 }
 
 // Traceln logs a message at level Trace on the standard logger.
 func Traceln(args ...interface{}) {
-	lrs.Traceln(args)
+	lrs.Traceln(args...)
 }
 
 // Debugln logs a message at level Debug on the standard logger.
 func Debugln(args ...interface{}) {
-	lrs.Debugln(args)
+	lrs.Debugln(args...)
 }
 
 // Println logs a message at level Info on the standard logger.
 func Println(args ...interface{}) {
-	lrs.Println(args)
+	lrs.Println(args...)
 }
 
 // Infoln logs a message at level Info on the standard logger.
 func Infoln(args ...interface{}) {
-	logger.Info(args)
+	logger.Info(args...)
 }
 
 // Warnln logs a message at level Warn on the standard logger.
 func Warnln(args ...interface{}) {
-	logger.Warn(args)
+	logger.Warn(args...)
 }
 
 // Warningln logs a message at level Warn on the standard logger.
 func Warningln(args ...interface{}) {
-	logger.Warn(args)
+	logger.Warn(args...)
 }
 
 // Errorln logs a message at level Error on the standard logger.
 func Errorln(args ...interface{}) {
-	logger.Error(args)
+	logger.Error(args...)
 }
 
 // Panicln logs a message at level Panic on the standard logger.
 func Panicln(args ...interface{}) {
-	logger.Panic(args)
+	logger.Panic(args...)
 }
 
 // Fatalln logs a message at level Fatal on the standard logger then the process will exit with status set to 1.
 func Fatalln(args ...interface{}) {
-	logger.Fatal(args)
+	logger.Fatal(args...)
 }
@@ -2,6 +2,8 @@ package logrus
 
 import (
 	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
 )
 
 // A hook to be fired when logging on the logging levels returned from
@@ -25,3 +27,41 @@ func (hooks LevelHooks) Add(hook Hook) {
 func (hooks LevelHooks) Fire(level Level, entry *Entry) error {
 	return (lrs.LevelHooks)(hooks).Fire((lrs.Level)(level), (*lrs.Entry)(entry))
 }
+
+// CoreHook returns a hook writing the entries at levels to core, so that
+// a zapcore.Core, such as the core of an mlogger sink, receives the
+// entries of a logger in addition to its formatter and output. With no
+// levels, it fires at all of them; core still filters by its own level.
+//
+// Entries go through the stages the core behind common.Logger puts in
+// front of its sinks: their secrets are masked by the redaction installed
+// by InitLogger or Configure at the time, and ecodes are derived from their
+// errors, so that a sink fed by a hook gets what it would get from the
+// configuration.
+func CoreHook(core zapcore.Core, levels ...Level) Hook {
+	h := &coreHook{core: common.NewErrorCore(core), levels: lrs.AllLevels}
+	if len(levels) > 0 {
+		h.levels = make([]lrs.Level, len(levels))
+		for i, l := range levels {
+			h.levels[i] = lrs.Level(l)
+		}
+	}
+	return h
+}
+
+type coreHook struct {
+	core   zapcore.Core
+	levels []lrs.Level
+}
+
+func (h *coreHook) Levels() []lrs.Level {
+	return h.levels
+}
+
+func (h *coreHook) Fire(entry *lrs.Entry) error {
+	core := h.core
+	if r := common.ActiveRedactor(); r != nil {
+		core = common.NewRedactCore(core, r)
+	}
+	return writeEntry(core, (*Entry)(entry))
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
 )
 
 type Logger lrs.Logger
@@ -39,7 +40,7 @@ func (mw *MutexWrap) Disable() {
 func New() *Logger {
 	return (*Logger)(&lrs.Logger{
 		Out:          os.Stderr,
-		Formatter:    &WrapFormatter{internal:new(TextFormatter)},
+		Formatter:    &WrapFormatter{internal:new(defaultFormatter)},
 		Hooks:        (lrs.LevelHooks)(make(LevelHooks)),
 		Level:        (lrs.Level)(InfoLevel),
 		ExitFunc:     os.Exit,
@@ -66,6 +67,18 @@ func (logger *Logger) WithError(err error) *Entry {
 	return (*Entry)((*lrs.Logger)(logger).WithError(err))
 }
 
+// Add a reference to a Kubernetes object to the log entry. All it does is
+// call `WithObject` for the given object.
+func (logger *Logger) WithObject(obj common.KMetadata) *Entry {
+	return NewEntry(logger).WithObject(obj)
+}
+
+// Name the log entry after a subsystem. All it does is call `Named` for
+// the given name.
+func (logger *Logger) Named(name string) *Entry {
+	return NewEntry(logger).Named(name)
+}
+
 // Add a context to the log entry.
 func (logger *Logger) WithContext(ctx context.Context) *Entry {
 	return (*Entry)((*lrs.Logger)(logger).WithContext(ctx))
@@ -77,123 +90,123 @@ func (logger *Logger) WithTime(t time.Time) *Entry {
 }
 
 func (logger *Logger) Logf(level Level, format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Logf((lrs.Level)(level), format, args)
+	(*lrs.Logger)(logger).Logf((lrs.Level)(level), format, args...)
 }
 
 func (logger *Logger) Tracef(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Tracef(format, args)
+	(*lrs.Logger)(logger).Tracef(format, args...)
 }
 
 func (logger *Logger) Debugf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Debugf(format, args)
+	(*lrs.Logger)(logger).Debugf(format, args...)
 }
 
 func (logger *Logger) Infof(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Infof(format, args)
+	(*lrs.Logger)(logger).Infof(format, args...)
 }
 
 func (logger *Logger) Printf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Printf(format, args)
+	(*lrs.Logger)(logger).Printf(format, args...)
 }
 
 func (logger *Logger) Warnf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Warnf(format, args)
+	(*lrs.Logger)(logger).Warnf(format, args...)
 }
 
 func (logger *Logger) Warningf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Warningf(format, args)
+	(*lrs.Logger)(logger).Warningf(format, args...)
 }
 
 func (logger *Logger) Errorf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Errorf(format, args)
+	(*lrs.Logger)(logger).Errorf(format, args...)
 }
 
 func (logger *Logger) Fatalf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Fatalf(format, args)
+	(*lrs.Logger)(logger).Fatalf(format, args...)
 }
 
 func (logger *Logger) Panicf(format string, args ...interface{}) {
-	(*lrs.Logger)(logger).Panicf(format, args)
+	(*lrs.Logger)(logger).Panicf(format, args...)
 }
 
 func (logger *Logger) Log(level Level, args ...interface{}) {
-	(*lrs.Logger)(logger).Log((lrs.Level)(level), args)
+	(*lrs.Logger)(logger).Log((lrs.Level)(level), args...)
 }
 
 func (logger *Logger) Trace(args ...interface{}) {
-	(*lrs.Logger)(logger).Trace(args)
+	(*lrs.Logger)(logger).Trace(args...)
 }
 
 func (logger *Logger) Debug(args ...interface{}) {
-	(*lrs.Logger)(logger).Debug(args)
+	(*lrs.Logger)(logger).Debug(args...)
 }
 
 func (logger *Logger) Info(args ...interface{}) {
-	(*lrs.Logger)(logger).Info(args)
+	(*lrs.Logger)(logger).Info(args...)
 }
 
 func (logger *Logger) Print(args ...interface{}) {
-	(*lrs.Logger)(logger).Print(args)
+	(*lrs.Logger)(logger).Print(args...)
 }
 
 func (logger *Logger) Warn(args ...interface{}) {
-	(*lrs.Logger)(logger).Warn(args)
+	(*lrs.Logger)(logger).Warn(args...)
 }
 
 func (logger *Logger) Warning(args ...interface{}) {
-	(*lrs.Logger)(logger).Warning(args)
+	(*lrs.Logger)(logger).Warning(args...)
 }
 
 func (logger *Logger) Error(args ...interface{}) {
-	(*lrs.Logger)(logger).Error(args)
+	(*lrs.Logger)(logger).Error(args...)
 }
 
 func (logger *Logger) Fatal(args ...interface{}) {
-	(*lrs.Logger)(logger).Fatal(args)
+	(*lrs.Logger)(logger).Fatal(args...)
 }
 
 func (logger *Logger) Panic(args ...interface{}) {
-	(*lrs.Logger)(logger).Panic(args)
+	(*lrs.Logger)(logger).Panic(args...)
 }
 
 func (logger *Logger) Logln(level Level, args ...interface{}) {
-	(*lrs.Logger)(logger).Logln((lrs.Level)(level), args)
+	(*lrs.Logger)(logger).Logln((lrs.Level)(level), args...)
 }
 
 func (logger *Logger) Traceln(args ...interface{}) {
-	(*lrs.Logger)(logger).Traceln(args)
+	(*lrs.Logger)(logger).Traceln(args...)
 }
 
 func (logger *Logger) Debugln(args ...interface{}) {
-	(*lrs.Logger)(logger).Debugln(args)
+	(*lrs.Logger)(logger).Debugln(args...)
 }
 
 func (logger *Logger) Infoln(args ...interface{}) {
-	(*lrs.Logger)(logger).Infoln(args)
+	(*lrs.Logger)(logger).Infoln(args...)
 }
 
 func (logger *Logger) Println(args ...interface{}) {
-	(*lrs.Logger)(logger).Println(args)
+	(*lrs.Logger)(logger).Println(args...)
 }
 
 func (logger *Logger) Warnln(args ...interface{}) {
-	(*lrs.Logger)(logger).Warnln(args)
+	(*lrs.Logger)(logger).Warnln(args...)
 }
 
 func (logger *Logger) Warningln(args ...interface{}) {
-	(*lrs.Logger)(logger).Warningln(args)
+	(*lrs.Logger)(logger).Warningln(args...)
 }
 
 func (logger *Logger) Errorln(args ...interface{}) {
-	(*lrs.Logger)(logger).Errorln(args)
+	(*lrs.Logger)(logger).Errorln(args...)
 }
 
 func (logger *Logger) Fatalln(args ...interface{}) {
-	(*lrs.Logger)(logger).Fatalln(args)
+	(*lrs.Logger)(logger).Fatalln(args...)
 }
 
 func (logger *Logger) Panicln(args ...interface{}) {
-	(*lrs.Logger)(logger).Panicln(args)
+	(*lrs.Logger)(logger).Panicln(args...)
 }
 
 func (logger *Logger) Exit(code int) {
@@ -2,17 +2,36 @@ package logrus
 
 import (
 	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
 )
 
 type WrapFormatter struct {
 	internal Formatter
 }
 
-// Format renders a single log entry
+// Format renders a single log entry. Entries not handed to the core are
+// redacted here the way the core would redact them.
 func (f *WrapFormatter) Format(entry *lrs.Entry) ([]byte, error) {
+	if !toCore(f.internal) {
+		entry = redactEntry(entry)
+	}
 	return f.internal.Format((*Entry)(entry))
 }
 
+// redactEntry returns a copy of entry with its message and fields passed
+// through the active redactor. The entry's Data map may be shared with the
+// Entry it was logged from, so it is never modified in place.
+func redactEntry(entry *lrs.Entry) *lrs.Entry {
+	r := common.ActiveRedactor()
+	if r == nil {
+		return entry
+	}
+	redacted := *entry
+	redacted.Message = r.Message(entry.Message)
+	redacted.Data = r.Map(entry.Data)
+	return &redacted
+}
+
 // TextFormatter formats logs into text
 type TextFormatter lrs.TextFormatter
 
@@ -1,22 +1,55 @@
 package logrus
 
 import (
-	lrs "github.com/Sirupsen/logrus"
 	"io"
+	"runtime"
+
+	lrs "github.com/Sirupsen/logrus"
+	"github.com/mayadata-io/mlogger/common"
 )
 
+// Writer returns a writer logging every line written to it at InfoLevel.
+// Lines are split by common.LineWriter, so unlike upstream logrus there is
+// no limit on their length. Closing the writer logs a trailing incomplete
+// line and ends the goroutine reading from the pipe, which a finalizer
+// also does when the writer is dropped without being closed.
+//
+// The *io.PipeWriter result, kept for compatibility with upstream logrus,
+// can only come from io.Pipe, so the goroutine cannot be avoided here.
+// Callers that need no goroutine can log through common.NewLineWriter
+// instead, as in
+//
+//	w := common.NewLineWriter(func(line string) { logger.Info(line) })
 func (logger *Logger) Writer() *io.PipeWriter {
-	return (*lrs.Logger)(logger).Writer()
+	return logger.WriterLevel(InfoLevel)
 }
 
 func (logger *Logger) WriterLevel(level Level) *io.PipeWriter {
-	return (*lrs.Logger)(logger).WriterLevel((lrs.Level)(level))
+	return pipe(func(line string) {
+		(*lrs.Logger)(logger).Log((lrs.Level)(level), line)
+	})
 }
 
 func (entry *Entry) Writer() *io.PipeWriter {
-	return (*lrs.Entry)(entry).Writer()
+	return entry.WriterLevel(InfoLevel)
 }
 
 func (entry *Entry) WriterLevel(level Level) *io.PipeWriter {
-	return (*lrs.Entry)(entry).WriterLevel((lrs.Level)(level))
-}
\ No newline at end of file
+	return pipe(func(line string) {
+		(*lrs.Entry)(entry).Log((lrs.Level)(level), line)
+	})
+}
+
+// pipe returns the writer of a pipe whose lines are handed to emit by a
+// goroutine copying the pipe into a common.LineWriter.
+func pipe(emit func(line string)) *io.PipeWriter {
+	r, w := io.Pipe()
+	go func() {
+		lw := common.NewLineWriter(emit)
+		io.Copy(lw, r)
+		lw.Close()
+		r.Close()
+	}()
+	runtime.SetFinalizer(w, (*io.PipeWriter).Close)
+	return w
+}
@@ -0,0 +1,39 @@
+package fluent
+
+// buffer holds in memory the chunks that could not be delivered, oldest
+// first, up to a total size. Chunks outliving a restart are the business
+// of the spool of the sink, set through SinkConfig.Spool.
+type buffer struct {
+	chunks [][]byte
+	size   int64
+	limit  int64
+}
+
+// push appends chunk, or reports false when it does not fit.
+func (b *buffer) push(chunk []byte) bool {
+	if b.size+int64(len(chunk)) > b.limit {
+		return false
+	}
+	b.chunks = append(b.chunks, chunk)
+	b.size += int64(len(chunk))
+	return true
+}
+
+// peek returns the oldest chunk, or nil when empty.
+func (b *buffer) peek() []byte {
+	if len(b.chunks) == 0 {
+		return nil
+	}
+	return b.chunks[0]
+}
+
+// pop removes the oldest chunk.
+func (b *buffer) pop() {
+	b.size -= int64(len(b.chunks[0]))
+	b.chunks[0] = nil
+	b.chunks = b.chunks[1:]
+}
+
+func (b *buffer) len() int {
+	return len(b.chunks)
+}
@@ -0,0 +1,216 @@
+// Package fluent provides a sink forwarding the records of the mlogger core
+// to Fluentd or Fluent Bit with the Fluent Forward protocol, over TCP or a
+// unix socket.
+//
+// Records are tagged with a prefix, the program name by default, followed
+// by the name of their logger: records of common.Named("volume") from
+// "maya-apiserver" are tagged "maya-apiserver.volume". They are batched per
+// tag and sent in Forward mode from a background goroutine, optionally
+// waiting for the server to acknowledge each chunk. While the server cannot
+// be reached, chunks are kept in a buffer in memory and sent again, oldest
+// first, with exponential backoff between attempts. For records to survive
+// a restart as well, give the sink a spool with SinkConfig.Spool.
+//
+// Importing the package registers the "fluent" sink type, configured
+// through SinkConfig.Params:
+//
+//	network         tcp (default) or unix
+//	address         server address; 127.0.0.1:24224 by default for tcp
+//	tag-prefix      prefix of the tags; the program name by default
+//	require-ack     wait for the server to acknowledge each chunk
+//	ack-timeout     how long to wait for an ack; 10s by default
+//	batch-size      records per chunk; 256 by default
+//	flush-interval  longest time a record waits to be sent; 1s by default
+//	queue-size      records waiting for the forwarder; 8192 by default
+//	buffer-limit    size of the buffer in bytes; 64MiB by default
+//	min-backoff, max-backoff  bounds of the delay between retries
+//	sync-timeout    bound of Sync; 5s by default
+//
+// The fluenttest package provides a stand-in server for tests.
+package fluent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"github.com/mayadata-io/mlogger/sink/fluent/internal/msgpack"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultAddress is the address of the forward input of a local server.
+const DefaultAddress = "127.0.0.1:24224"
+
+// Keys of the records, as in the encoder configuration of common.
+const (
+	messageKey = "msg"
+	levelKey   = "severity"
+	stackKey   = "stacktrace"
+)
+
+// Config describes a fluent sink.
+type Config struct {
+	// Network is "tcp" or "unix".
+	Network string
+	// Address is the address of the server.
+	Address string
+	// TagPrefix starts the tag of every record. The name of the logger,
+	// if any, is appended to it after a dot.
+	TagPrefix string
+	// RequireAck makes the sink wait for the server to acknowledge each
+	// chunk, for up to AckTimeout, and send it again otherwise.
+	RequireAck bool
+	AckTimeout time.Duration
+	// BatchSize is the number of records a chunk holds at most.
+	BatchSize int
+	// FlushInterval bounds how long a record waits for its chunk to fill.
+	FlushInterval time.Duration
+	// QueueSize is the number of records waiting for the forwarder beyond
+	// which records are dropped.
+	QueueSize int
+	// BufferLimit is the size in bytes of the buffer of the chunks that
+	// could not be delivered beyond which chunks are dropped.
+	BufferLimit int64
+	// MinBackoff and MaxBackoff bound the delay between attempts to reach
+	// the server, which doubles after each failure.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// DialTimeout and WriteTimeout bound connecting and sending a chunk.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	// SyncTimeout bounds Sync.
+	SyncTimeout time.Duration
+}
+
+// New returns a core forwarding records at the levels enab enables to the
+// server described by cfg. The connection is established on first use.
+//
+// Records dropped because the queue or the buffer is full, and chunks
+// still buffered, are reported by Sync, which also sends the pending
+// chunks without waiting for the backoff to expire, for up to
+// cfg.SyncTimeout. The core implements io.Closer; closing it stops the
+// forwarder and closes its connection.
+func New(cfg Config, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	switch cfg.Network {
+	case "tcp":
+		if cfg.Address == "" {
+			cfg.Address = DefaultAddress
+		}
+	case "unix":
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("fluent: no address for network %q", cfg.Network)
+		}
+	default:
+		return nil, fmt.Errorf("fluent: unknown network %q", cfg.Network)
+	}
+	if cfg.TagPrefix == "" {
+		cfg.TagPrefix = filepath.Base(os.Args[0])
+	}
+	if cfg.AckTimeout == 0 {
+		cfg.AckTimeout = 10 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 256
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.BufferLimit == 0 {
+		cfg.BufferLimit = 64 << 20
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 5 * time.Second
+	}
+	if cfg.SyncTimeout == 0 {
+		cfg.SyncTimeout = 5 * time.Second
+	}
+	return &core{
+		LevelEnabler: enab,
+		tagPrefix:    cfg.TagPrefix,
+		fwd:          startForwarder(cfg),
+	}, nil
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	tagPrefix string
+	fwd       *forwarder
+	fields    []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	tag := c.tagPrefix
+	if ent.LoggerName != "" {
+		tag += "." + ent.LoggerName
+	}
+	c.fwd.enqueue(tag, c.encode(ent, fields))
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.fwd.sync()
+}
+
+func (c *core) Close() error {
+	return c.fwd.close()
+}
+
+// encode renders a record as the [time, record] entry of a Forward mode
+// message.
+func (c *core) encode(ent zapcore.Entry, fields []zapcore.Field) []byte {
+	record := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(record)
+	}
+	for _, f := range fields {
+		f.AddTo(record)
+	}
+	record.Fields[messageKey] = ent.Message
+	record.Fields[levelKey] = ent.Level.String()
+	if _, ok := record.Fields[common.ECodeKey]; !ok && ent.Caller.Defined {
+		record.Fields[common.ECodeKey] = common.PackagePath(ent.Caller, 3)
+	}
+	if ent.LoggerName != "" {
+		record.Fields[common.LoggerKey] = ent.LoggerName
+	}
+	if ent.Stack != "" {
+		record.Fields[stackKey] = ent.Stack
+	}
+
+	b := msgpack.AppendArrayHeader(nil, 2)
+	b = msgpack.AppendEventTime(b, ent.Time)
+	return msgpack.Append(b, record.Fields)
+}
@@ -0,0 +1,151 @@
+package fluent
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mayadata-io/mlogger/sink/fluent/fluenttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newTestCore(t *testing.T, cfg Config) zapcore.Core {
+	cfg.TagPrefix = "maya"
+	cfg.FlushInterval = time.Hour
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	c, err := New(cfg, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func startServer(t *testing.T, network, address string) *fluenttest.Server {
+	srv, err := fluenttest.NewServer(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestForward(t *testing.T) {
+	srv := startServer(t, "tcp", "127.0.0.1:0")
+	defer srv.Close()
+	c := newTestCore(t, Config{Address: srv.Addr()})
+	defer c.(io.Closer).Close()
+
+	log := zap.New(c)
+	log.Named("volume").Info("created", zap.String("pool", "p1"))
+	log.Named("volume").With(zap.Int("size", 10)).Warn("resized")
+	log.Info("started")
+	log.Debug("disabled")
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := srv.WaitEvents(3, time.Second)
+	if len(events) != 3 {
+		t.Fatalf("received %d events, want 3", len(events))
+	}
+	byMsg := make(map[string]fluenttest.Event)
+	for _, ev := range events {
+		byMsg[ev.Record[messageKey].(string)] = ev
+	}
+	created := byMsg["created"]
+	if created.Tag != "maya.volume" || created.Record["pool"] != "p1" || created.Record[levelKey] != "info" {
+		t.Errorf("created = %+v", created)
+	}
+	if resized := byMsg["resized"]; resized.Record["size"] != int64(10) {
+		t.Errorf("resized = %+v", resized)
+	}
+	if started := byMsg["started"]; started.Tag != "maya" {
+		t.Errorf("started = %+v", started)
+	}
+	if errs := srv.Errors(); len(errs) > 0 {
+		t.Errorf("server errors: %v", errs)
+	}
+}
+
+func TestAck(t *testing.T) {
+	srv := startServer(t, "tcp", "127.0.0.1:0")
+	defer srv.Close()
+	c := newTestCore(t, Config{Address: srv.Addr(), RequireAck: true, AckTimeout: 50 * time.Millisecond})
+	defer c.(io.Closer).Close()
+
+	srv.SetAck(false)
+	zap.New(c).Info("lost once")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "1 chunks buffered") {
+		t.Fatalf("Sync = %v", err)
+	}
+	srv.SetAck(true)
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if events := srv.Events(); len(events) != 1 {
+		t.Errorf("received %d events, want 1", len(events))
+	}
+}
+
+func TestBufferWhileDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "forward.sock")
+
+	c := newTestCore(t, Config{Network: "unix", Address: sock})
+	defer c.(io.Closer).Close()
+	log := zap.New(c)
+	log.Info("first")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "1 chunks buffered") {
+		t.Fatalf("Sync = %v", err)
+	}
+	log.Info("second")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "2 chunks buffered") {
+		t.Fatalf("Sync = %v", err)
+	}
+
+	srv := startServer(t, "unix", sock)
+	defer srv.Close()
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	events := srv.WaitEvents(2, time.Second)
+	if len(events) != 2 || events[0].Record[messageKey] != "first" || events[1].Record[messageKey] != "second" {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestSyncIsBoundedAndClose(t *testing.T) {
+	srv := startServer(t, "tcp", "127.0.0.1:0")
+	defer srv.Close()
+	srv.SetAck(false)
+	c := newTestCore(t, Config{
+		Address:     srv.Addr(),
+		RequireAck:  true,
+		AckTimeout:  time.Hour,
+		SyncTimeout: 50 * time.Millisecond,
+	})
+
+	zap.New(c).Info("unacked")
+	start := time.Now()
+	if err := c.Sync(); err == nil {
+		t.Error("Sync succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Sync took %v", d)
+	}
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Sync after Close = %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+// Package fluenttest provides a stand-in Fluent Forward server for testing
+// the fluent sink without Fluentd or Fluent Bit.
+//
+//	srv, err := fluenttest.NewServer("tcp", "127.0.0.1:0")
+//	...
+//	cfg := common.NewConfig()
+//	cfg.Sinks = []common.SinkConfig{{
+//		Type:   "fluent",
+//		Params: map[string]string{"address": srv.Addr(), "require-ack": "true"},
+//	}}
+//	...
+//	common.Logger.Sync()
+//	events := srv.Events()
+package fluenttest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mayadata-io/mlogger/sink/fluent/internal/msgpack"
+)
+
+// Event is a record received by the server.
+type Event struct {
+	Tag    string
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// Server accepts the Message, Forward, PackedForward and
+// CompressedPackedForward modes of the protocol, and acknowledges the
+// messages carrying a chunk option.
+type Server struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	events  []Event
+	errs    []error
+	noAck   bool
+	conns   map[net.Conn]bool
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server listening on address, such as "127.0.0.1:0"
+// for network "tcp" or a socket path for "unix".
+func NewServer(network, address string) (*Server, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{ln: ln, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Events returns the events received so far.
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Errors returns the protocol errors met so far; each closed the
+// connection it happened on.
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// WaitEvents waits until the server has received at least n events or
+// timeout has elapsed, and returns the events received.
+func (s *Server) WaitEvents(n int, timeout time.Duration) []Event {
+	deadline := time.Now().Add(timeout)
+	for {
+		events := s.Events()
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// SetAck makes the server acknowledge chunks or, to simulate a server
+// losing them, not. Unacknowledged messages are not recorded.
+func (s *Server) SetAck(ack bool) {
+	s.mu.Lock()
+	s.noAck = !ack
+	s.mu.Unlock()
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		msg, err := msgpack.Decode(r)
+		if err != nil {
+			if err != io.EOF {
+				s.fail(err)
+			}
+			return
+		}
+		events, option, err := parseMessage(msg)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		chunk, _ := option["chunk"].(string)
+
+		s.mu.Lock()
+		noAck := s.noAck
+		if chunk == "" || !noAck {
+			s.events = append(s.events, events...)
+		}
+		s.mu.Unlock()
+
+		if chunk != "" && !noAck {
+			ack := msgpack.AppendMapHeader(nil, 1)
+			ack = msgpack.AppendString(ack, "ack")
+			ack = msgpack.AppendString(ack, chunk)
+			if _, err := c.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	if !s.closing {
+		s.errs = append(s.errs, err)
+	}
+	s.mu.Unlock()
+}
+
+// parseMessage returns the events and the option of a message in any
+// mode.
+func parseMessage(msg interface{}) ([]Event, map[string]interface{}, error) {
+	a, ok := msg.([]interface{})
+	if !ok || len(a) < 2 {
+		return nil, nil, fmt.Errorf("fluenttest: message is not an array: %v", msg)
+	}
+	tag, ok := a[0].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("fluenttest: tag is not a string: %v", a[0])
+	}
+
+	var option map[string]interface{}
+	switch entries := a[1].(type) {
+	case []interface{}:
+		// Forward: [tag, [[time, record], ...], option?]
+		if len(a) > 2 {
+			option, _ = a[2].(map[string]interface{})
+		}
+		events := make([]Event, 0, len(entries))
+		for _, e := range entries {
+			ev, err := parseEntry(tag, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, ev)
+		}
+		return events, option, nil
+
+	case []byte, string:
+		// PackedForward: [tag, bin of concatenated entries, option?]
+		if len(a) > 2 {
+			option, _ = a[2].(map[string]interface{})
+		}
+		packed, _ := entries.([]byte)
+		if str, ok := entries.(string); ok {
+			packed = []byte(str)
+		}
+		if option["compressed"] == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(packed))
+			if err != nil {
+				return nil, nil, err
+			}
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, zr); err != nil {
+				return nil, nil, err
+			}
+			packed = buf.Bytes()
+		}
+		var events []Event
+		r := bufio.NewReader(bytes.NewReader(packed))
+		for {
+			e, err := msgpack.Decode(r)
+			if err == io.EOF {
+				return events, option, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			ev, err := parseEntry(tag, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			events = append(events, ev)
+		}
+	}
+
+	// Message: [tag, time, record, option?]
+	if len(a) < 3 {
+		return nil, nil, fmt.Errorf("fluenttest: message without a record: %v", msg)
+	}
+	if len(a) > 3 {
+		option, _ = a[3].(map[string]interface{})
+	}
+	ev, err := parseEntry(tag, []interface{}{a[1], a[2]})
+	if err != nil {
+		return nil, nil, err
+	}
+	return []Event{ev}, option, nil
+}
+
+// parseEntry parses a [time, record] entry, the time being an event time
+// or an integer of seconds.
+func parseEntry(tag string, entry interface{}) (Event, error) {
+	e, ok := entry.([]interface{})
+	if !ok || len(e) != 2 {
+		return Event{}, fmt.Errorf("fluenttest: entry is not [time, record]: %v", entry)
+	}
+	ev := Event{Tag: tag}
+	switch t := e[0].(type) {
+	case time.Time:
+		ev.Time = t
+	case int64:
+		ev.Time = time.Unix(t, 0)
+	case uint64:
+		ev.Time = time.Unix(int64(t), 0)
+	default:
+		return Event{}, fmt.Errorf("fluenttest: invalid time %v", e[0])
+	}
+	if ev.Record, ok = e[1].(map[string]interface{}); !ok {
+		return Event{}, fmt.Errorf("fluenttest: record is not a map: %v", e[1])
+	}
+	return ev, nil
+}
@@ -0,0 +1,355 @@
+package fluent
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mayadata-io/mlogger/sink/fluent/internal/msgpack"
+)
+
+// record is an encoded entry waiting for the forwarder.
+type record struct {
+	tag   string
+	entry []byte
+}
+
+// batch accumulates the entries of a tag.
+type batch struct {
+	entries []byte
+	n       int
+}
+
+// forwarder batches records and sends them to the server. Its state is
+// owned by the goroutine running run, except for dropped.
+type forwarder struct {
+	// dropped counts the records dropped since the last sync. It comes
+	// first to be 64-bit aligned for atomic operations on 32-bit
+	// platforms.
+	dropped uint64
+
+	cfg   Config
+	queue chan record
+	syncs chan syncRequest
+
+	// ctx is cancelled by close, which then waits for run to return.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	batches map[string]*batch
+	buf     buffer
+	// connMu guards conn against close, which interrupts its I/O.
+	connMu  sync.Mutex
+	conn    net.Conn
+	r       *bufio.Reader
+	backoff time.Duration
+	retryAt time.Time
+	// lastErr is the last delivery error, reported by sync while chunks
+	// remain buffered.
+	lastErr error
+}
+
+// syncRequest asks the forwarder to send what it holds until deadline.
+type syncRequest struct {
+	deadline time.Time
+	done     chan error
+}
+
+func startForwarder(cfg Config) *forwarder {
+	f := &forwarder{
+		cfg:     cfg,
+		queue:   make(chan record, cfg.QueueSize),
+		syncs:   make(chan syncRequest),
+		stopped: make(chan struct{}),
+		batches: make(map[string]*batch),
+		buf:     buffer{limit: cfg.BufferLimit},
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	go f.run()
+	return f
+}
+
+// enqueue hands a record to the forwarder, dropping it when the queue is
+// full rather than blocking the caller.
+func (f *forwarder) enqueue(tag string, entry []byte) {
+	select {
+	case f.queue <- record{tag: tag, entry: entry}:
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+	}
+}
+
+// sync sends the pending records, for up to SyncTimeout, and reports the
+// records dropped since the last sync and the chunks left in the buffer.
+func (f *forwarder) sync() error {
+	req := syncRequest{deadline: time.Now().Add(f.cfg.SyncTimeout), done: make(chan error, 1)}
+	timer := time.NewTimer(f.cfg.SyncTimeout)
+	defer timer.Stop()
+	select {
+	case f.syncs <- req:
+	case <-f.stopped:
+		return fmt.Errorf("fluent: closed")
+	case <-timer.C:
+		return fmt.Errorf("fluent: sync timed out")
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("fluent: sync timed out")
+	}
+}
+
+// close stops the forwarder, dropping the records it still holds, and
+// closes its connection.
+func (f *forwarder) close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		f.cancel()
+		f.connMu.Lock()
+		if f.conn != nil {
+			// interrupt a write or an ack wait
+			f.conn.SetDeadline(time.Now())
+		}
+		f.connMu.Unlock()
+		<-f.stopped
+		if f.conn != nil {
+			err = f.conn.Close()
+		}
+	})
+	return err
+}
+
+func (f *forwarder) run() {
+	defer close(f.stopped)
+	ticker := time.NewTicker(f.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case r := <-f.queue:
+			f.add(r)
+		case <-ticker.C:
+			f.flushAll()
+			f.retry(time.Time{})
+		case req := <-f.syncs:
+			f.drain()
+			f.flushAll()
+			f.retry(req.deadline)
+			req.done <- f.status()
+		}
+	}
+}
+
+func (f *forwarder) add(r record) {
+	b := f.batches[r.tag]
+	if b == nil {
+		b = &batch{}
+		f.batches[r.tag] = b
+	}
+	b.entries = append(b.entries, r.entry...)
+	b.n++
+	if b.n >= f.cfg.BatchSize {
+		f.flush(r.tag, b)
+	}
+}
+
+// drain adds the records queued so far.
+func (f *forwarder) drain() {
+	for {
+		select {
+		case r := <-f.queue:
+			f.add(r)
+		default:
+			return
+		}
+	}
+}
+
+func (f *forwarder) flushAll() {
+	for tag, b := range f.batches {
+		f.flush(tag, b)
+	}
+}
+
+func (f *forwarder) flush(tag string, b *batch) {
+	if b.n == 0 {
+		return
+	}
+	chunk := f.chunk(tag, b)
+	n := b.n
+	delete(f.batches, tag)
+	f.deliver(chunk, n)
+}
+
+// chunk renders a batch as a Forward mode message:
+//
+//	[tag, [[time, record], ...], {"size": n, "chunk": id}]
+//
+// where the chunk option, asking the server for an ack, comes last, so
+// that chunkID finds it in buffered chunks.
+func (f *forwarder) chunk(tag string, b *batch) []byte {
+	msg := make([]byte, 0, len(b.entries)+len(tag)+64)
+	msg = msgpack.AppendArrayHeader(msg, 3)
+	msg = msgpack.AppendString(msg, tag)
+	msg = msgpack.AppendArrayHeader(msg, b.n)
+	msg = append(msg, b.entries...)
+	if !f.cfg.RequireAck {
+		msg = msgpack.AppendMapHeader(msg, 1)
+		msg = msgpack.AppendString(msg, "size")
+		return msgpack.AppendInt(msg, int64(b.n))
+	}
+	msg = msgpack.AppendMapHeader(msg, 2)
+	msg = msgpack.AppendString(msg, "size")
+	msg = msgpack.AppendInt(msg, int64(b.n))
+	msg = msgpack.AppendString(msg, "chunk")
+	return msgpack.AppendString(msg, newChunkID())
+}
+
+// chunkIDLen is the length of a chunk ID: 16 random bytes in base64.
+const chunkIDLen = 24
+
+func newChunkID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// chunkID returns the ID a chunk ends with, if it asks for an ack.
+func chunkID(chunk []byte) (string, bool) {
+	const option = "\xa5chunk\xb8" // "chunk", then the header of a 24-byte string
+	n := len(chunk) - chunkIDLen
+	if n < len(option) || string(chunk[n-len(option):n]) != option {
+		return "", false
+	}
+	return string(chunk[n:]), true
+}
+
+// deliver sends chunk, holding n records, or buffers it while the server
+// cannot be reached or older chunks wait to be sent.
+func (f *forwarder) deliver(chunk []byte, n int) {
+	if f.buf.len() == 0 && !time.Now().Before(f.retryAt) {
+		err := f.send(chunk, time.Time{})
+		if err == nil {
+			return
+		}
+		f.fail(err)
+	}
+	if !f.buf.push(chunk) {
+		atomic.AddUint64(&f.dropped, uint64(n))
+	}
+}
+
+// retry sends the buffered chunks, oldest first, once the backoff has
+// expired. With a deadline, as for a sync, it does not wait for the
+// backoff but stops at deadline.
+func (f *forwarder) retry(deadline time.Time) {
+	for f.buf.len() > 0 {
+		if deadline.IsZero() && time.Now().Before(f.retryAt) {
+			return
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return
+		}
+		if err := f.send(f.buf.peek(), deadline); err != nil {
+			f.fail(err)
+			return
+		}
+		f.buf.pop()
+	}
+}
+
+// fail backs off after a failed delivery.
+func (f *forwarder) fail(err error) {
+	f.lastErr = err
+	if f.backoff == 0 {
+		f.backoff = f.cfg.MinBackoff
+	} else if f.backoff *= 2; f.backoff > f.cfg.MaxBackoff {
+		f.backoff = f.cfg.MaxBackoff
+	}
+	f.retryAt = time.Now().Add(f.backoff)
+}
+
+// send writes chunk to the server, connecting first if needed, and waits
+// for its ack if it asks for one, giving up at deadline when set. The
+// connection is closed on failure.
+func (f *forwarder) send(chunk []byte, deadline time.Time) error {
+	if f.conn == nil {
+		d := net.Dialer{Timeout: f.cfg.DialTimeout, Deadline: deadline}
+		conn, err := d.DialContext(f.ctx, f.cfg.Network, f.cfg.Address)
+		if err != nil {
+			return err
+		}
+		f.connMu.Lock()
+		f.conn, f.r = conn, bufio.NewReader(conn)
+		f.connMu.Unlock()
+		if f.ctx.Err() != nil {
+			// closed while dialing, after close looked at conn
+			return f.ctx.Err()
+		}
+	}
+	err := f.write(chunk, deadline)
+	if err != nil {
+		f.connMu.Lock()
+		f.conn.Close()
+		f.conn, f.r = nil, nil
+		f.connMu.Unlock()
+		return err
+	}
+	f.backoff, f.retryAt = 0, time.Time{}
+	return nil
+}
+
+// ioDeadline returns the earlier of now plus timeout and deadline, when
+// set.
+func ioDeadline(timeout time.Duration, deadline time.Time) time.Time {
+	t := time.Now().Add(timeout)
+	if !deadline.IsZero() && deadline.Before(t) {
+		return deadline
+	}
+	return t
+}
+
+func (f *forwarder) write(chunk []byte, deadline time.Time) error {
+	f.conn.SetWriteDeadline(ioDeadline(f.cfg.WriteTimeout, deadline))
+	if _, err := f.conn.Write(chunk); err != nil {
+		return err
+	}
+	id, ok := chunkID(chunk)
+	if !ok {
+		return nil
+	}
+	f.conn.SetReadDeadline(ioDeadline(f.cfg.AckTimeout, deadline))
+	resp, err := msgpack.Decode(f.r)
+	if err != nil {
+		return fmt.Errorf("no ack: %v", err)
+	}
+	if m, _ := resp.(map[string]interface{}); m["ack"] != id {
+		return fmt.Errorf("ack %v does not match chunk %s", m["ack"], id)
+	}
+	return nil
+}
+
+// status reports the records dropped since the last call and the chunks
+// left in the buffer.
+func (f *forwarder) status() error {
+	dropped := atomic.SwapUint64(&f.dropped, 0)
+	switch {
+	case dropped > 0 && f.buf.len() > 0:
+		return fmt.Errorf("fluent: %d records dropped, %d chunks buffered: %v", dropped, f.buf.len(), f.lastErr)
+	case dropped > 0:
+		return fmt.Errorf("fluent: %d records dropped", dropped)
+	case f.buf.len() > 0:
+		return fmt.Errorf("fluent: %d chunks buffered: %v", f.buf.len(), f.lastErr)
+	}
+	return nil
+}
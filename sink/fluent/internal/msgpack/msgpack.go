@@ -0,0 +1,404 @@
+// Package msgpack implements the subset of MessagePack the Fluent Forward
+// protocol needs: appending values to a buffer and decoding them back into
+// generic Go values.
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// EventTimeType is the extension type of Fluent event times: seconds and
+// nanoseconds since the epoch, as two big-endian uint32.
+const EventTimeType = 0
+
+// Ext is a decoded extension value of a type other than EventTimeType.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// AppendNil appends nil.
+func AppendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+// AppendBool appends a boolean.
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+// AppendInt appends a signed integer in its shortest form.
+func AppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return AppendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return append(b, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32:
+		b = append(b, 0xd2)
+		return appendUint32(b, uint32(v))
+	}
+	b = append(b, 0xd3)
+	return appendUint64(b, uint64(v))
+}
+
+// AppendUint appends an unsigned integer in its shortest form.
+func AppendUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return append(b, 0xcd, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		b = append(b, 0xce)
+		return appendUint32(b, uint32(v))
+	}
+	b = append(b, 0xcf)
+	return appendUint64(b, v)
+}
+
+// AppendFloat appends a 64-bit float.
+func AppendFloat(b []byte, v float64) []byte {
+	b = append(b, 0xcb)
+	return appendUint64(b, math.Float64bits(v))
+}
+
+// AppendString appends a string.
+func AppendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb)
+		b = appendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+// AppendBinary appends a byte slice as bin.
+func AppendBinary(b []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xc6)
+		b = appendUint32(b, uint32(n))
+	}
+	return append(b, v...)
+}
+
+// AppendArrayHeader appends the header of an array of n elements, which
+// the caller appends next.
+func AppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	b = append(b, 0xdd)
+	return appendUint32(b, uint32(n))
+}
+
+// AppendMapHeader appends the header of a map of n pairs, whose keys and
+// values the caller appends next.
+func AppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	b = append(b, 0xdf)
+	return appendUint32(b, uint32(n))
+}
+
+// AppendEventTime appends t as a Fluent event time.
+func AppendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, EventTimeType)
+	b = appendUint32(b, uint32(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+// Append appends v. Besides nil, booleans, numbers, strings, byte slices,
+// generic maps and slices, it renders times in RFC 3339, durations, errors
+// and fmt.Stringers as strings, and other values as they marshal to JSON.
+func Append(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return AppendNil(b)
+	case bool:
+		return AppendBool(b, v)
+	case int:
+		return AppendInt(b, int64(v))
+	case int8:
+		return AppendInt(b, int64(v))
+	case int16:
+		return AppendInt(b, int64(v))
+	case int32:
+		return AppendInt(b, int64(v))
+	case int64:
+		return AppendInt(b, v)
+	case uint:
+		return AppendUint(b, uint64(v))
+	case uint8:
+		return AppendUint(b, uint64(v))
+	case uint16:
+		return AppendUint(b, uint64(v))
+	case uint32:
+		return AppendUint(b, uint64(v))
+	case uint64:
+		return AppendUint(b, v)
+	case uintptr:
+		return AppendUint(b, uint64(v))
+	case float32:
+		return AppendFloat(b, float64(v))
+	case float64:
+		return AppendFloat(b, v)
+	case string:
+		return AppendString(b, v)
+	case []byte:
+		return AppendBinary(b, v)
+	case time.Time:
+		return AppendString(b, v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return AppendString(b, v.String())
+	case map[string]interface{}:
+		b = AppendMapHeader(b, len(v))
+		for k, e := range v {
+			b = AppendString(b, k)
+			b = Append(b, e)
+		}
+		return b
+	case []interface{}:
+		b = AppendArrayHeader(b, len(v))
+		for _, e := range v {
+			b = Append(b, e)
+		}
+		return b
+	case error:
+		return AppendString(b, v.Error())
+	case fmt.Stringer:
+		return AppendString(b, v.String())
+	}
+
+	// reflected values: keep their JSON structure
+	j, err := json.Marshal(v)
+	if err != nil {
+		return AppendString(b, fmt.Sprint(v))
+	}
+	var generic interface{}
+	if err := json.Unmarshal(j, &generic); err != nil {
+		return AppendString(b, string(j))
+	}
+	return Append(b, generic)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Decode reads one value from r. Maps decode to map[string]interface{}
+// when all their keys are strings and to map[interface{}]interface{}
+// otherwise, arrays to []interface{}, integers to int64 or uint64, str to
+// string, bin to []byte, event times to time.Time and other extensions
+// to Ext.
+func Decode(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return decodeMap(r, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return decodeArray(r, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return decodeString(r, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readLength(r, c-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readN(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readLength(r, c-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return decodeExt(r, n)
+	case 0xca:
+		v, err := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := readUint(r, 8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return readUint(r, 1<<(c-0xcc))
+	case 0xd0:
+		v, err := readUint(r, 1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := readUint(r, 2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := readUint(r, 4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := readUint(r, 8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(r, 1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readLength(r, c-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return decodeString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readLength(r, c-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, n)
+	case 0xde, 0xdf:
+		n, err := readLength(r, c-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(r, n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", c)
+}
+
+// readLength reads a length of 1, 2 or 4 bytes, for size 0, 1 or 2.
+func readLength(r *bufio.Reader, size byte) (int, error) {
+	v, err := readUint(r, 1<<size)
+	return int(v), err
+}
+
+func readUint(r *bufio.Reader, n int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func readN(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func decodeString(r *bufio.Reader, n int) (interface{}, error) {
+	b, err := readN(r, n)
+	return string(b), err
+}
+
+func decodeExt(r *bufio.Reader, n int) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readN(r, n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(t) == EventTimeType && n == 8 {
+		sec := binary.BigEndian.Uint32(data)
+		nsec := binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+	return Ext{Type: int8(t), Data: data}, nil
+}
+
+func decodeArray(r *bufio.Reader, n int) (interface{}, error) {
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func decodeMap(r *bufio.Reader, n int) (interface{}, error) {
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	strKeys := true
+	for i := 0; i < n; i++ {
+		k, err := Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.(string); !ok {
+			strKeys = false
+		}
+		keys[i], values[i] = k, v
+	}
+	if strKeys {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		switch k.(type) {
+		case []byte, []interface{}, map[string]interface{}, map[interface{}]interface{}:
+			// not hashable
+			k = fmt.Sprint(k)
+		}
+		m[k] = values[i]
+	}
+	return m, nil
+}
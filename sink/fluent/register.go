@@ -0,0 +1,77 @@
+package fluent
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("fluent", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a fluent sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, _ zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, enab)
+}
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		Network:   params["network"],
+		Address:   params["address"],
+		TagPrefix: params["tag-prefix"],
+	}
+
+	if v := params["require-ack"]; v != "" {
+		ack, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("require-ack: %v", err)
+		}
+		cfg.RequireAck = ack
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"ack-timeout":    &cfg.AckTimeout,
+		"flush-interval": &cfg.FlushInterval,
+		"min-backoff":    &cfg.MinBackoff,
+		"max-backoff":    &cfg.MaxBackoff,
+		"sync-timeout":   &cfg.SyncTimeout,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	for name, n := range map[string]*int{
+		"batch-size": &cfg.BatchSize,
+		"queue-size": &cfg.QueueSize,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	if v := params["buffer-limit"]; v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("buffer-limit: %v", err)
+		}
+		cfg.BufferLimit = limit
+	}
+	return cfg, nil
+}
@@ -0,0 +1,412 @@
+// Package batch provides the sender shared by the HTTP sinks: items are
+// queued by the logging goroutines, gathered into batches by a Batcher and
+// posted from a background goroutine, with retries, an optional circuit
+// breaker and an optional dead-letter file.
+package batch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Batcher builds the batches of a sender. Its methods are called from the
+// goroutine of the sender only.
+type Batcher interface {
+	// Add adds item to the batch being built and reports whether the
+	// batch is full.
+	Add(item interface{}) bool
+	// Flush encodes the batch being built, of n items, and starts another
+	// one. n is 0 when the batch is empty.
+	Flush() (body []byte, n int, err error)
+}
+
+// Config describes a sender.
+type Config struct {
+	// Name prefixes the errors of Sync.
+	Name string
+	// QueueSize is the number of items waiting for the sender beyond which
+	// items are dropped.
+	QueueSize int
+	// FlushInterval is how often the batch being built is sent and the
+	// pending batches are retried.
+	FlushInterval time.Duration
+	// MaxRetries is the number of times a batch is retried before it is
+	// given up.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the delay before a retry, which
+	// doubles after each failure, and is jittered by up to half with
+	// Jitter.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Jitter     bool
+	// PendingLimit is the size in bytes of the batches waiting to be
+	// retried beyond which batches are given up.
+	PendingLimit int64
+	// BreakerThreshold, when positive, is the number of consecutive failed
+	// requests that opens the breaker, for BreakerCooldown. Batches are
+	// given up while it is open.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// DeadLetterFile, when set, is the file the batches given up are
+	// appended to. They are dropped otherwise.
+	DeadLetterFile string
+	// SyncTimeout bounds Sync.
+	SyncTimeout time.Duration
+	// Post sends a batch and reports whether a failure is worth retrying.
+	Post func(ctx context.Context, body []byte) (bool, error)
+}
+
+// payload is an encoded batch waiting to be sent.
+type payload struct {
+	body     []byte
+	n        int
+	attempts int
+}
+
+// syncRequest asks the sender to send what it holds until deadline.
+type syncRequest struct {
+	deadline time.Time
+	done     chan error
+}
+
+// Sender batches items and sends them. Its state is owned by the goroutine
+// running run, except for dropped.
+type Sender struct {
+	// dropped counts the items dropped since the last sync. It comes
+	// first to be 64-bit aligned for atomic operations on 32-bit
+	// platforms.
+	dropped uint64
+
+	cfg   Config
+	b     Batcher
+	queue chan interface{}
+	syncs chan syncRequest
+
+	// ctx is cancelled by Close, aborting the request in flight.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	// pending holds the batches waiting to be sent, oldest first.
+	pending     []*payload
+	pendingSize int64
+	backoff     time.Duration
+	retryAt     time.Time
+
+	// failures counts the consecutive failed requests; the breaker is
+	// open until openUntil once they reach the threshold.
+	failures  int
+	openUntil time.Time
+
+	deadLetter *os.File
+	// deadLettered counts the items written to deadLetter since the last
+	// sync.
+	deadLettered int
+	// lastErr is the last delivery error, reported by sync.
+	lastErr error
+}
+
+// Start starts a sender of the batches of b.
+func Start(cfg Config, b Batcher) *Sender {
+	s := &Sender{
+		cfg:     cfg,
+		b:       b,
+		queue:   make(chan interface{}, cfg.QueueSize),
+		syncs:   make(chan syncRequest),
+		stopped: make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s
+}
+
+// Enqueue hands an item to the sender, dropping it when the queue is full
+// rather than blocking the caller.
+func (s *Sender) Enqueue(item interface{}) {
+	select {
+	case s.queue <- item:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Sync sends the queued items and the pending batches, without waiting for
+// the backoff to expire, for up to SyncTimeout. It reports the items
+// dropped or dead-lettered since the last sync and the batches left
+// pending.
+func (s *Sender) Sync() error {
+	deadline := time.Now().Add(s.cfg.SyncTimeout)
+	req := syncRequest{deadline: deadline, done: make(chan error, 1)}
+	timer := time.NewTimer(s.cfg.SyncTimeout)
+	defer timer.Stop()
+	select {
+	case s.syncs <- req:
+	case <-s.stopped:
+		return fmt.Errorf("%s: closed", s.cfg.Name)
+	case <-timer.C:
+		return fmt.Errorf("%s: sync timed out", s.cfg.Name)
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%s: sync timed out", s.cfg.Name)
+	}
+}
+
+// Close stops the sender, dropping the items it still holds, and closes
+// the dead-letter file. Sync first to send them.
+func (s *Sender) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		<-s.stopped
+		if s.deadLetter != nil {
+			err = s.deadLetter.Close()
+		}
+	})
+	return err
+}
+
+func (s *Sender) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case item := <-s.queue:
+			if s.b.Add(item) {
+				s.flush()
+			}
+		case <-ticker.C:
+			s.flush()
+			s.retry(time.Time{})
+		case req := <-s.syncs:
+			s.drain()
+			s.flush()
+			s.retry(req.deadline)
+			req.done <- s.status()
+		}
+	}
+}
+
+// drain adds the items queued so far.
+func (s *Sender) drain() {
+	for {
+		select {
+		case item := <-s.queue:
+			if s.b.Add(item) {
+				s.flush()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// flush sends the batch being built.
+func (s *Sender) flush() {
+	body, n, err := s.b.Flush()
+	if n == 0 {
+		return
+	}
+	if err != nil {
+		s.lastErr = err
+		atomic.AddUint64(&s.dropped, uint64(n))
+		return
+	}
+	s.deliver(&payload{body: body, n: n})
+}
+
+// open reports whether the breaker is open.
+func (s *Sender) open() bool {
+	return time.Now().Before(s.openUntil)
+}
+
+// deliver sends pl, or keeps it pending while older batches wait to be
+// sent or the backoff runs, or gives it up while the breaker is open.
+func (s *Sender) deliver(pl *payload) {
+	if s.open() {
+		s.giveUp(pl)
+		return
+	}
+	if len(s.pending) == 0 && !time.Now().Before(s.retryAt) {
+		if s.try(pl, time.Time{}) {
+			return
+		}
+	}
+	if s.pendingSize+int64(len(pl.body)) > s.cfg.PendingLimit {
+		s.giveUp(pl)
+		return
+	}
+	s.pending = append(s.pending, pl)
+	s.pendingSize += int64(len(pl.body))
+}
+
+// retry sends the pending batches, oldest first, once the backoff has
+// expired, or gives them up while the breaker is open. With a deadline,
+// as for a sync, it does not wait for the backoff but stops at deadline.
+func (s *Sender) retry(deadline time.Time) {
+	for len(s.pending) > 0 {
+		pl := s.pending[0]
+		if s.open() {
+			s.giveUp(pl)
+		} else if deadline.IsZero() && time.Now().Before(s.retryAt) {
+			return
+		} else if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return
+		} else if !s.try(pl, deadline) {
+			return
+		}
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.pendingSize -= int64(len(pl.body))
+	}
+}
+
+// try sends pl, by deadline when set, and reports whether it is done
+// with, sent or given up.
+func (s *Sender) try(pl *payload, deadline time.Time) bool {
+	ctx := s.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	retryable, err := s.cfg.Post(ctx, pl.body)
+	if err == nil {
+		s.failures, s.backoff, s.retryAt = 0, 0, time.Time{}
+		return true
+	}
+	s.lastErr = err
+	if !retryable {
+		// the endpoint is up but refuses the batch
+		s.giveUp(pl)
+		return true
+	}
+
+	s.failures++
+	if s.cfg.BreakerThreshold > 0 && s.failures >= s.cfg.BreakerThreshold {
+		// a failed probe reopens the breaker at once
+		s.openUntil = time.Now().Add(s.cfg.BreakerCooldown)
+		s.giveUp(pl)
+		return true
+	}
+	pl.attempts++
+	if pl.attempts > s.cfg.MaxRetries {
+		s.giveUp(pl)
+		return true
+	}
+	if s.backoff == 0 {
+		s.backoff = s.cfg.MinBackoff
+	} else if s.backoff *= 2; s.backoff > s.cfg.MaxBackoff {
+		s.backoff = s.cfg.MaxBackoff
+	}
+	delay := s.backoff
+	if s.cfg.Jitter {
+		delay = jitter(delay)
+	}
+	s.retryAt = time.Now().Add(delay)
+	return false
+}
+
+// jitter returns a delay between half d and d, so that clients failing
+// together do not retry together.
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// giveUp appends pl to the dead-letter file, or drops it when there is
+// none or it cannot be written.
+func (s *Sender) giveUp(pl *payload) {
+	if s.cfg.DeadLetterFile == "" {
+		atomic.AddUint64(&s.dropped, uint64(pl.n))
+		return
+	}
+	if s.deadLetter == nil {
+		f, err := os.OpenFile(s.cfg.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			s.lastErr = err
+			atomic.AddUint64(&s.dropped, uint64(pl.n))
+			return
+		}
+		s.deadLetter = f
+	}
+	if _, err := s.deadLetter.Write(pl.body); err != nil {
+		s.lastErr = err
+		atomic.AddUint64(&s.dropped, uint64(pl.n))
+		return
+	}
+	s.deadLettered += pl.n
+}
+
+// status reports the items dropped since the last call and the batches
+// left pending, with the last error, and syncs the dead-letter file.
+func (s *Sender) status() error {
+	var problems []string
+	if s.deadLetter != nil {
+		if err := s.deadLetter.Sync(); err != nil {
+			problems = append(problems, fmt.Sprintf("dead-letter file: %v", err))
+		}
+	}
+	if dropped := atomic.SwapUint64(&s.dropped, 0); dropped > 0 {
+		problems = append(problems, fmt.Sprintf("%d records dropped", dropped))
+	}
+	if s.deadLettered > 0 {
+		problems = append(problems, fmt.Sprintf("%d records dead-lettered", s.deadLettered))
+		s.deadLettered = 0
+	}
+	if len(s.pending) > 0 {
+		problems = append(problems, fmt.Sprintf("%d batches pending", len(s.pending)))
+	}
+	if s.open() {
+		problems = append(problems, "circuit open")
+	}
+	lastErr := s.lastErr
+	if len(s.pending) == 0 && !s.open() {
+		s.lastErr = nil
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	msg := s.cfg.Name + ": " + strings.Join(problems, ", ")
+	if lastErr != nil {
+		msg += ": " + lastErr.Error()
+	}
+	return errors.New(msg)
+}
+
+// Do sends req with client and reports whether a failure is worth
+// retrying: network errors, 408, 429 and 5xx are.
+func Do(client *http.Client, req *http.Request) (bool, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true, err
+	}
+	return resp.StatusCode/100 == 5, err
+}
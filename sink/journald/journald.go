@@ -0,0 +1,343 @@
+// Package journald provides a sink writing the records of the mlogger core
+// to the systemd journal through its native protocol, so that fields stay
+// individually queryable with journalctl.
+//
+// Importing the package registers the "journald" sink type, configured
+// through SinkConfig.Params:
+//
+//	socket      path of the journal socket; /run/systemd/journal/socket by default
+//	identifier  SYSLOG_IDENTIFIER; the program name by default
+//
+// When the journal socket is absent, as outside systemd services, records
+// are encoded with the encoder of the sink and written to stderr instead.
+// The socket is dialed again when journald restarts or comes up later.
+package journald
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultSocket is the path of the native journal socket.
+const DefaultSocket = "/run/systemd/journal/socket"
+
+// Config describes a journald sink.
+type Config struct {
+	// Socket is the path of the journal socket.
+	Socket string
+	// Identifier is the SYSLOG_IDENTIFIER of the records.
+	Identifier string
+	// Fallback receives the encoded records when the journal cannot be
+	// reached. It defaults to stderr.
+	Fallback zapcore.WriteSyncer
+}
+
+// New returns a core writing records at the levels enab enables to the
+// journal, or encoded with enc to cfg.Fallback when the journal socket
+// cannot be reached. The core implements io.Closer.
+//
+// Fields become journal fields named after their key in upper case, with
+// characters journald does not accept replaced by underscores. The level
+// is sent as PRIORITY, the ecode as ECODE and, hashed into a 128-bit ID,
+// as MESSAGE_ID, and the caller as CODE_FILE and CODE_LINE. Fields whose
+// name journald or the sink gives a meaning to, such as MESSAGE or
+// PRIORITY, are prefixed with FIELD_.
+func New(cfg Config, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.Socket == "" {
+		cfg.Socket = DefaultSocket
+	}
+	if cfg.Identifier == "" {
+		cfg.Identifier = filepath.Base(os.Args[0])
+	}
+	if cfg.Fallback == nil {
+		cfg.Fallback = zapcore.Lock(os.Stderr)
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("journald: no encoder for the fallback")
+	}
+
+	j := &journal{socket: cfg.Socket}
+	j.dial()
+	return &core{
+		LevelEnabler: enab,
+		identifier:   cfg.Identifier,
+		journal:      j,
+		fallback:     zapcore.NewCore(enc, cfg.Fallback, enab),
+	}, nil
+}
+
+// redialInterval is how long the journal is left alone after dialing it
+// failed, so that records written without journald do not all pay for
+// an attempt.
+var redialInterval = time.Second
+
+var (
+	errUnreachable = errors.New("journald: journal unreachable")
+	errClosed      = errors.New("journald: closed")
+)
+
+// journal is the connection to the journal socket shared by a core and
+// those derived from it.
+type journal struct {
+	socket string
+
+	mu sync.Mutex
+	// conn is nil when the journal could not be reached.
+	conn *net.UnixConn
+	// retry is when the journal is dialed again after that failed.
+	retry  time.Time
+	closed bool
+}
+
+func (j *journal) dial() error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.socket, Net: "unixgram"})
+	if err != nil {
+		j.retry = time.Now().Add(redialInterval)
+		return err
+	}
+	j.conn = conn
+	return nil
+}
+
+// send writes msg to the journal. When journald restarted, which refuses
+// the datagrams of the previous connection, or went away, the socket is
+// dialed again and msg resent once.
+func (j *journal) send(msg []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return errClosed
+	}
+	if j.conn == nil {
+		if time.Now().Before(j.retry) {
+			return errUnreachable
+		}
+		if err := j.dial(); err != nil {
+			return err
+		}
+	}
+	err := send(j.conn, msg)
+	if !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.ENOENT) {
+		return err
+	}
+	j.conn.Close()
+	j.conn = nil
+	if err := j.dial(); err != nil {
+		return err
+	}
+	return send(j.conn, msg)
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	if j.conn == nil {
+		return nil
+	}
+	err := j.conn.Close()
+	j.conn = nil
+	return err
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	identifier string
+	journal    *journal
+	fallback   zapcore.Core
+	fields     []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	clone.fallback = c.fallback.With(fields)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if err := c.journal.send(c.encode(ent, fields)); err != nil {
+		// the journal cannot be reached; keep the record
+		return c.fallback.Write(ent, fields)
+	}
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.fallback.Sync()
+}
+
+// Close closes the journal socket. Records written afterwards go to the
+// fallback.
+func (c *core) Close() error {
+	return c.journal.close()
+}
+
+// encode renders a record in the native journal format.
+func (c *core) encode(ent zapcore.Entry, fields []zapcore.Field) []byte {
+	var b bytes.Buffer
+	writeField(&b, "MESSAGE", ent.Message)
+	writeField(&b, "PRIORITY", strconv.Itoa(priority(ent.Level)))
+	writeField(&b, "SYSLOG_IDENTIFIER", c.identifier)
+
+	values := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(values)
+	}
+	for _, f := range fields {
+		f.AddTo(values)
+	}
+
+	ecode, _ := values.Fields[common.ECodeKey].(string)
+	delete(values.Fields, common.ECodeKey)
+	if ecode == "" && ent.Caller.Defined {
+		ecode = common.PackagePath(ent.Caller, 3)
+	}
+	if ecode != "" {
+		writeField(&b, "ECODE", ecode)
+		writeField(&b, "MESSAGE_ID", messageID(ecode))
+	}
+	if ent.Caller.Defined {
+		writeField(&b, "CODE_FILE", ent.Caller.File)
+		writeField(&b, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+	}
+	if ent.LoggerName != "" {
+		writeField(&b, "LOGGER", ent.LoggerName)
+	}
+	if ent.Stack != "" {
+		writeField(&b, "STACKTRACE", ent.Stack)
+	}
+
+	keys := make([]string, 0, len(values.Fields))
+	for k := range values.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := fieldName(k)
+		if reservedFields[name] {
+			name = "FIELD_" + name
+		}
+		writeField(&b, name, fieldValue(values.Fields[k]))
+	}
+	return b.Bytes()
+}
+
+// priority maps a zap level onto a syslog priority. Levels above ErrorLevel,
+// fatal ones included, are critical: emergency and alert are for
+// conditions of the whole system, not of one process. Levels below
+// DebugLevel are debug.
+func priority(level zapcore.Level) int {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return 7
+	case level == zapcore.InfoLevel:
+		return 6
+	case level == zapcore.WarnLevel:
+		return 4
+	case level == zapcore.ErrorLevel:
+		return 3
+	}
+	return 2
+}
+
+// messageID derives a journal MESSAGE_ID, 128 bits in hex, from an ecode.
+func messageID(ecode string) string {
+	sum := md5.Sum([]byte(ecode))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeField appends a field to a journal message. Values spanning lines
+// use the binary form: the name, a newline, the length of the value as a
+// little-endian uint64 and the value.
+func writeField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.Write(size[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// reservedFields are the journal fields the sink sets and those journald
+// gives a meaning to (systemd.journal-fields(7)), which fields must not
+// override.
+var reservedFields = map[string]bool{
+	"MESSAGE": true, "MESSAGE_ID": true, "PRIORITY": true,
+	"CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true,
+	"ERRNO": true, "INVOCATION_ID": true, "USER_INVOCATION_ID": true,
+	"SYSLOG_FACILITY": true, "SYSLOG_IDENTIFIER": true, "SYSLOG_PID": true,
+	"SYSLOG_TIMESTAMP": true, "SYSLOG_RAW": true, "DOCUMENTATION": true,
+	"TID": true, "UNIT": true, "USER_UNIT": true, "OBJECT_PID": true,
+	"ECODE": true, "LOGGER": true, "STACKTRACE": true,
+}
+
+// fieldName turns a key into a journal field name: at most 64 upper case
+// letters, digits and underscores, starting with a letter, since names
+// starting with an underscore are reserved to journald.
+func fieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "FIELD_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// fieldValue renders a field value: strings as they are, nested objects
+// and arrays as JSON.
+func fieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		if j, err := json.Marshal(v); err == nil {
+			return string(j)
+		}
+	}
+	return fmt.Sprint(v)
+}
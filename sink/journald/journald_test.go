@@ -0,0 +1,208 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// listen serves a journal socket at path.
+func listen(t *testing.T, path string) *net.UnixConn {
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// receive reads a message from the journal socket and parses its fields.
+func receive(t *testing.T, l *net.UnixConn) map[string]string {
+	buf := make([]byte, 1<<16)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]string)
+	for msg := buf[:n]; len(msg) > 0; {
+		i := bytes.IndexAny(msg, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated field %q", msg)
+		}
+		name := string(msg[:i])
+		if msg[i] == '=' {
+			end := bytes.IndexByte(msg, '\n')
+			fields[name] = string(msg[i+1 : end])
+			msg = msg[end+1:]
+			continue
+		}
+		size := int(binary.LittleEndian.Uint64(msg[i+1 : i+9]))
+		fields[name] = string(msg[i+9 : i+9+size])
+		msg = msg[i+9+size+1:]
+	}
+	return fields
+}
+
+func newTestCore(t *testing.T, socket string, fallback *bytes.Buffer) zapcore.Core {
+	enc := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(Config{Socket: socket, Identifier: "test", Fallback: zapcore.AddSync(fallback)}, enc, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFields(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	l := listen(t, socket)
+	defer l.Close()
+
+	var fallback bytes.Buffer
+	c := newTestCore(t, socket, &fallback)
+	defer c.(*core).Close()
+	zap.New(c).Named("pool").Warn("degraded",
+		zap.String("ecode", "POOL001"),
+		zap.String("pool-name", "a"),
+		zap.String("message", "user message"),
+		zap.Int("priority", 1),
+		zap.String("trace", "line 1\nline 2"),
+	)
+
+	got := receive(t, l)
+	want := map[string]string{
+		"MESSAGE":           "degraded",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "test",
+		"ECODE":             "POOL001",
+		"MESSAGE_ID":        messageID("POOL001"),
+		"LOGGER":            "pool",
+		"POOL_NAME":         "a",
+		"FIELD_MESSAGE":     "user message",
+		"FIELD_PRIORITY":    "1",
+		"TRACE":             "line 1\nline 2",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got fields %q, want %q", got, want)
+	}
+	if fallback.Len() != 0 {
+		t.Errorf("fallback written: %q", fallback.String())
+	}
+}
+
+func TestRedial(t *testing.T) {
+	defer func(d time.Duration) { redialInterval = d }(redialInterval)
+	redialInterval = 0
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	l := listen(t, socket)
+
+	var fallback bytes.Buffer
+	c := newTestCore(t, socket, &fallback)
+	defer c.(*core).Close()
+	logger := zap.New(c)
+	logger.Info("first")
+	if got := receive(t, l); got["MESSAGE"] != "first" {
+		t.Fatalf("got %q", got)
+	}
+
+	// journald stops, and the records go to the fallback
+	l.Close()
+	os.Remove(socket)
+	logger.Info("while stopped")
+	if !strings.Contains(fallback.String(), "while stopped") {
+		t.Fatalf("fallback got %q", fallback.String())
+	}
+
+	// and restarts on a new socket
+	l = listen(t, socket)
+	defer l.Close()
+	logger.Info("restarted")
+	if got := receive(t, l); got["MESSAGE"] != "restarted" {
+		t.Fatalf("got %q", got)
+	}
+	if strings.Contains(fallback.String(), "restarted") {
+		t.Errorf("fallback got %q", fallback.String())
+	}
+}
+
+func TestNoJournal(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+
+	var fallback bytes.Buffer
+	c := newTestCore(t, socket, &fallback)
+	defer c.(*core).Close()
+	zap.New(c).Info("no journal", zap.String("pool", "a"))
+	if got := fallback.String(); !strings.Contains(got, "no journal") || !strings.Contains(got, `"pool": "a"`) {
+		t.Errorf("fallback got %q", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+	l := listen(t, socket)
+	defer l.Close()
+
+	var fallback bytes.Buffer
+	c := newTestCore(t, socket, &fallback)
+	if err := c.(*core).Close(); err != nil {
+		t.Fatal(err)
+	}
+	zap.New(c).Info("after close")
+	if !strings.Contains(fallback.String(), "after close") {
+		t.Errorf("fallback got %q", fallback.String())
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	for level, want := range map[zapcore.Level]int{
+		zapcore.DebugLevel - 1: 7,
+		zapcore.DebugLevel:     7,
+		zapcore.InfoLevel:      6,
+		zapcore.WarnLevel:      4,
+		zapcore.ErrorLevel:     3,
+		zapcore.DPanicLevel:    2,
+		zapcore.PanicLevel:     2,
+		zapcore.FatalLevel:     2,
+		zapcore.FatalLevel + 1: 2,
+	} {
+		if got := priority(level); got != want {
+			t.Errorf("priority(%v) = %d, want %d", level, got, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package journald
+
+import (
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("journald", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a journald sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	return New(Config{
+		Socket:     sc.Params["socket"],
+		Identifier: sc.Params["identifier"],
+	}, enc, enab)
+}
@@ -0,0 +1,51 @@
+package journald
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+)
+
+// send writes msg to the journal. Messages too large for a datagram are
+// written to an unlinked temporary file whose descriptor is passed
+// instead, as sd_journal_send does.
+func send(conn *net.UnixConn, msg []byte) error {
+	_, err := conn.Write(msg)
+	if err == nil || !isTooLarge(err) {
+		return err
+	}
+
+	f, err := ioutil.TempFile("/dev/shm", "mlogger-journal-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(msg); err != nil {
+		return err
+	}
+	// WriteMsgUnix refuses connected sockets, so the descriptor is sent
+	// with sendmsg on the socket itself.
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(f.Fd()))
+	var serr error
+	err = raw.Write(func(fd uintptr) bool {
+		serr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return serr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func isTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
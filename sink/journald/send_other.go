@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package journald
+
+import "net"
+
+// send writes msg to the journal. Only Linux can pass the descriptors
+// journald needs for messages too large for a datagram.
+func send(conn *net.UnixConn, msg []byte) error {
+	_, err := conn.Write(msg)
+	return err
+}
@@ -0,0 +1,516 @@
+// Package kafka provides a sink producing the records of the mlogger core
+// to a Kafka topic, for centralized log pipelines.
+//
+// Records are encoded with the encoder of the sink, JSON by default, and
+// produced asynchronously: the producer batches them by frequency, count
+// and size, and reports each delivery to Config.OnDelivery. Records the
+// broker did not acknowledge, or that did not fit in the queue of the
+// producer, are counted by Dropped. They can be keyed by a field, such as
+// the UID of a pool or the ecode, so that related records land in the same
+// partition and stay in order.
+//
+// The producer connects to the brokers in the background, retrying with
+// backoff, so that a Kafka outage does not keep the logger from being
+// configured; records wait in the queue meanwhile. Sync, which glog.Flush,
+// klog.Flush and Logger.Sync reach, waits for the records produced so far
+// to be acknowledged, so that flushing before exit loses none. Close, which
+// Configure calls on the sinks it replaces, flushes the producer and shuts
+// it down.
+//
+// Importing the package registers the "kafka" sink type, configured through
+// SinkConfig.Params:
+//
+//	brokers         comma separated broker addresses
+//	topic           topic records are produced to
+//	key-field       field whose value keys the records; ecode for the ecode
+//	required-acks   none, local (default) or all
+//	compression     none (default), gzip, snappy, lz4 or zstd
+//	flush-frequency, flush-messages, flush-bytes  bounds of a batch;
+//	                500ms, 1000 records and 1MiB by default
+//	max-retries     retries of a failed batch; 3 by default
+//	queue-size      records waiting for the producer; 8192 by default
+//	flush-timeout   how long Sync and Close wait for acks; 10s by default
+//	client-id       client ID; mlogger by default
+//	version         Kafka version of the brokers, as 2.1.0
+//	tls             enable TLS; tls-ca-file, tls-server-name and
+//	                tls-insecure-skip-verify configure it
+//	sasl-user, sasl-password  SASL/PLAIN authentication
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config describes a Kafka sink.
+type Config struct {
+	// Brokers lists the addresses of the brokers to bootstrap from.
+	Brokers []string
+	// Topic is the topic records are produced to.
+	Topic string
+	// KeyField is the key of the field whose value keys the records.
+	// common.ECodeKey keys them by ecode, the caller when they have none.
+	// Records without the field are not keyed and spread over partitions.
+	KeyField string
+	// RequiredAcks is the acknowledgement the producer waits for. When
+	// zero, that of Sarama applies, sarama.WaitForLocal by default; use
+	// Sarama to select sarama.NoResponse.
+	RequiredAcks sarama.RequiredAcks
+	Compression  sarama.CompressionCodec
+	// FlushFrequency, FlushMessages and FlushBytes bound a batch in
+	// time, records and bytes.
+	FlushFrequency time.Duration
+	FlushMessages  int
+	FlushBytes     int
+	// MaxRetries is the number of times a failed batch is retried.
+	MaxRetries int
+	// QueueSize is the number of records waiting for the producer beyond
+	// which records are dropped.
+	QueueSize int
+	// FlushTimeout bounds how long Sync and Close wait for
+	// acknowledgements.
+	FlushTimeout time.Duration
+	ClientID     string
+	// Version is the Kafka version of the brokers; the default of sarama
+	// when zero.
+	Version sarama.KafkaVersion
+	TLS     *tls.Config
+	// SASLUser and SASLPassword enable SASL/PLAIN authentication.
+	SASLUser     string
+	SASLPassword string
+	// OnDelivery, when set, is called with each record once the broker
+	// acknowledged it, with a nil error, or once it was given up. It is
+	// called from the goroutines of the producer, or from the logging
+	// goroutine with ErrQueueFull, and must not block.
+	OnDelivery func(msg *sarama.ProducerMessage, err error)
+	// Sarama, when set, replaces the defaults of the sink as the base
+	// configuration of the producer, for settings Config does not cover.
+	// The fields above override it when set.
+	Sarama *sarama.Config
+}
+
+// ErrQueueFull is reported to Config.OnDelivery for the records dropped
+// because the queue of the producer was full.
+var ErrQueueFull = errors.New("kafka: queue full")
+
+// dropped counts the records dropped by all Kafka sinks.
+var dropped uint64
+
+// Dropped returns the number of records the Kafka sinks dropped, because
+// their queue was full or the broker did not acknowledge them.
+func Dropped() uint64 {
+	return atomic.LoadUint64(&dropped)
+}
+
+// ErrClosed is reported to Config.OnDelivery for the records written after
+// the sink was closed, or still queued when it was closed before it could
+// connect to the brokers.
+var ErrClosed = errors.New("kafka: closed")
+
+// Bounds of the delay between attempts to connect to the brokers.
+const (
+	minConnectBackoff = 100 * time.Millisecond
+	maxConnectBackoff = 30 * time.Second
+)
+
+// New returns a core producing records at the levels enab enables to the
+// topic described by cfg, encoded with enc. It validates cfg but connects
+// to the brokers in the background. The core implements io.Closer.
+func New(cfg Config, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: no brokers")
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka: no topic")
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("kafka: no encoder")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.FlushTimeout == 0 {
+		cfg.FlushTimeout = 10 * time.Second
+	}
+
+	sc := saramaConfig(cfg)
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("kafka: %v", err)
+	}
+	p := &producer{
+		cfg:     cfg,
+		sc:      sc,
+		queue:   make(chan *sarama.ProducerMessage, cfg.QueueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.forward()
+	return &core{LevelEnabler: enab, enc: enc, prod: p, topic: cfg.Topic, keyField: cfg.KeyField}, nil
+}
+
+// saramaConfig builds the configuration of the producer: cfg.Sarama, or
+// the defaults of the sink, overridden by the fields of cfg that are set.
+func saramaConfig(cfg Config) *sarama.Config {
+	sc := cfg.Sarama
+	if sc == nil {
+		sc = defaultSarama()
+	}
+	if cfg.ClientID != "" {
+		sc.ClientID = cfg.ClientID
+	}
+	if cfg.Version != (sarama.KafkaVersion{}) {
+		sc.Version = cfg.Version
+	}
+	if cfg.RequiredAcks != 0 {
+		sc.Producer.RequiredAcks = cfg.RequiredAcks
+	}
+	if cfg.Compression != 0 {
+		sc.Producer.Compression = cfg.Compression
+	}
+	if cfg.FlushFrequency != 0 {
+		sc.Producer.Flush.Frequency = cfg.FlushFrequency
+	}
+	if cfg.FlushMessages != 0 {
+		sc.Producer.Flush.Messages = cfg.FlushMessages
+	}
+	if cfg.FlushBytes != 0 {
+		sc.Producer.Flush.Bytes = cfg.FlushBytes
+	}
+	if cfg.MaxRetries != 0 {
+		sc.Producer.Retry.Max = cfg.MaxRetries
+	}
+	if cfg.TLS != nil {
+		sc.Net.TLS.Enable = true
+		sc.Net.TLS.Config = cfg.TLS
+	}
+	if cfg.SASLUser != "" {
+		sc.Net.SASL.Enable = true
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		sc.Net.SASL.User = cfg.SASLUser
+		sc.Net.SASL.Password = cfg.SASLPassword
+	}
+	// acknowledgements feed OnDelivery, Dropped and Sync
+	sc.Producer.Return.Successes = true
+	sc.Producer.Return.Errors = true
+	return sc
+}
+
+// defaultSarama returns the configuration of the producer when
+// Config.Sarama is not set.
+func defaultSarama() *sarama.Config {
+	sc := sarama.NewConfig()
+	sc.ClientID = "mlogger"
+	sc.Producer.Flush.Frequency = 500 * time.Millisecond
+	sc.Producer.Flush.Messages = 1000
+	sc.Producer.Flush.Bytes = 1 << 20
+	return sc
+}
+
+// producer tracks the records in flight in an AsyncProducer.
+type producer struct {
+	cfg Config
+	sc  *sarama.Config
+	// queue holds the records waiting for the AsyncProducer, whose input
+	// is unbuffered, and for the connection to the brokers.
+	queue chan *sarama.ProducerMessage
+
+	// stop is closed by close; forward closes stopped once the
+	// AsyncProducer is shut down and its results are handled.
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	mu       sync.Mutex
+	inflight int
+	// idle is closed when inflight drops to zero.
+	idle chan struct{}
+	// dropped counts the records dropped since the last sync.
+	dropped int
+	lastErr error
+}
+
+// send hands msg to the producer, dropping it when the queue is full
+// rather than blocking the caller.
+func (p *producer) send(msg *sarama.ProducerMessage) {
+	select {
+	case <-p.stop:
+		p.mu.Lock()
+		p.inflight++
+		p.mu.Unlock()
+		p.done(msg, ErrClosed)
+		return
+	default:
+	}
+	p.mu.Lock()
+	p.inflight++
+	p.mu.Unlock()
+	select {
+	case p.queue <- msg:
+	default:
+		p.done(msg, ErrQueueFull)
+	}
+}
+
+// forward connects to the brokers and hands them the queued records until
+// the producer is closed.
+func (p *producer) forward() {
+	defer close(p.stopped)
+	ap := p.connect()
+	if ap == nil {
+		p.discard()
+		return
+	}
+
+	var handlers sync.WaitGroup
+	handlers.Add(2)
+	go func() {
+		defer handlers.Done()
+		for msg := range ap.Successes() {
+			p.done(msg, nil)
+		}
+	}()
+	go func() {
+		defer handlers.Done()
+		for perr := range ap.Errors() {
+			p.done(perr.Msg, perr.Err)
+		}
+	}()
+
+	for stopping := false; ; {
+		var msg *sarama.ProducerMessage
+		if stopping {
+			select {
+			case msg = <-p.queue:
+			default:
+				// the queued records are in; flush them and shut down
+				ap.AsyncClose()
+				handlers.Wait()
+				return
+			}
+		} else {
+			select {
+			case msg = <-p.queue:
+			case <-p.stop:
+				stopping = true
+				continue
+			}
+		}
+		ap.Input() <- msg
+	}
+}
+
+// connect creates the AsyncProducer, retrying with backoff until it
+// succeeds or the producer is closed, in which case it returns nil.
+func (p *producer) connect() sarama.AsyncProducer {
+	backoff := minConnectBackoff
+	for {
+		ap, err := sarama.NewAsyncProducer(p.cfg.Brokers, p.sc)
+		if err == nil {
+			return ap
+		}
+		p.mu.Lock()
+		p.lastErr = err
+		p.mu.Unlock()
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-p.stop:
+			timer.Stop()
+			return nil
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// discard gives up the queued records of a producer closed before it
+// could connect.
+func (p *producer) discard() {
+	for {
+		select {
+		case msg := <-p.queue:
+			p.done(msg, ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+// close flushes the records queued and in flight and shuts the producer
+// down, waiting for up to FlushTimeout. Records written afterwards are
+// dropped.
+func (p *producer) close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		timer := time.NewTimer(p.cfg.FlushTimeout)
+		defer timer.Stop()
+		select {
+		case <-p.stopped:
+			// records sent while forward was stopping
+			p.discard()
+			p.closeErr = p.flush()
+		case <-timer.C:
+			p.closeErr = fmt.Errorf("kafka: close timed out after %v", p.cfg.FlushTimeout)
+		}
+	})
+	return p.closeErr
+}
+
+// done accounts for a record leaving the producer, delivered when err is
+// nil, and reports it to OnDelivery.
+func (p *producer) done(msg *sarama.ProducerMessage, err error) {
+	if p.cfg.OnDelivery != nil {
+		p.cfg.OnDelivery(msg, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		atomic.AddUint64(&dropped, 1)
+		p.dropped++
+		p.lastErr = err
+	}
+	p.inflight--
+	if p.inflight == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+}
+
+// flush waits for the records in flight to be acknowledged, for up to
+// FlushTimeout, and reports those dropped since the last flush.
+func (p *producer) flush() error {
+	p.mu.Lock()
+	var idle chan struct{}
+	if p.inflight > 0 {
+		if p.idle == nil {
+			p.idle = make(chan struct{})
+		}
+		idle = p.idle
+	}
+	p.mu.Unlock()
+
+	var timedOut bool
+	if idle != nil {
+		timer := time.NewTimer(p.cfg.FlushTimeout)
+		select {
+		case <-idle:
+		case <-timer.C:
+			timedOut = true
+		}
+		timer.Stop()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, lastErr, inflight := p.dropped, p.lastErr, p.inflight
+	p.dropped, p.lastErr = 0, nil
+	switch {
+	case timedOut && n > 0:
+		return fmt.Errorf("kafka: %d records dropped, %d still in flight: %v", n, inflight, lastErr)
+	case timedOut:
+		return fmt.Errorf("kafka: %d records still in flight after %v", inflight, p.cfg.FlushTimeout)
+	case n > 0:
+		return fmt.Errorf("kafka: %d records dropped: %v", n, lastErr)
+	}
+	return nil
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	enc      zapcore.Encoder
+	prod     *producer
+	topic    string
+	keyField string
+	// key is the value of the key field among the fields added with With.
+	key string
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	if k, ok := c.keyOf(fields); ok {
+		clone.key = k
+	}
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, buf.Len())
+	copy(value, buf.Bytes())
+	buf.Free()
+	if n := len(value); n > 0 && value[n-1] == '\n' {
+		value = value[:n-1]
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     c.topic,
+		Value:     sarama.ByteEncoder(value),
+		Timestamp: ent.Time,
+	}
+	key, ok := c.keyOf(fields)
+	if !ok {
+		key, ok = c.key, c.key != ""
+	}
+	if !ok && c.keyField == common.ECodeKey && ent.Caller.Defined {
+		key, ok = common.PackagePath(ent.Caller, 3), true
+	}
+	if ok {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	c.prod.send(msg)
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.prod.flush()
+}
+
+func (c *core) Close() error {
+	return c.prod.close()
+}
+
+// keyOf returns the value of the key field among fields.
+func (c *core) keyOf(fields []zapcore.Field) (string, bool) {
+	if c.keyField == "" {
+		return "", false
+	}
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Key != c.keyField {
+			continue
+		}
+		if f.Type == zapcore.StringType {
+			return f.String, true
+		}
+		m := zapcore.NewMapObjectEncoder()
+		f.AddTo(m)
+		if v, ok := m.Fields[f.Key]; ok {
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const testTopic = "logs"
+
+// newTestBroker starts a broker leading the partition of testTopic and
+// acknowledging the records produced to it.
+func newTestBroker(t *testing.T, addr string) *sarama.MockBroker {
+	var b *sarama.MockBroker
+	if addr == "" {
+		b = sarama.NewMockBroker(t, 1)
+	} else {
+		b = sarama.NewMockBrokerAddr(t, 1, addr)
+	}
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(testTopic, 0, b.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetError(testTopic, 0, sarama.ErrNoError),
+	})
+	return b
+}
+
+// deliveries records the records reported to OnDelivery.
+type deliveries struct {
+	mu   sync.Mutex
+	keys []string
+	errs []error
+}
+
+func (d *deliveries) add(msg *sarama.ProducerMessage, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var key string
+	if msg.Key != nil {
+		b, _ := msg.Key.Encode()
+		key = string(b)
+	}
+	d.keys = append(d.keys, key)
+	d.errs = append(d.errs, err)
+}
+
+func (d *deliveries) get() ([]string, []error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.keys...), append([]error(nil), d.errs...)
+}
+
+func newTestCore(t *testing.T, addr string, d *deliveries) zapcore.Core {
+	cfg := Config{
+		Brokers:        []string{addr},
+		Topic:          testTopic,
+		KeyField:       "pool",
+		FlushFrequency: time.Millisecond,
+		FlushTimeout:   5 * time.Second,
+		OnDelivery:     d.add,
+	}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(cfg, enc, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDeliver(t *testing.T) {
+	b := newTestBroker(t, "")
+	defer b.Close()
+	var d deliveries
+	c := newTestCore(t, b.Addr(), &d)
+	defer c.(*core).Close()
+
+	logger := zap.New(c)
+	logger.Info("created", zap.String("pool", "pool-a"))
+	logger.With(zap.String("pool", "pool-b")).Info("imported")
+	logger.Info("unkeyed")
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, errs := d.get()
+	want := []string{"pool-a", "pool-b", ""}
+	if len(keys) != len(want) {
+		t.Fatalf("delivered %q, want %q", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] || errs[i] != nil {
+			t.Errorf("delivery %d: %q, %v, want %q", i, keys[i], errs[i], want[i])
+		}
+	}
+}
+
+func TestConnectLazily(t *testing.T) {
+	// reserve an address no broker listens on yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var d deliveries
+	c := newTestCore(t, addr, &d)
+	defer c.(*core).Close()
+	zap.New(c).Info("before the broker", zap.String("pool", "pool-a"))
+
+	time.Sleep(200 * time.Millisecond)
+	b := newTestBroker(t, addr)
+	defer b.Close()
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, errs := d.get(); len(keys) != 1 || errs[0] != nil {
+		t.Errorf("delivered %q, %v", keys, errs)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := newTestBroker(t, "")
+	defer b.Close()
+	var d deliveries
+	c := newTestCore(t, b.Addr(), &d)
+	logger := zap.New(c)
+	for i := 0; i < 10; i++ {
+		logger.Info("queued")
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Fatal(err)
+	}
+	keys, errs := d.get()
+	if len(keys) != 10 {
+		t.Fatalf("%d records delivered before Close returned, want 10", len(keys))
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger.Info("after close")
+	if _, errs = d.get(); len(errs) != 11 || errs[10] != ErrClosed {
+		t.Errorf("write after Close reported %v, want %v", errs[10:], ErrClosed)
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestCloseUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var d deliveries
+	c := newTestCore(t, addr, &d)
+	zap.New(c).Info("never delivered")
+	c.(*core).Close()
+	if _, errs := d.get(); len(errs) != 1 || errs[0] != ErrClosed {
+		t.Errorf("queued record reported %v, want %v", errs, ErrClosed)
+	}
+}
@@ -0,0 +1,142 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("kafka", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a Kafka sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, enc, enab)
+}
+
+var compressions = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		Topic:        params["topic"],
+		KeyField:     params["key-field"],
+		ClientID:     params["client-id"],
+		SASLUser:     params["sasl-user"],
+		SASLPassword: params["sasl-password"],
+	}
+	for _, b := range strings.Split(params["brokers"], ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			cfg.Brokers = append(cfg.Brokers, b)
+		}
+	}
+
+	switch params["required-acks"] {
+	case "", "local":
+	case "none":
+		// NoResponse is zero, which selects the default in Config
+		cfg.Sarama = defaultSarama()
+		cfg.Sarama.Producer.RequiredAcks = sarama.NoResponse
+	case "all":
+		cfg.RequiredAcks = sarama.WaitForAll
+	default:
+		return cfg, fmt.Errorf("unknown required-acks %q", params["required-acks"])
+	}
+
+	if name := params["compression"]; name != "" {
+		c, ok := compressions[name]
+		if !ok {
+			return cfg, fmt.Errorf("unknown compression %q", name)
+		}
+		cfg.Compression = c
+	}
+
+	for name, n := range map[string]*int{
+		"flush-messages": &cfg.FlushMessages,
+		"flush-bytes":    &cfg.FlushBytes,
+		"max-retries":    &cfg.MaxRetries,
+		"queue-size":     &cfg.QueueSize,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"flush-frequency": &cfg.FlushFrequency,
+		"flush-timeout":   &cfg.FlushTimeout,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	if v := params["version"]; v != "" {
+		version, err := sarama.ParseKafkaVersion(v)
+		if err != nil {
+			return cfg, fmt.Errorf("version: %v", err)
+		}
+		cfg.Version = version
+	}
+
+	if v := params["tls"]; v != "" {
+		enable, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("tls: %v", err)
+		}
+		if enable {
+			if cfg.TLS, err = parseTLS(params); err != nil {
+				return cfg, err
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func parseTLS(params map[string]string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: params["tls-server-name"]}
+	if v := params["tls-insecure-skip-verify"]; v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("tls-insecure-skip-verify: %v", err)
+		}
+		c.InsecureSkipVerify = skip
+	}
+	if file := params["tls-ca-file"]; file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls-ca-file: no certificates in %s", file)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,255 @@
+// Package kevent provides a sink turning selected records of the mlogger
+// core into Kubernetes events, for users who follow the objects they
+// manage with kubectl describe rather than the logs of the pods managing
+// them.
+//
+// A record becomes an event when its ecode is registered with
+// common.RegisterECode as UserVisible and it refers to an object through
+// common.KObjField or common.WithObject. The event is attached to that
+// object, with the reason of the ecode, the message of the record, and
+// the Warning type at WarnLevel and above, Normal below. Events are
+// emitted asynchronously through the event broadcaster of client-go,
+// which aggregates similar events and rate limits them per object.
+//
+// Sync, which glog.Flush, klog.Flush and Logger.Sync reach, waits for the
+// events emitted so far to be created, for up to SyncTimeout. Close, which
+// Configure calls on the sinks it replaces, syncs and shuts the
+// broadcaster down.
+//
+// Tests can pass the fake clientset of client-go as Config.Client and list
+// the events it received.
+//
+// Importing the package registers the "kevent" sink type, configured
+// through SinkConfig.Params:
+//
+//	kubeconfig    kubeconfig file; the in-cluster configuration by default
+//	component     source component of the events; the program by default
+//	host          source host of the events
+//	burst, qps    rate limit of the events of an object; 25 and 1/300 by
+//	              default
+//	max-events, max-interval  similar events aggregated into one; 10
+//	              within 10m by default
+//	sync-timeout  how long Sync waits for the events; 5s by default
+package kevent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Config describes a Kubernetes event sink.
+type Config struct {
+	// Client creates the events.
+	Client kubernetes.Interface
+	// Component and Host are the source of the events. Component is the
+	// name of the program by default.
+	Component string
+	Host      string
+	// Correlator sets how similar events are aggregated and rate limited;
+	// the defaults of client-go apply to the fields left zero.
+	Correlator record.CorrelatorOptions
+	// SyncTimeout bounds how long Sync waits for the events to be
+	// created; 5s when zero.
+	SyncTimeout time.Duration
+}
+
+// syncKind is the kind of the objects of the markers Sync emits. The
+// broadcaster hands events to the sink one at a time and in order, so the
+// sink reaching a marker means the events emitted before it are settled.
+const syncKind = "mlogger.sync"
+
+// New returns a core emitting the records at the levels enab enables that
+// carry a user-visible ecode and an object as events through cfg.Client.
+// The records are emitted as they are written, and dropped when the queue
+// of the broadcaster is full. The core implements io.Closer.
+func New(cfg Config, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("kevent: no client")
+	}
+	if cfg.Component == "" {
+		cfg.Component = filepath.Base(os.Args[0])
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = 5 * time.Second
+	}
+	sink := &eventSink{
+		EventSink: &typedcorev1.EventSinkImpl{Interface: cfg.Client.CoreV1().Events("")},
+		markers:   make(map[string]chan struct{}),
+	}
+	b := record.NewBroadcaster(record.WithCorrelatorOptions(cfg.Correlator))
+	b.StartRecordingToSink(sink)
+	e := &emitter{
+		b:       b,
+		rec:     b.NewRecorder(scheme.Scheme, corev1.EventSource{Component: cfg.Component, Host: cfg.Host}),
+		sink:    sink,
+		timeout: cfg.SyncTimeout,
+	}
+	return &core{LevelEnabler: enab, emit: e}, nil
+}
+
+// eventSink creates the events through the client, and acknowledges the
+// markers of Sync in their place.
+type eventSink struct {
+	record.EventSink
+	mu      sync.Mutex
+	markers map[string]chan struct{}
+}
+
+func (s *eventSink) Create(ev *corev1.Event) (*corev1.Event, error) {
+	if ev.InvolvedObject.Kind != syncKind {
+		return s.EventSink.Create(ev)
+	}
+	s.mu.Lock()
+	if reached, ok := s.markers[ev.InvolvedObject.Name]; ok {
+		close(reached)
+		delete(s.markers, ev.InvolvedObject.Name)
+	}
+	s.mu.Unlock()
+	return ev, nil
+}
+
+// emitter is the broadcaster shared by a core and those derived from it.
+type emitter struct {
+	b       record.EventBroadcaster
+	rec     record.EventRecorder
+	sink    *eventSink
+	timeout time.Duration
+
+	// mu guards closed against the shutdown of the broadcaster, which
+	// must not be sent events once shut down.
+	mu      sync.RWMutex
+	closed  bool
+	markers uint64
+}
+
+func (e *emitter) event(ref *corev1.ObjectReference, eventType, reason, message string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.closed {
+		e.rec.Event(ref, eventType, reason, message)
+	}
+}
+
+// sync emits a marker and waits for the sink to reach it.
+func (e *emitter) sync() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return fmt.Errorf("kevent: closed")
+	}
+	e.markers++
+	name := strconv.FormatUint(e.markers, 10)
+	reached := make(chan struct{})
+	e.sink.mu.Lock()
+	e.sink.markers[name] = reached
+	e.sink.mu.Unlock()
+	e.rec.Event(&corev1.ObjectReference{Kind: syncKind, Name: name}, corev1.EventTypeNormal, "Sync", "sync")
+	e.mu.Unlock()
+
+	timer := time.NewTimer(e.timeout)
+	defer timer.Stop()
+	select {
+	case <-reached:
+		return nil
+	case <-timer.C:
+		e.sink.mu.Lock()
+		delete(e.sink.markers, name)
+		e.sink.mu.Unlock()
+		return fmt.Errorf("kevent: events still queued after %v", e.timeout)
+	}
+}
+
+// close syncs and shuts the broadcaster down. Events emitted afterwards
+// are dropped.
+func (e *emitter) close() error {
+	err := e.sync()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	e.b.Shutdown()
+	return err
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	emit *emitter
+	// ecode and object are those among the fields added with With.
+	ecode  string
+	object *common.ObjectRef
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.ecode, clone.object = scan(fields, c.ecode, c.object)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ecode, obj := scan(fields, c.ecode, c.object)
+	if ecode == "" || obj == nil || obj.Name == "" {
+		return nil
+	}
+	info, ok := common.LookupECode(ecode)
+	if !ok || !info.UserVisible {
+		return nil
+	}
+	eventType := corev1.EventTypeNormal
+	if ent.Level >= zapcore.WarnLevel {
+		eventType = corev1.EventTypeWarning
+	}
+	ref := &corev1.ObjectReference{
+		Kind:      obj.Kind,
+		Namespace: obj.Namespace,
+		Name:      obj.Name,
+		UID:       types.UID(obj.UID),
+	}
+	c.emit.event(ref, eventType, info.Reason, ent.Message)
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.emit.sync()
+}
+
+func (c *core) Close() error {
+	return c.emit.close()
+}
+
+// scan returns the explicit ecode and the object among fields, or ecode
+// and obj when fields have none.
+func scan(fields []zapcore.Field, ecode string, obj *common.ObjectRef) (string, *common.ObjectRef) {
+	for _, f := range fields {
+		switch {
+		case f.Key == common.ECodeKey && f.Type == zapcore.StringType:
+			ecode = f.String
+		case f.Key == common.ObjectKey && f.Type == zapcore.ObjectMarshalerType:
+			if ref, ok := f.Interface.(common.ObjectRef); ok {
+				obj = &ref
+			}
+		}
+	}
+	return ecode, obj
+}
@@ -0,0 +1,130 @@
+package kevent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func init() {
+	common.RegisterECode("KEVT001", common.ECodeInfo{Reason: "PoolDegraded", UserVisible: true})
+	common.RegisterECode("KEVT002", common.ECodeInfo{})
+}
+
+var pool = common.ObjectRef{Namespace: "openebs", Name: "pool-a", Kind: "CStorPoolInstance"}
+
+func events(t *testing.T, client *fake.Clientset) []corev1.Event {
+	list, err := client.CoreV1().Events(pool.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list.Items
+}
+
+func TestEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c, err := New(Config{Client: client, Component: "cspi-mgmt"}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*core).Close()
+
+	logger := zap.New(c)
+	obj := zap.Object(common.ObjectKey, pool)
+	logger.Warn("pool degraded", common.ECode("KEVT001"), obj)
+	logger.Info("not user visible", common.ECode("KEVT002"), obj)
+	logger.Info("no object", common.ECode("KEVT001"))
+	logger.With(obj).Info("pool recovered", common.ECode("KEVT001"))
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	evs := events(t, client)
+	if len(evs) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(evs), evs)
+	}
+	for _, ev := range evs {
+		if ev.Reason != "PoolDegraded" || ev.InvolvedObject.Name != pool.Name || ev.InvolvedObject.Kind != pool.Kind || ev.Source.Component != "cspi-mgmt" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		switch ev.Message {
+		case "pool degraded":
+			if ev.Type != corev1.EventTypeWarning {
+				t.Errorf("%q has type %s, want Warning", ev.Message, ev.Type)
+			}
+		case "pool recovered":
+			if ev.Type != corev1.EventTypeNormal {
+				t.Errorf("%q has type %s, want Normal", ev.Message, ev.Type)
+			}
+		default:
+			t.Errorf("unexpected event %q", ev.Message)
+		}
+	}
+}
+
+func TestSyncTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	release := make(chan struct{})
+	client.PrependReactor("create", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		<-release
+		return false, nil, nil
+	})
+	c, err := New(Config{Client: client, SyncTimeout: 50 * time.Millisecond}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*core).Close()
+
+	zap.New(c).Warn("pool degraded", common.ECode("KEVT001"), zap.Object(common.ObjectKey, pool))
+	start := time.Now()
+	if err := c.Sync(); err == nil {
+		t.Error("Sync succeeded with the event still being created")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Sync took %v", d)
+	}
+	close(release)
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if evs := events(t, client); len(evs) != 1 {
+		t.Errorf("got %d events, want 1", len(evs))
+	}
+}
+
+func TestClose(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c, err := New(Config{Client: client}, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(c)
+	obj := zap.Object(common.ObjectKey, pool)
+	logger.Warn("before close", common.ECode("KEVT001"), obj)
+	if err := c.(*core).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if evs := events(t, client); len(evs) != 1 {
+		t.Fatalf("got %d events after Close, want 1", len(evs))
+	}
+
+	logger.Warn("after close", common.ECode("KEVT001"), obj)
+	if err := c.Sync(); err == nil {
+		t.Error("Sync after Close succeeded")
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if evs := events(t, client); len(evs) != 1 {
+		t.Errorf("got %d events, want 1", len(evs))
+	}
+}
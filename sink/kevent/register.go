@@ -0,0 +1,78 @@
+package kevent
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func init() {
+	if err := common.RegisterSinkType("kevent", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a Kubernetes event sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, _ zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	// an empty kubeconfig selects the in-cluster configuration
+	rc, err := clientcmd.BuildConfigFromFlags("", sc.Params["kubeconfig"])
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Client, err = kubernetes.NewForConfig(rc); err != nil {
+		return nil, err
+	}
+	return New(cfg, enab)
+}
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		Component: params["component"],
+		Host:      params["host"],
+	}
+
+	for name, n := range map[string]*int{
+		"burst":      &cfg.Correlator.BurstSize,
+		"max-events": &cfg.Correlator.MaxEvents,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	if v := params["qps"]; v != "" {
+		qps, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("qps: %v", err)
+		}
+		cfg.Correlator.QPS = float32(qps)
+	}
+
+	if v := params["max-interval"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("max-interval: %v", err)
+		}
+		cfg.Correlator.MaxIntervalInSeconds = int(d / time.Second)
+	}
+
+	if v := params["sync-timeout"]; v != "" {
+		var err error
+		if cfg.SyncTimeout, err = time.ParseDuration(v); err != nil {
+			return cfg, fmt.Errorf("sync-timeout: %v", err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,90 @@
+package loki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strconv"
+
+	"github.com/golang/snappy"
+)
+
+// jsonPush is the JSON body of a push.
+type jsonPush struct {
+	Streams []jsonStream `json:"streams"`
+}
+
+type jsonStream struct {
+	Stream map[string]string `json:"stream"`
+	// Values holds [timestamp in nanoseconds, line] pairs.
+	Values [][2]string `json:"values"`
+}
+
+// encodeJSON renders the streams, in the order of keys, as gzipped JSON.
+func encodeJSON(keys []string, streams map[string]*stream) ([]byte, error) {
+	push := jsonPush{Streams: make([]jsonStream, 0, len(keys))}
+	for _, key := range keys {
+		s := streams[key]
+		js := jsonStream{Stream: s.labels, Values: make([][2]string, len(s.entries))}
+		for i, e := range s.entries {
+			js.Values[i] = [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line}
+		}
+		push.Streams = append(push.Streams, js)
+	}
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if err := json.NewEncoder(zw).Encode(push); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// encodeProtobuf renders the streams, in the order of keys, as a
+// snappy-compressed logproto.PushRequest:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodeProtobuf(keys []string, streams map[string]*stream) ([]byte, error) {
+	var req, st, ent, ts []byte
+	for _, key := range keys {
+		s := streams[key]
+		st = appendBytesField(st[:0], 1, []byte(key))
+		for _, e := range s.entries {
+			ts = appendVarintField(ts[:0], 1, uint64(e.ts.Unix()))
+			ts = appendVarintField(ts, 2, uint64(e.ts.Nanosecond()))
+			ent = appendBytesField(ent[:0], 1, ts)
+			ent = appendBytesField(ent, 2, []byte(e.line))
+			st = appendBytesField(st, 2, ent)
+		}
+		req = appendBytesField(req, 1, st)
+	}
+	return snappy.Encode(nil, req), nil
+}
+
+// appendVarintField appends a field of wire type 0.
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+// appendBytesField appends a field of wire type 2: strings, bytes and
+// embedded messages.
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -0,0 +1,379 @@
+// Package loki provides a sink pushing the records of the mlogger core to
+// Grafana Loki over HTTP, without an agent in between.
+//
+// Records are encoded with the encoder of the sink into log lines and
+// batched into streams by their labels: the static labels of the sink, a
+// job label naming the program by default, and labels promoted from the
+// records, the leading components of the ecode and the logger and pool
+// fields by default. Since every label value makes a stream, the number of
+// values of each promoted label is limited within a window of time; values
+// beyond the limit are replaced by OverflowValue until the window ends. Batches are pushed as gzipped JSON or as
+// snappy-compressed protobuf from a background goroutine, and retried with
+// exponential backoff when Loki is unreachable or overloaded.
+//
+// Importing the package registers the "loki" sink type, configured through
+// SinkConfig.Params:
+//
+//	url               push endpoint, such as http://loki:3100/loki/api/v1/push
+//	tenant-id         X-Scope-OrgID of the pushes
+//	username, password  basic authentication
+//	labels            static labels, as job=maya,env=prod
+//	label-fields      fields promoted to labels; logger,pool by default
+//	ecode-depth       components of the ecode promoted as the ecode label;
+//	                  2 by default, 0 to not promote it
+//	max-label-values  values of each promoted label; 64 by default
+//	label-values-window  window of max-label-values; 1h by default
+//	format            json (default) or protobuf
+//	batch-size, batch-bytes, batch-wait  bounds of a batch; 1024 records,
+//	                  1MiB and 1s by default
+//	queue-size        records waiting for the pusher; 8192 by default
+//	buffer-limit      bytes of batches waiting to be retried; 16MiB by default
+//	min-backoff, max-backoff, max-retries  retry policy
+//	timeout           timeout of a push; 10s by default
+//	sync-timeout      bound of Sync; 5s by default
+package loki
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"github.com/mayadata-io/mlogger/sink/internal/batch"
+	"go.uber.org/zap/zapcore"
+)
+
+// Format is the payload format of the pushes.
+type Format int
+
+const (
+	// JSON pushes gzipped JSON.
+	JSON Format = iota
+	// Protobuf pushes snappy-compressed protobuf, as Promtail does.
+	Protobuf
+)
+
+// PushPath is the path of the push endpoint, used when Config.URL has
+// none.
+const PushPath = "/loki/api/v1/push"
+
+// OverflowValue replaces the values of a promoted label beyond
+// Config.MaxLabelValues in a Config.LabelValuesWindow.
+const OverflowValue = "__overflow__"
+
+// ECodeLabel is the label the ecode prefix is promoted to.
+const ECodeLabel = "ecode"
+
+// Config describes a Loki sink.
+type Config struct {
+	// URL is the push endpoint. When it has no path, PushPath is used.
+	URL string
+	// TenantID is sent as X-Scope-OrgID to multi-tenant Loki.
+	TenantID string
+	// Username and Password enable basic authentication.
+	Username string
+	Password string
+	// Labels are added to every stream. When nil, a job label names the
+	// program.
+	Labels map[string]string
+	// LabelFields lists the keys of the fields promoted to labels. The
+	// logger name is available as common.LoggerKey. When nil, the logger
+	// and pool fields are promoted.
+	LabelFields []string
+	// ECodeDepth is the number of dotted components of the ecode promoted
+	// to ECodeLabel: with 2, "maya.volume.create.go:42" is promoted as
+	// "maya.volume". Negative disables it; 0 selects the default of 2.
+	ECodeDepth int
+	// MaxLabelValues bounds the number of values of each promoted label
+	// seen in a LabelValuesWindow.
+	MaxLabelValues    int
+	LabelValuesWindow time.Duration
+	// MaxLabelValueLength truncates longer label values, in bytes and on a
+	// rune boundary.
+	MaxLabelValueLength int
+	Format              Format
+	// BatchSize, BatchBytes and BatchWait bound a batch in records, in
+	// bytes of log lines and in the time its oldest record waits.
+	BatchSize  int
+	BatchBytes int
+	BatchWait  time.Duration
+	// QueueSize is the number of records waiting for the pusher beyond
+	// which records are dropped.
+	QueueSize int
+	// BufferLimit is the size in bytes of the batches waiting to be
+	// retried beyond which batches are dropped.
+	BufferLimit int64
+	// MinBackoff and MaxBackoff bound the delay between attempts, which
+	// doubles after each failure. A batch is dropped after MaxRetries
+	// failed retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	MaxRetries int
+	// Timeout bounds each push.
+	Timeout time.Duration
+	// SyncTimeout bounds Sync.
+	SyncTimeout time.Duration
+	// Client sends the pushes; http.DefaultTransport is used when nil.
+	Client *http.Client
+}
+
+// New returns a core pushing records at the levels enab enables to the
+// Loki described by cfg, encoded into log lines with enc.
+//
+// Records dropped because the queue or the buffer is full, or because Loki
+// rejected them, and batches still waiting to be retried are reported by
+// Sync, which also pushes the pending batches without waiting for the
+// backoff to expire, for up to cfg.SyncTimeout. The core implements
+// io.Closer; closing it stops the pusher.
+func New(cfg Config, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("loki: no URL")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("loki: %v", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = PushPath
+		cfg.URL = u.String()
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("loki: no encoder")
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{"job": filepath.Base(os.Args[0])}
+	}
+	if cfg.LabelFields == nil {
+		cfg.LabelFields = []string{common.LoggerKey, "pool"}
+	}
+	if cfg.ECodeDepth == 0 {
+		cfg.ECodeDepth = 2
+	}
+	if cfg.MaxLabelValues <= 0 {
+		cfg.MaxLabelValues = 64
+	}
+	if cfg.LabelValuesWindow <= 0 {
+		cfg.LabelValuesWindow = time.Hour
+	}
+	if cfg.MaxLabelValueLength <= 0 {
+		cfg.MaxLabelValueLength = 128
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1024
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = 1 << 20
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.BufferLimit == 0 {
+		cfg.BufferLimit = 16 << 20
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 10
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.SyncTimeout == 0 {
+		cfg.SyncTimeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+
+	static := make(map[string]string, len(cfg.Labels))
+	for name, value := range cfg.Labels {
+		static[labelName(name)] = value
+	}
+	promoted := make(map[string]string, len(cfg.LabelFields))
+	for _, key := range cfg.LabelFields {
+		promoted[key] = labelName(key)
+	}
+	return &core{
+		LevelEnabler: enab,
+		enc:          enc,
+		promoted:     promoted,
+		ecodeDepth:   cfg.ECodeDepth,
+		push:         startPusher(cfg, static),
+	}, nil
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	// promoted maps the keys of the fields promoted to labels to the
+	// name of their label.
+	promoted   map[string]string
+	ecodeDepth int
+	push       *batch.Sender
+	// labels holds the labels promoted from the fields added with With.
+	labels map[string]string
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	clone.labels = c.promote(c.labels, fields)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimRight(buf.String(), "\n")
+	buf.Free()
+
+	labels := c.promote(c.labels, fields)
+	if ent.LoggerName != "" {
+		if name, ok := c.promoted[common.LoggerKey]; ok {
+			labels = withLabel(labels, name, ent.LoggerName)
+		}
+	}
+	if c.ecodeDepth > 0 {
+		ecode := labels[ECodeLabel]
+		if ecode == "" && ent.Caller.Defined {
+			ecode = common.PackagePath(ent.Caller, 3)
+		}
+		if ecode != "" {
+			labels = withLabel(labels, ECodeLabel, ecodePrefix(ecode, c.ecodeDepth))
+		}
+	}
+	c.push.Enqueue(record{labels: labels, ts: ent.Time, line: line})
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.push.Sync()
+}
+
+func (c *core) Close() error {
+	return c.push.Close()
+}
+
+// promote returns labels with the values of the promoted fields and of the
+// ecode among fields added, copying labels when it changes.
+func (c *core) promote(labels map[string]string, fields []zapcore.Field) map[string]string {
+	for _, f := range fields {
+		name, ok := c.promoted[f.Key]
+		if f.Key == common.ECodeKey && c.ecodeDepth > 0 {
+			// the full ecode, cut by Write once the caller is known
+			name, ok = ECodeLabel, true
+		}
+		if !ok {
+			continue
+		}
+		if v := fieldValue(f); v != "" {
+			labels = withLabel(labels, name, v)
+		}
+	}
+	return labels
+}
+
+// withLabel returns a copy of labels with name set to value.
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[name] = value
+	return copied
+}
+
+// fieldValue renders the value of a field as a label value.
+func fieldValue(f zapcore.Field) string {
+	if f.Type == zapcore.StringType {
+		return f.String
+	}
+	m := zapcore.NewMapObjectEncoder()
+	f.AddTo(m)
+	if v, ok := m.Fields[f.Key]; ok {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// ecodePrefix returns the first depth dotted components of the ecode,
+// leaving out the line.
+func ecodePrefix(ecode string, depth int) string {
+	if i := strings.IndexByte(ecode, ':'); i >= 0 {
+		ecode = ecode[:i]
+	}
+	parts := strings.SplitN(ecode, ".", depth+1)
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, ".")
+}
+
+// labelName turns a key into a label name: letters, digits and
+// underscores, not starting with a digit.
+func labelName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "_"
+	}
+	return string(name)
+}
+
+// formatLabels renders labels in the syntax of Loki stream selectors,
+// {name="value", ...} sorted by name, which identifies the stream.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,215 @@
+package loki
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// lokiServer is a push endpoint recording the streams pushed to it.
+type lokiServer struct {
+	mu      sync.Mutex
+	status  int
+	pushes  int
+	tenant  string
+	streams []jsonStream
+}
+
+func (s *lokiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pushes++
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	if r.URL.Path != PushPath || r.Header.Get("Content-Encoding") != "gzip" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	s.tenant = r.Header.Get("X-Scope-OrgID")
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var push jsonPush
+	if err := json.NewDecoder(zr).Decode(&push); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.streams = append(s.streams, push.Streams...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *lokiServer) set(status int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+// lines returns the lines pushed by the values of the label name.
+func (s *lokiServer) lines(name string) map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make(map[string][]string)
+	for _, st := range s.streams {
+		for _, v := range st.Values {
+			lines[st.Stream[name]] = append(lines[st.Stream[name]], v[1])
+		}
+	}
+	return lines
+}
+
+func newTestCore(t *testing.T, cfg Config) zapcore.Core {
+	cfg.BatchWait = time.Hour
+	cfg.MinBackoff = time.Millisecond
+	cfg.MaxBackoff = time.Millisecond
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(cfg, enc, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPush(t *testing.T) {
+	srv := &lokiServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := newTestCore(t, Config{URL: ts.URL, TenantID: "maya", Labels: map[string]string{"job": "test"}})
+	defer c.(io.Closer).Close()
+
+	log := zap.New(c)
+	log.Info("created", zap.String("pool", "p1"))
+	log.With(zap.String("pool", "p2")).Info("deleted")
+	log.Debug("disabled")
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := srv.lines("pool")
+	if len(lines["p1"]) != 1 || len(lines["p2"]) != 1 || len(lines) != 2 {
+		t.Fatalf("lines by pool = %v", lines)
+	}
+	if lines["p1"][0] != `{"msg":"created","pool":"p1"}` {
+		t.Errorf("line = %s", lines["p1"][0])
+	}
+	if jobs := srv.lines("job"); len(jobs["test"]) != 2 {
+		t.Errorf("lines by job = %v", jobs)
+	}
+	if srv.tenant != "maya" {
+		t.Errorf("tenant = %q", srv.tenant)
+	}
+}
+
+func TestLabelValuesWindow(t *testing.T) {
+	srv := &lokiServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := newTestCore(t, Config{URL: ts.URL, MaxLabelValues: 1, LabelValuesWindow: 50 * time.Millisecond})
+	defer c.(io.Closer).Close()
+
+	log := zap.New(c)
+	log.Info("a", zap.String("pool", "p1"))
+	log.Info("b", zap.String("pool", "p2"))
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	log.Info("c", zap.String("pool", "p2"))
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := srv.lines("pool")
+	if len(lines["p1"]) != 1 || len(lines[OverflowValue]) != 1 || len(lines["p2"]) != 1 {
+		t.Errorf("lines by pool = %v", lines)
+	}
+}
+
+func TestLabelTruncation(t *testing.T) {
+	srv := &lokiServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	// a negative wait falls back to the default rather than panicking
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(Config{URL: ts.URL, BatchWait: -time.Second, MaxLabelValueLength: 4}, enc, zapcore.InfoLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(io.Closer).Close()
+
+	log := zap.New(c)
+	log.Info("a", zap.String("pool", "abcé"))
+	log.Info("b", zap.String("pool", "ééé"))
+	log.Info("c", zap.String("pool", "abcdef"))
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := srv.lines("pool")
+	if len(lines["abc"]) != 1 || len(lines["éé"]) != 1 || len(lines["abcd"]) != 1 {
+		t.Errorf("lines by pool = %v", lines)
+	}
+}
+
+func TestRetryAndDrop(t *testing.T) {
+	srv := &lokiServer{status: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	c := newTestCore(t, Config{URL: ts.URL, MaxRetries: 5})
+	defer c.(io.Closer).Close()
+
+	log := zap.New(c)
+	log.Info("kept")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "1 batches pending") {
+		t.Fatalf("Sync = %v", err)
+	}
+	srv.set(0)
+	time.Sleep(5 * time.Millisecond)
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := srv.lines("job"); len(lines) != 1 {
+		t.Errorf("lines = %v", lines)
+	}
+
+	srv.set(http.StatusBadRequest)
+	log.Info("rejected")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "1 records dropped") {
+		t.Errorf("Sync = %v", err)
+	}
+}
+
+func TestSyncIsBounded(t *testing.T) {
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+	cfg := Config{URL: ts.URL, SyncTimeout: 50 * time.Millisecond}
+	c := newTestCore(t, cfg)
+
+	zap.New(c).Info("stuck")
+	start := time.Now()
+	if err := c.Sync(); err == nil {
+		t.Error("Sync succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Sync took %v", d)
+	}
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,182 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mayadata-io/mlogger/sink/internal/batch"
+)
+
+// record is an encoded record waiting for the pusher, with the labels
+// promoted from it.
+type record struct {
+	labels map[string]string
+	ts     time.Time
+	line   string
+}
+
+// entry is a line of a stream.
+type entry struct {
+	ts   time.Time
+	line string
+}
+
+// stream gathers the entries of a batch with the same labels.
+type stream struct {
+	labels  map[string]string
+	entries []entry
+}
+
+// pusher builds the batches of the sender pushing to Loki. It is used
+// from the goroutine of the sender only.
+type pusher struct {
+	cfg    Config
+	static map[string]string
+
+	// streams holds the batch being built, by formatted labels.
+	streams map[string]*stream
+	n       int
+	size    int
+	// values holds the values seen for each promoted label since
+	// valuesSince, to limit their number.
+	values      map[string]map[string]bool
+	valuesSince time.Time
+}
+
+func startPusher(cfg Config, static map[string]string) *batch.Sender {
+	p := &pusher{
+		cfg:     cfg,
+		static:  static,
+		streams: make(map[string]*stream),
+	}
+	return batch.Start(batch.Config{
+		Name:          "loki",
+		QueueSize:     cfg.QueueSize,
+		FlushInterval: cfg.BatchWait,
+		MaxRetries:    cfg.MaxRetries,
+		MinBackoff:    cfg.MinBackoff,
+		MaxBackoff:    cfg.MaxBackoff,
+		PendingLimit:  cfg.BufferLimit,
+		SyncTimeout:   cfg.SyncTimeout,
+		Post:          p.post,
+	}, p)
+}
+
+func (p *pusher) Add(item interface{}) bool {
+	p.add(item.(record))
+	return p.n >= p.cfg.BatchSize || p.size >= p.cfg.BatchBytes
+}
+
+func (p *pusher) add(r record) {
+	labels := p.labels(r.labels)
+	key := formatLabels(labels)
+	s := p.streams[key]
+	if s == nil {
+		s = &stream{labels: labels}
+		p.streams[key] = s
+	}
+	s.entries = append(s.entries, entry{ts: r.ts, line: r.line})
+	p.n++
+	p.size += len(r.line)
+}
+
+// labels returns the labels of a stream: the static labels and the
+// promoted ones, truncated and limited in number of values.
+func (p *pusher) labels(promoted map[string]string) map[string]string {
+	if now := time.Now(); now.Sub(p.valuesSince) >= p.cfg.LabelValuesWindow {
+		// values that stopped appearing no longer take room
+		p.values, p.valuesSince = make(map[string]map[string]bool), now
+	}
+	labels := make(map[string]string, len(p.static)+len(promoted))
+	for name, value := range promoted {
+		if _, ok := p.static[name]; ok {
+			continue
+		}
+		value = truncate(value, p.cfg.MaxLabelValueLength)
+		seen := p.values[name]
+		if seen == nil {
+			seen = make(map[string]bool)
+			p.values[name] = seen
+		}
+		if !seen[value] {
+			if len(seen) >= p.cfg.MaxLabelValues {
+				value = OverflowValue
+			} else {
+				seen[value] = true
+			}
+		}
+		labels[name] = value
+	}
+	for name, value := range p.static {
+		labels[name] = value
+	}
+	return labels
+}
+
+// truncate shortens s to at most max bytes without splitting a rune.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// Flush encodes the batch being built.
+func (p *pusher) Flush() ([]byte, int, error) {
+	if p.n == 0 {
+		return nil, 0, nil
+	}
+	keys := make([]string, 0, len(p.streams))
+	for key, s := range p.streams {
+		// entries of a stream must be in order for Loki before 2.4; they
+		// may not be when logged from several goroutines
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].ts.Before(s.entries[j].ts)
+		})
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var body []byte
+	var err error
+	if p.cfg.Format == Protobuf {
+		body, err = encodeProtobuf(keys, p.streams)
+	} else {
+		body, err = encodeJSON(keys, p.streams)
+	}
+	n := p.n
+	p.streams, p.n, p.size = make(map[string]*stream), 0, 0
+	return body, n, err
+}
+
+// post sends a push request.
+func (p *pusher) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	if p.cfg.Format == Protobuf {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("User-Agent", "mlogger")
+	if p.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.cfg.TenantID)
+	}
+	if p.cfg.Username != "" {
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+	return batch.Do(p.cfg.Client, req)
+}
@@ -0,0 +1,111 @@
+package loki
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("loki", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a Loki sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, enc, enab)
+}
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		URL:      params["url"],
+		TenantID: params["tenant-id"],
+		Username: params["username"],
+		Password: params["password"],
+	}
+
+	if v, ok := params["labels"]; ok {
+		cfg.Labels = make(map[string]string)
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			eq := strings.IndexByte(item, '=')
+			if eq <= 0 {
+				return cfg, fmt.Errorf("labels: %q is not name=value", item)
+			}
+			cfg.Labels[strings.TrimSpace(item[:eq])] = strings.TrimSpace(item[eq+1:])
+		}
+	}
+	if v, ok := params["label-fields"]; ok {
+		cfg.LabelFields = []string{}
+		for _, key := range strings.Split(v, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				cfg.LabelFields = append(cfg.LabelFields, key)
+			}
+		}
+	}
+
+	switch params["format"] {
+	case "", "json":
+	case "protobuf":
+		cfg.Format = Protobuf
+	default:
+		return cfg, fmt.Errorf("unknown format %q", params["format"])
+	}
+
+	for name, n := range map[string]*int{
+		"ecode-depth":      &cfg.ECodeDepth,
+		"max-label-values": &cfg.MaxLabelValues,
+		"batch-size":       &cfg.BatchSize,
+		"batch-bytes":      &cfg.BatchBytes,
+		"queue-size":       &cfg.QueueSize,
+		"max-retries":      &cfg.MaxRetries,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	if params["ecode-depth"] == "0" {
+		// 0 selects the default in Config
+		cfg.ECodeDepth = -1
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"label-values-window": &cfg.LabelValuesWindow,
+		"batch-wait":          &cfg.BatchWait,
+		"min-backoff":         &cfg.MinBackoff,
+		"max-backoff":         &cfg.MaxBackoff,
+		"timeout":             &cfg.Timeout,
+		"sync-timeout":        &cfg.SyncTimeout,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	if v := params["buffer-limit"]; v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("buffer-limit: %v", err)
+		}
+		cfg.BufferLimit = limit
+	}
+	return cfg, nil
+}
@@ -0,0 +1,130 @@
+package syslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// localSockets are the usual paths of the local syslog socket.
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// conn is the connection to the server. It is dialed on first use and
+// redialed once when sending fails, so that a restarted server does not
+// lose the sink.
+type conn struct {
+	cfg Config
+
+	mu sync.Mutex
+	c  net.Conn
+	// stream is set on connections needing framing, framed as framing.
+	stream  bool
+	framing Framing
+	closed  bool
+}
+
+var errClosed = errors.New("syslog: closed")
+
+func (c *conn) send(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errClosed
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.c == nil {
+			if err = c.dial(); err != nil {
+				continue
+			}
+		}
+		c.c.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+		if _, err = c.c.Write(c.frame(msg)); err == nil {
+			return nil
+		}
+		c.c.Close()
+		c.c = nil
+	}
+	return err
+}
+
+// frame applies the framing of stream transports to msg.
+func (c *conn) frame(msg []byte) []byte {
+	if !c.stream {
+		return msg
+	}
+	if c.framing == NonTransparent {
+		// a line feed would end the frame early; escape it the way
+		// rsyslog escapes control characters
+		if bytes.IndexByte(msg, '\n') >= 0 {
+			msg = bytes.Replace(msg, []byte{'\n'}, []byte("#012"), -1)
+		}
+		return append(msg, '\n')
+	}
+	framed := make([]byte, 0, len(msg)+8)
+	framed = strconv.AppendInt(framed, int64(len(msg)), 10)
+	framed = append(framed, ' ')
+	return append(framed, msg...)
+}
+
+func (c *conn) dial() error {
+	d := net.Dialer{Timeout: c.cfg.DialTimeout}
+	c.framing = c.cfg.Framing
+	var err error
+	switch c.cfg.Network {
+	case "unix":
+		c.c, err = c.dialLocal(d)
+		// local daemons split a unix stream socket on newlines, and
+		// do not know octet counting
+		c.stream = err == nil && c.c.RemoteAddr().Network() == "unix"
+		c.framing = NonTransparent
+	case "tcp+tls":
+		c.c, err = tls.DialWithDialer(&d, "tcp", c.cfg.Address, c.cfg.TLS)
+		c.stream = true
+	default:
+		c.c, err = d.Dial(c.cfg.Network, c.cfg.Address)
+		c.stream = c.cfg.Network == "tcp"
+	}
+	if err != nil {
+		c.c = nil
+	}
+	return err
+}
+
+// close closes the connection; sending afterwards fails.
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.c == nil {
+		return nil
+	}
+	err := c.c.Close()
+	c.c = nil
+	return err
+}
+
+// dialLocal connects to the local daemon, trying datagram then stream
+// sockets, at cfg.Address or the usual paths.
+func (c *conn) dialLocal(d net.Dialer) (net.Conn, error) {
+	paths := localSockets
+	if c.cfg.Address != "" {
+		paths = []string{c.cfg.Address}
+	}
+	for _, path := range paths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := d.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("syslog: no local syslog socket found")
+}
@@ -0,0 +1,83 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("syslog", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a syslog sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, enc, enab)
+}
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		Network:  params["network"],
+		Address:  params["address"],
+		Facility: User,
+		Tag:      params["tag"],
+		Hostname: params["hostname"],
+	}
+
+	switch params["format"] {
+	case "", "rfc5424":
+	case "rfc3164":
+		cfg.Format = RFC3164
+	default:
+		return cfg, fmt.Errorf("unknown format %q", params["format"])
+	}
+
+	switch params["framing"] {
+	case "", "octet-counting":
+	case "non-transparent":
+		cfg.Framing = NonTransparent
+	default:
+		return cfg, fmt.Errorf("unknown framing %q", params["framing"])
+	}
+
+	if name := params["facility"]; name != "" {
+		f, ok := facilities[name]
+		if !ok {
+			return cfg, fmt.Errorf("unknown facility %q", name)
+		}
+		cfg.Facility = f
+	}
+
+	if cfg.Network == "tcp+tls" {
+		cfg.TLS = &tls.Config{ServerName: params["tls-server-name"]}
+		if v := params["tls-insecure-skip-verify"]; v != "" {
+			skip, err := strconv.ParseBool(v)
+			if err != nil {
+				return cfg, fmt.Errorf("tls-insecure-skip-verify: %v", err)
+			}
+			cfg.TLS.InsecureSkipVerify = skip
+		}
+		if file := params["tls-ca-file"]; file != "" {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.TLS.RootCAs = x509.NewCertPool()
+			if !cfg.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return cfg, fmt.Errorf("tls-ca-file: no certificates in %s", file)
+			}
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,379 @@
+// Package syslog provides a sink sending the records of the mlogger core
+// to a syslog server, in RFC 5424 or RFC 3164 format, over a local socket,
+// UDP, TCP or TCP with TLS.
+//
+// Importing the package registers the "syslog" sink type, configured
+// through SinkConfig.Params:
+//
+//	network   unix (default), udp, tcp or tcp+tls
+//	address   server address; /dev/log and friends by default for unix
+//	format    rfc5424 (default) or rfc3164
+//	framing   octet-counting (default) or non-transparent, for TCP; local
+//	          stream sockets are always newline terminated
+//	facility  kern, user (default), daemon, local0 to local7, ...
+//	tag       APP-NAME or TAG; the program name by default
+//	hostname  HOSTNAME; the local host name by default
+//	tls-ca-file, tls-server-name, tls-insecure-skip-verify
+package syslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+// Format is the syslog message format.
+type Format int
+
+const (
+	// RFC5424 is the structured syslog format; fields are sent as
+	// structured data.
+	RFC5424 Format = iota
+	// RFC3164 is the legacy BSD format; fields are sent in the message,
+	// encoded with the encoder of the sink.
+	RFC3164
+)
+
+// Framing separates messages on stream transports (RFC 6587).
+type Framing int
+
+const (
+	// OctetCounting prefixes each message with its length.
+	OctetCounting Framing = iota
+	// NonTransparent terminates each message with a newline. Line feeds
+	// within messages are sent as #012.
+	NonTransparent
+)
+
+// Facility is the syslog facility messages are sent with.
+type Facility int
+
+// Facilities, as numbered by RFC 5424.
+const (
+	Kern Facility = iota
+	User
+	Mail
+	Daemon
+	Auth
+	Syslog
+	LPR
+	News
+	UUCP
+	Cron
+	AuthPriv
+	FTP
+	Local0 Facility = iota + 4
+	Local1
+	Local2
+	Local3
+	Local4
+	Local5
+	Local6
+	Local7
+)
+
+var facilities = map[string]Facility{
+	"kern": Kern, "user": User, "mail": Mail, "daemon": Daemon,
+	"auth": Auth, "syslog": Syslog, "lpr": LPR, "news": News,
+	"uucp": UUCP, "cron": Cron, "authpriv": AuthPriv, "ftp": FTP,
+	"local0": Local0, "local1": Local1, "local2": Local2, "local3": Local3,
+	"local4": Local4, "local5": Local5, "local6": Local6, "local7": Local7,
+}
+
+// SDID is the ID of the structured data element fields are sent in. 32473
+// is the private enterprise number reserved for documentation.
+const SDID = "mlogger@32473"
+
+// Config describes a syslog sink.
+type Config struct {
+	// Network is "unix", "udp", "tcp" or "tcp+tls".
+	Network string
+	// Address is the address of the server. For "unix" it defaults to the
+	// first of the usual local syslog sockets that accepts a connection.
+	Address string
+	Format  Format
+	// Framing applies to "tcp" and "tcp+tls". Messages sent to a local
+	// stream socket are terminated with a newline, as local daemons
+	// expect.
+	Framing  Framing
+	Facility Facility
+	// Tag is the APP-NAME (RFC 5424) or TAG (RFC 3164) of the messages.
+	Tag string
+	// Hostname is the HOSTNAME of the messages.
+	Hostname string
+	// TLS configures "tcp+tls".
+	TLS *tls.Config
+	// DialTimeout and WriteTimeout bound connecting and sending a message.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// New returns a core sending records at the levels enab enables to the
+// server described by cfg. enc encodes the message of RFC 3164 records
+// and may be nil for RFC 5424. The connection is established on first use
+// and re-established after a failure. The core implements io.Closer.
+func New(cfg Config, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.Network == "" {
+		cfg.Network = "unix"
+	}
+	switch cfg.Network {
+	case "unix", "udp", "tcp", "tcp+tls":
+	default:
+		return nil, fmt.Errorf("syslog: unknown network %q", cfg.Network)
+	}
+	if cfg.Network != "unix" && cfg.Address == "" {
+		return nil, fmt.Errorf("syslog: no address for network %q", cfg.Network)
+	}
+	if cfg.Format == RFC3164 && enc == nil {
+		return nil, fmt.Errorf("syslog: RFC 3164 needs an encoder")
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = filepath.Base(os.Args[0])
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 5 * time.Second
+	}
+	return &core{
+		LevelEnabler: enab,
+		cfg:          cfg,
+		enc:          enc,
+		conn:         &conn{cfg: cfg},
+		pid:          os.Getpid(),
+	}, nil
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	cfg    Config
+	enc    zapcore.Encoder
+	conn   *conn
+	pid    int
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	if c.enc != nil {
+		clone.enc = c.enc.Clone()
+		for _, f := range fields {
+			f.AddTo(clone.enc)
+		}
+	}
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var msg []byte
+	var err error
+	if c.cfg.Format == RFC3164 {
+		msg, err = c.format3164(ent, fields)
+	} else {
+		msg = c.format5424(ent, fields)
+	}
+	if err != nil {
+		return err
+	}
+	return c.conn.send(msg)
+}
+
+func (c *core) Sync() error {
+	return nil
+}
+
+// Close closes the connection to the server.
+func (c *core) Close() error {
+	return c.conn.close()
+}
+
+// priority returns the PRI of a record.
+func (c *core) priority(level zapcore.Level) int {
+	return int(c.cfg.Facility)*8 + severity(level)
+}
+
+// severity maps a zap level onto a syslog severity. Levels above ErrorLevel,
+// fatal ones included, are critical: emergency and alert are for
+// conditions of the whole system, not of one process. Levels below
+// DebugLevel are debug.
+func severity(level zapcore.Level) int {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return 7
+	case level == zapcore.InfoLevel:
+		return 6
+	case level == zapcore.WarnLevel:
+		return 4
+	case level == zapcore.ErrorLevel:
+		return 3
+	}
+	return 2
+}
+
+// format5424 renders a record as
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ELEMENT] MSG
+//
+// with the fields, the ecode and the logger name as SD-PARAMs.
+func (c *core) format5424(ent zapcore.Entry, fields []zapcore.Field) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ",
+		c.priority(ent.Level),
+		ent.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(c.cfg.Hostname, 255),
+		headerField(c.cfg.Tag, 48),
+		c.pid,
+	)
+
+	params := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(params)
+	}
+	for _, f := range fields {
+		f.AddTo(params)
+	}
+	if _, ok := params.Fields[common.ECodeKey]; !ok && ent.Caller.Defined {
+		params.Fields[common.ECodeKey] = common.PackagePath(ent.Caller, 3)
+	}
+	if ent.LoggerName != "" {
+		params.Fields[common.LoggerKey] = ent.LoggerName
+	}
+	if ent.Stack != "" {
+		params.Fields["stacktrace"] = ent.Stack
+	}
+
+	if len(params.Fields) == 0 {
+		b.WriteByte('-')
+	} else {
+		keys := make([]string, 0, len(params.Fields))
+		for k := range params.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("[" + SDID)
+		for _, k := range keys {
+			b.WriteByte(' ')
+			b.WriteString(paramName(k))
+			b.WriteString(`="`)
+			writeParamValue(&b, params.Fields[k])
+			b.WriteByte('"')
+		}
+		b.WriteByte(']')
+	}
+	b.WriteByte(' ')
+	b.WriteString(ent.Message)
+	return b.Bytes()
+}
+
+// format3164 renders a record as
+//
+//	<PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+//
+// where MSG is the record as encoded by the encoder of the sink. Local
+// daemons add the hostname themselves, so it is left out on unix sockets.
+func (c *core) format3164(ent zapcore.Entry, fields []zapcore.Field) ([]byte, error) {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>%s ", c.priority(ent.Level), ent.Time.Format(time.Stamp))
+	if c.cfg.Network != "unix" {
+		b.WriteString(headerField(c.cfg.Hostname, 255))
+		b.WriteByte(' ')
+	}
+	fmt.Fprintf(&b, "%s[%d]: ", c.cfg.Tag, c.pid)
+	b.Write(bytes.TrimRight(buf.Bytes(), "\n"))
+	return b.Bytes(), nil
+}
+
+// headerField returns s as a header field of at most max printable ASCII
+// characters, or the nil value "-".
+func headerField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// paramName returns key as a valid PARAM-NAME: at most 32 printable ASCII
+// characters other than '=', ' ', ']' and '"'.
+func paramName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if name == "" {
+		return "_"
+	}
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// writeParamValue writes v as a PARAM-VALUE, escaping '"', '\' and ']'.
+func writeParamValue(b *bytes.Buffer, v interface{}) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case fmt.Stringer:
+		s = v.String()
+	case error:
+		s = v.Error()
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case map[string]interface{}, []interface{}:
+		// nested objects and arrays
+		if j, err := json.Marshal(v); err == nil {
+			s = string(j)
+		} else {
+			s = fmt.Sprint(v)
+		}
+	default:
+		s = fmt.Sprint(v)
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\\', ']':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+}
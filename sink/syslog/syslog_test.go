@@ -0,0 +1,206 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newTestCore(t *testing.T, cfg Config) zapcore.Core {
+	cfg.Tag = "test"
+	cfg.Hostname = "host"
+	enc := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(cfg, enc, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// checkRFC5424 checks that msg is the record logged by logRecord.
+func checkRFC5424(t *testing.T, msg string) {
+	// kern is 0, info 6
+	prefix := "<6>1 "
+	suffix := fmt.Sprintf(" host test %d - [%s pool=\"a\\]\"] created", os.Getpid(), SDID)
+	if !strings.HasPrefix(msg, prefix) || !strings.HasSuffix(msg, suffix) {
+		t.Errorf("got %q, want %q...%q", msg, prefix, suffix)
+	}
+}
+
+func logRecord(c zapcore.Core) {
+	zap.New(c).Info("created", zap.String("pool", "a]"))
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestUnixDatagram(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := newTestCore(t, Config{Network: "unix", Address: path})
+	defer c.(*core).Close()
+	logRecord(c)
+
+	buf := make([]byte, 4096)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRFC5424(t, string(buf[:n]))
+}
+
+func TestUnixStream(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// octet counting is not used on local sockets, whatever Framing says
+	c := newTestCore(t, Config{Network: "unix", Address: path, Framing: OctetCounting})
+	defer c.(*core).Close()
+	logRecord(c)
+	zap.New(c).Info("two\nlines", zap.String("pool", "a\nb"))
+	logRecord(c)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if i != 1 {
+			checkRFC5424(t, line)
+		} else if want := `[` + SDID + ` pool="a#012b"] two#012lines`; !strings.HasSuffix(line, want) {
+			t.Errorf("got %q, want line feeds escaped", line)
+		}
+	}
+}
+
+func TestTCPOctetCounting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := newTestCore(t, Config{Network: "tcp", Address: l.Addr().String()})
+	defer c.(*core).Close()
+	logRecord(c)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var n int
+	if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	checkRFC5424(t, string(msg))
+}
+
+func TestUDPRFC3164(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := newTestCore(t, Config{Network: "udp", Address: l.LocalAddr().String(), Format: RFC3164, Facility: Local0})
+	defer c.(*core).Close()
+	zap.New(c).Error("failed")
+
+	buf := make([]byte, 4096)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := l.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 is 16, error 3
+	prefix := "<131>"
+	suffix := fmt.Sprintf(" host test[%d]: failed", os.Getpid())
+	if !strings.HasPrefix(msg, prefix) || !strings.HasSuffix(msg, suffix) {
+		t.Errorf("got %q, want %q...%q", msg, prefix, suffix)
+	}
+}
+
+func TestClose(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c := newTestCore(t, Config{Network: "udp", Address: l.LocalAddr().String()})
+	if err := c.Write(zapcore.Entry{Message: "before"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(zapcore.Entry{Message: "after"}, nil); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+	if err := c.(*core).Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	for level, want := range map[zapcore.Level]int{
+		zapcore.DebugLevel - 1: 7,
+		zapcore.DebugLevel:     7,
+		zapcore.InfoLevel:      6,
+		zapcore.WarnLevel:      4,
+		zapcore.ErrorLevel:     3,
+		zapcore.DPanicLevel:    2,
+		zapcore.PanicLevel:     2,
+		zapcore.FatalLevel:     2,
+		zapcore.FatalLevel + 1: 2,
+	} {
+		if got := severity(level); got != want {
+			t.Errorf("severity(%v) = %d, want %d", level, got, want)
+		}
+	}
+}
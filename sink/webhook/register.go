@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	if err := common.RegisterSinkType("webhook", newSink); err != nil {
+		panic(err)
+	}
+}
+
+// newSink builds a webhook sink from the Params of a SinkConfig.
+func newSink(sc common.SinkConfig, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	cfg, err := parseParams(sc.Params)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, enc, enab)
+}
+
+// headerPrefix starts the params setting headers.
+const headerPrefix = "header."
+
+func parseParams(params map[string]string) (Config, error) {
+	cfg := Config{
+		URL:            params["url"],
+		Method:         params["method"],
+		DeadLetterFile: params["dead-letter-file"],
+	}
+
+	for name, value := range params {
+		if strings.HasPrefix(name, headerPrefix) {
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[strings.TrimPrefix(name, headerPrefix)] = value
+		}
+	}
+
+	for name, n := range map[string]*int{
+		"max-batch-size":    &cfg.MaxBatchSize,
+		"max-batch-bytes":   &cfg.MaxBatchBytes,
+		"queue-size":        &cfg.QueueSize,
+		"max-retries":       &cfg.MaxRetries,
+		"breaker-threshold": &cfg.BreakerThreshold,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	for name, d := range map[string]*time.Duration{
+		"max-batch-age":    &cfg.MaxBatchAge,
+		"min-backoff":      &cfg.MinBackoff,
+		"max-backoff":      &cfg.MaxBackoff,
+		"breaker-cooldown": &cfg.BreakerCooldown,
+		"timeout":          &cfg.Timeout,
+		"sync-timeout":     &cfg.SyncTimeout,
+	} {
+		if v := params[name]; v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/mayadata-io/mlogger/sink/internal/batch"
+)
+
+// lines gathers lines into an NDJSON body.
+type lines struct {
+	cfg  *Config
+	body []byte
+	n    int
+}
+
+func (l *lines) Add(item interface{}) bool {
+	l.body = append(l.body, item.([]byte)...)
+	l.n++
+	return l.n >= l.cfg.MaxBatchSize || len(l.body) >= l.cfg.MaxBatchBytes
+}
+
+func (l *lines) Flush() ([]byte, int, error) {
+	body, n := l.body, l.n
+	l.body, l.n = nil, 0
+	return body, n, nil
+}
+
+func startSender(cfg Config) *batch.Sender {
+	post := func(ctx context.Context, body []byte) (bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+		req, err := http.NewRequest(cfg.Method, cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("User-Agent", "mlogger")
+		for name, value := range cfg.Headers {
+			req.Header.Set(name, value)
+		}
+		return batch.Do(cfg.Client, req)
+	}
+	return batch.Start(batch.Config{
+		Name:             "webhook",
+		QueueSize:        cfg.QueueSize,
+		FlushInterval:    cfg.MaxBatchAge,
+		MaxRetries:       cfg.MaxRetries,
+		MinBackoff:       cfg.MinBackoff,
+		MaxBackoff:       cfg.MaxBackoff,
+		Jitter:           true,
+		PendingLimit:     cfg.PendingLimit,
+		BreakerThreshold: cfg.BreakerThreshold,
+		BreakerCooldown:  cfg.BreakerCooldown,
+		DeadLetterFile:   cfg.DeadLetterFile,
+		SyncTimeout:      cfg.SyncTimeout,
+		Post:             post,
+	}, &lines{cfg: &cfg})
+}
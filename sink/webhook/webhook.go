@@ -0,0 +1,227 @@
+// Package webhook provides a sink POSTing the records of the mlogger core
+// to an HTTP endpoint in batches of newline-delimited JSON, for
+// integrations too small to warrant a log shipper.
+//
+// Batches are sent from a background goroutine once they reach a number
+// of records, a size or an age, and retried with exponential backoff and
+// jitter. A circuit breaker stops sending after consecutive failures, for
+// a cooldown after which a single batch probes the endpoint. Batches that
+// cannot be delivered, because they were rejected, ran out of retries or
+// met an open breaker, are appended to a dead-letter file when one is
+// configured, and dropped otherwise.
+//
+// The sink is a zapcore.Core, available to Config.Sinks as the "webhook"
+// type, and a logrus hook through NewHook. The type is configured through
+// SinkConfig.Params:
+//
+//	url               endpoint
+//	method            POST by default
+//	header.<Name>     a header of the requests, as header.Authorization
+//	max-batch-size, max-batch-bytes, max-batch-age  bounds of a batch;
+//	                  500 records, 1MiB and 1s by default
+//	queue-size        records waiting for the sender; 8192 by default
+//	max-retries       retries of a batch; 5 by default, none when negative
+//	min-backoff, max-backoff  bounds of the delay between retries
+//	breaker-threshold consecutive failures opening the breaker; 5 by default
+//	breaker-cooldown  how long the breaker stays open; 30s by default
+//	dead-letter-file  file undeliverable batches are appended to
+//	timeout           timeout of a request; 10s by default
+//	sync-timeout      bound of Sync; 5s by default
+package webhook
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"github.com/mayadata-io/mlogger/logrus"
+	"github.com/mayadata-io/mlogger/sink/internal/batch"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config describes a webhook sink.
+type Config struct {
+	// URL is the endpoint batches are sent to.
+	URL string
+	// Method is the method of the requests, POST by default.
+	Method string
+	// Headers are added to the requests, such as Authorization.
+	Headers map[string]string
+	// MaxBatchSize, MaxBatchBytes and MaxBatchAge bound a batch in
+	// records, in bytes and in the time its oldest record waits.
+	MaxBatchSize  int
+	MaxBatchBytes int
+	MaxBatchAge   time.Duration
+	// QueueSize is the number of records waiting for the sender beyond
+	// which records are dropped.
+	QueueSize int
+	// MaxRetries is the number of times a batch is retried before it is
+	// given up, 5 when zero. A negative value disables retries.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the delay before a retry, which
+	// doubles after each failure and is jittered by up to half.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failed requests that
+	// opens the breaker, for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// DeadLetterFile, when set, is the file undeliverable batches are
+	// appended to.
+	DeadLetterFile string
+	// PendingLimit is the size in bytes of the batches waiting to be
+	// sent beyond which batches go to the dead-letter file.
+	PendingLimit int64
+	// Timeout bounds each request.
+	Timeout time.Duration
+	// SyncTimeout bounds Sync.
+	SyncTimeout time.Duration
+	// Client sends the requests; http.DefaultTransport is used when nil.
+	Client *http.Client
+}
+
+// New returns a core sending records at the levels enab enables to the
+// endpoint described by cfg, each encoded with enc on a line.
+//
+// Records dropped because the queue is full or because they could not be
+// delivered nor dead-lettered, and batches still pending, are reported by
+// Sync, which also sends the pending batches without waiting for the
+// backoff to expire, for up to cfg.SyncTimeout. The core implements
+// io.Closer; closing it stops the sender and closes the dead-letter file.
+func New(cfg Config, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook: no URL")
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("webhook: no encoder")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = 500
+	}
+	if cfg.MaxBatchBytes <= 0 {
+		cfg.MaxBatchBytes = 1 << 20
+	}
+	if cfg.MaxBatchAge <= 0 {
+		cfg.MaxBatchAge = time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 8192
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = 200 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.BreakerThreshold <= 0 {
+		cfg.BreakerThreshold = 5
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = 30 * time.Second
+	}
+	if cfg.PendingLimit == 0 {
+		cfg.PendingLimit = 16 << 20
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.SyncTimeout == 0 {
+		cfg.SyncTimeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+	return &core{
+		LevelEnabler: enab,
+		enc:          enc,
+		send:         startSender(cfg),
+	}, nil
+}
+
+// NewHook returns a logrus hook sending the entries at levels, or at all
+// levels when none are given, to the endpoint described by cfg, encoded
+// as JSON with the keys of common.NewEncoderConfig. Entries are redacted
+// as records of common.Logger are, as described for logrus.CoreHook. Its
+// Sync method reports as the Sync of New does, and its Close method stops
+// it.
+func NewHook(cfg Config, levels ...logrus.Level) (*Hook, error) {
+	c, err := New(cfg, zapcore.NewJSONEncoder(common.NewEncoderConfig()), zapcore.DebugLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &Hook{Hook: logrus.CoreHook(c, levels...), core: c}, nil
+}
+
+// Hook is a logrus hook sending entries to an endpoint.
+type Hook struct {
+	logrus.Hook
+	core zapcore.Core
+}
+
+// Sync sends the pending entries.
+func (h *Hook) Sync() error {
+	return h.core.Sync()
+}
+
+// Close stops sending entries. Sync first to send the pending ones.
+func (h *Hook) Close() error {
+	return h.core.(io.Closer).Close()
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	enc  zapcore.Encoder
+	send *batch.Sender
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := make([]byte, 0, buf.Len()+1)
+	line = append(line, buf.Bytes()...)
+	buf.Free()
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	c.send.Enqueue(line)
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.send.Sync()
+}
+
+func (c *core) Close() error {
+	return c.send.Close()
+}
@@ -0,0 +1,270 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mayadata-io/mlogger/common"
+	"github.com/mayadata-io/mlogger/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// endpoint is a test endpoint answering with the statuses of replies in
+// turn, then with the last one, and recording the lines it accepted.
+type endpoint struct {
+	mu       sync.Mutex
+	replies  []int
+	requests int
+	lines    []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := e.replies[len(e.replies)-1]
+	if e.requests < len(e.replies) {
+		status = e.replies[e.requests]
+	}
+	e.requests++
+	if status == http.StatusOK {
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			status = http.StatusUnsupportedMediaType
+		} else {
+			s := bufio.NewScanner(bytes.NewReader(body))
+			for s.Scan() {
+				e.lines = append(e.lines, s.Text())
+			}
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func (e *endpoint) stats() (int, []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests, append([]string(nil), e.lines...)
+}
+
+func newTestCore(t *testing.T, cfg Config) zapcore.Core {
+	cfg.MaxBatchAge = time.Hour
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = time.Millisecond
+		cfg.MaxBackoff = 2 * time.Millisecond
+	}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(cfg, enc, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func closeCore(c zapcore.Core) {
+	c.(io.Closer).Close()
+}
+
+func TestDeliver(t *testing.T) {
+	e := &endpoint{replies: []int{http.StatusOK}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := newTestCore(t, Config{URL: srv.URL})
+	defer closeCore(c)
+
+	log := zap.New(c)
+	for i := 0; i < 3; i++ {
+		log.Info("created", zap.Int("n", i))
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	requests, lines := e.stats()
+	if requests != 1 || len(lines) != 3 {
+		t.Fatalf("%d requests of %d lines, want 1 of 3", requests, len(lines))
+	}
+	if lines[2] != `{"msg":"created","n":2}` {
+		t.Errorf("line = %s", lines[2])
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	e := &endpoint{replies: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := newTestCore(t, Config{URL: srv.URL})
+	defer closeCore(c)
+
+	zap.New(c).Info("created")
+	err := c.Sync()
+	for i := 0; err != nil && i < 10; i++ {
+		if !strings.Contains(err.Error(), "batches pending") {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		err = c.Sync()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, lines := e.stats()
+	if requests != 3 || len(lines) != 1 {
+		t.Errorf("%d requests, %d lines delivered; want 3 and 1", requests, len(lines))
+	}
+}
+
+func TestBreakerAndDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dead := filepath.Join(dir, "dead.ndjson")
+
+	e := &endpoint{replies: []int{http.StatusBadGateway}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := newTestCore(t, Config{
+		URL:              srv.URL,
+		MaxRetries:       10,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+		DeadLetterFile:   dead,
+	})
+	defer closeCore(c)
+
+	log := zap.New(c)
+	log.Info("first")
+	// the retry of a sync fails too, opening the breaker
+	err = c.Sync()
+	if err == nil || !strings.Contains(err.Error(), "circuit open") || !strings.Contains(err.Error(), "1 records dead-lettered") {
+		t.Fatalf("Sync = %v", err)
+	}
+
+	// while the breaker is open, batches go to the dead-letter file
+	log.Info("second")
+	c.Sync()
+	if requests, _ := e.stats(); requests != 2 {
+		t.Errorf("%d requests, want 2", requests)
+	}
+	b, err := ioutil.ReadFile(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 2 {
+		t.Errorf("dead-letter file holds %d lines, want 2:\n%s", got, b)
+	}
+}
+
+func TestRejectedBatchIsDeadLettered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dead := filepath.Join(dir, "dead.ndjson")
+
+	e := &endpoint{replies: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := newTestCore(t, Config{URL: srv.URL, DeadLetterFile: dead})
+
+	zap.New(c).Info("refused")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Sync = %v", err)
+	}
+	if requests, _ := e.stats(); requests != 1 {
+		t.Errorf("%d requests, want 1", requests)
+	}
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(); err == nil {
+		t.Error("Sync succeeded after Close")
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dead := filepath.Join(dir, "dead.ndjson")
+
+	e := &endpoint{replies: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	// a negative age falls back to the default rather than panicking
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	c, err := New(Config{URL: srv.URL, MaxBatchAge: -time.Second, MaxRetries: -1, DeadLetterFile: dead}, enc, zapcore.DebugLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeCore(c)
+
+	zap.New(c).Info("unavailable")
+	if err := c.Sync(); err == nil || !strings.Contains(err.Error(), "1 records dead-lettered") {
+		t.Errorf("Sync = %v", err)
+	}
+	c.Sync()
+	if requests, lines := e.stats(); requests != 1 || len(lines) != 0 {
+		t.Errorf("%d requests, %d lines delivered; want 1 and none", requests, len(lines))
+	}
+}
+
+func TestSyncIsBounded(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+	c := newTestCore(t, Config{URL: srv.URL, SyncTimeout: 50 * time.Millisecond})
+	defer closeCore(c)
+
+	zap.New(c).Info("stuck")
+	start := time.Now()
+	if err := c.Sync(); err == nil {
+		t.Error("Sync succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Sync took %v", d)
+	}
+}
+
+func TestHookRedacts(t *testing.T) {
+	e := &endpoint{replies: []int{http.StatusOK}}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	cfg := common.NewConfig()
+	if err := common.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHook(Config{URL: srv.URL, MaxBatchAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	l.Hooks.Add(h)
+	l.WithField("password", "hunter2").Info("login")
+	if err := h.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	_, lines := e.stats()
+	if len(lines) != 1 || strings.Contains(lines[0], "hunter2") {
+		t.Errorf("lines = %q", lines)
+	}
+}